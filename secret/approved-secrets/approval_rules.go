@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+var approvalRuleRegex = regexp.MustCompile(`^\s*([\w.@-]+)\s*>=\s*(\d+)\s*$`)
+
+// approvalRule requires at least Min approvers of Group.
+type approvalRule struct {
+	Group string `json:"group"`
+	Min   int    `json:"min"`
+}
+
+// approvalRuleSet is satisfied if all its rules are satisfied by distinct
+// approvers, so an approver that is member of several groups only counts once.
+type approvalRuleSet []approvalRule
+
+// parseApprovalRuleSet parses a rule set like "sre>=1,security>=1".
+func parseApprovalRuleSet(s string) (approvalRuleSet, error) {
+
+	var set approvalRuleSet
+	for _, part := range strings.Split(s, ",") {
+		if strings.TrimSpace(part) == "" {
+			continue
+		}
+
+		m := approvalRuleRegex.FindStringSubmatch(part)
+		if m == nil {
+			return nil, errors.Errorf("bad approval rule %q (expected <group>>=<min>)", part)
+		}
+
+		min, err := strconv.Atoi(m[2])
+		if err != nil || min < 1 {
+			return nil, errors.Errorf("bad approval rule %q (min must be >= 1)", part)
+		}
+
+		set = append(set, approvalRule{Group: m[1], Min: min})
+	}
+
+	if len(set) == 0 {
+		return nil, errors.Errorf("empty approval rule set %q", s)
+	}
+
+	return set, nil
+}
+
+func (set approvalRuleSet) String() string {
+	rules := make([]string, 0, len(set))
+	for _, rule := range set {
+		rules = append(rules, fmt.Sprintf("%s>=%d", rule.Group, rule.Min))
+	}
+	return strings.Join(rules, ",")
+}
+
+// satisfied returns whether the approvals satisfy all rules of the set. Each
+// rule is expanded to Min slots, which are matched to approvers that were
+// member of the slot's group at approval time.
+func (set approvalRuleSet) satisfied(approvals []requestApproval) bool {
+
+	var slots []string
+	for _, rule := range set {
+		for i := 0; i < rule.Min; i++ {
+			slots = append(slots, rule.Group)
+		}
+	}
+
+	if len(slots) > len(approvals) {
+		return false
+	}
+
+	// Maximum bipartite matching of slots to approvals (augmenting paths).
+	assigned := make([]int, len(approvals))
+	for i := range assigned {
+		assigned[i] = -1
+	}
+
+	var match func(slot int, visited []bool) bool
+	match = func(slot int, visited []bool) bool {
+		for i, approval := range approvals {
+			if visited[i] || !containsFold(approval.Groups, slots[slot]) {
+				continue
+			}
+			visited[i] = true
+			if assigned[i] < 0 || match(assigned[i], visited) {
+				assigned[i] = slot
+				return true
+			}
+		}
+		return false
+	}
+
+	for slot := range slots {
+		if !match(slot, make([]bool, len(approvals))) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func approvalRuleSetsString(sets []approvalRuleSet) []string {
+	res := make([]string, 0, len(sets))
+	for _, set := range sets {
+		res = append(res, set.String())
+	}
+	return res
+}
+
+func containsFold(list []string, s string) bool {
+	for _, e := range list {
+		if strings.EqualFold(e, s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestApprovalRules_Parse(t *testing.T) {
+
+	set, err := parseApprovalRuleSet("sre>=1, security >= 2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if set.String() != "sre>=1,security>=2" {
+		t.Fatalf("Unexpected rule set: expected %q got %q\n", "sre>=1,security>=2", set.String())
+	}
+
+	for _, s := range []string{"", "sre", "sre>=0", "sre=1", "sre>=1,security"} {
+		if _, err := parseApprovalRuleSet(s); err == nil {
+			t.Fatalf("Expected error for rule set %q\n", s)
+		}
+	}
+}
+
+func TestApprovalRules_Satisfied(t *testing.T) {
+
+	set, err := parseApprovalRuleSet("sre>=1,security>=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		approvals []requestApproval
+		expected  bool
+	}{
+		{
+			name: "two sre",
+			approvals: []requestApproval{
+				{ApproverID: "a", Groups: []string{"sre"}},
+				{ApproverID: "b", Groups: []string{"sre"}},
+			},
+			expected: false,
+		},
+		{
+			name: "sre and security",
+			approvals: []requestApproval{
+				{ApproverID: "a", Groups: []string{"sre"}},
+				{ApproverID: "b", Groups: []string{"security"}},
+			},
+			expected: true,
+		},
+		{
+			name: "one approver in both groups",
+			approvals: []requestApproval{
+				{ApproverID: "a", Groups: []string{"sre", "security"}},
+			},
+			expected: false,
+		},
+		{
+			name: "approver in both groups needs reassignment",
+			approvals: []requestApproval{
+				{ApproverID: "a", Groups: []string{"sre", "security"}},
+				{ApproverID: "b", Groups: []string{"SRE"}},
+			},
+			expected: true,
+		},
+	}
+
+	for _, tc := range tests {
+		if actual := set.satisfied(tc.approvals); actual != tc.expected {
+			t.Fatalf("%s: expected %t got %t\n", tc.name, tc.expected, actual)
+		}
+	}
+}
+
+func TestApprovalRules_AnyOf(t *testing.T) {
+
+	sre, _ := parseApprovalRuleSet("sre>=1,security>=1")
+	security, _ := parseApprovalRuleSet("security>=2")
+
+	sr := &requestStorageEntry{
+		MinApprovers:  2,
+		ApprovalRules: []approvalRuleSet{sre, security},
+		ApproverIDs:   []string{"a", "b"},
+		Approvals: []requestApproval{
+			{ApproverID: "a", Groups: []string{"security"}},
+			{ApproverID: "b", Groups: []string{"security"}},
+		},
+	}
+
+	if !sr.approved() {
+		t.Fatalf("Expected request to be approved by alternative rule set\n")
+	}
+
+	sr.Approvals[1].Groups = []string{"dev"}
+	if sr.approved() {
+		t.Fatalf("Expected request not to be approved\n")
+	}
+}
@@ -0,0 +1,362 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/pkg/errors"
+)
+
+// autoApproverID is the synthetic approver of automatically approved
+// requests, as recorded in approvals and history.
+const autoApproverID = "auto-approve"
+
+var weekdays = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+// autoApproveRule approves requests that satisfy all of its conditions.
+// Conditions that are not set are satisfied by any request.
+type autoApproveRule struct {
+	Groups       []string       `json:"groups,omitempty"`
+	Days         []time.Weekday `json:"days,omitempty"`
+	Hours        *minuteRange   `json:"hours,omitempty"`
+	Location     string         `json:"tz,omitempty"`
+	MaxTTL       time.Duration  `json:"max_ttl,omitempty"`
+	Environments []string       `json:"environments,omitempty"`
+}
+
+// minuteRange is a range of minutes of the day, from inclusive to exclusive.
+// If From is after To, the range wraps midnight.
+type minuteRange struct {
+	From int `json:"from"`
+	To   int `json:"to"`
+}
+
+// autoApproveInput holds what auto approve rules are evaluated against.
+type autoApproveInput struct {
+	Groups      []string
+	Environment string
+	TTL         time.Duration
+	Time        time.Time
+}
+
+// parseAutoApproveRule parses a rule like
+// "group=dev|qa,days=mon-fri,hours=08:00-18:00,tz=Europe/Amsterdam,max_ttl=1h,environment=dta".
+func parseAutoApproveRule(s string) (*autoApproveRule, error) {
+
+	rule := &autoApproveRule{}
+	conditions := 0
+	for _, part := range strings.Split(s, ",") {
+		if strings.TrimSpace(part) == "" {
+			continue
+		}
+
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			return nil, errors.Errorf("bad auto_approve condition %q (expected <key>=<value>)", part)
+		}
+		key, value := strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1])
+		values := splitAlternatives(value)
+		if len(values) == 0 {
+			return nil, errors.Errorf("bad auto_approve condition %q (empty value)", part)
+		}
+
+		switch key {
+		case "group":
+			rule.Groups = values
+		case "environment":
+			rule.Environments = values
+		case "days":
+			for _, v := range values {
+				days, err := parseWeekdays(v)
+				if err != nil {
+					return nil, err
+				}
+				rule.Days = append(rule.Days, days...)
+			}
+		case "hours":
+			hours, err := parseMinuteRange(value)
+			if err != nil {
+				return nil, err
+			}
+			rule.Hours = hours
+		case "tz":
+			if _, err := time.LoadLocation(value); err != nil {
+				return nil, errors.Errorf("bad auto_approve time zone %q", value)
+			}
+			rule.Location = value
+			continue // Not a condition by itself.
+		case "max_ttl":
+			ttl, err := time.ParseDuration(value)
+			if err != nil || ttl <= 0 {
+				return nil, errors.Errorf("bad auto_approve max_ttl %q", value)
+			}
+			rule.MaxTTL = ttl
+		default:
+			return nil, errors.Errorf("unknown auto_approve condition %q (expected group, days, hours, tz, max_ttl or environment)", key)
+		}
+		conditions++
+	}
+
+	if conditions == 0 {
+		return nil, errors.Errorf("auto_approve rule %q has no conditions", s)
+	}
+
+	return rule, nil
+}
+
+func splitAlternatives(s string) []string {
+	var res []string
+	for _, v := range strings.Split(s, "|") {
+		if v = strings.TrimSpace(v); v != "" {
+			res = append(res, v)
+		}
+	}
+	return res
+}
+
+// parseWeekdays parses a weekday like "mon" or a range like "mon-fri", which
+// may wrap the week, like "sat-mon".
+func parseWeekdays(s string) ([]time.Weekday, error) {
+
+	weekday := func(s string) (int, error) {
+		for i, day := range weekdays {
+			if strings.EqualFold(s, day) {
+				return i, nil
+			}
+		}
+		return 0, errors.Errorf("bad auto_approve day %q (expected %s)", s, strings.Join(weekdays, ", "))
+	}
+
+	bounds := strings.SplitN(s, "-", 2)
+	from, err := weekday(bounds[0])
+	if err != nil {
+		return nil, err
+	}
+	to := from
+	if len(bounds) == 2 {
+		if to, err = weekday(bounds[1]); err != nil {
+			return nil, err
+		}
+	}
+
+	var days []time.Weekday
+	for d := from; ; d = (d + 1) % 7 {
+		days = append(days, time.Weekday(d))
+		if d == to {
+			break
+		}
+	}
+
+	return days, nil
+}
+
+// parseMinuteRange parses a range like "08:00-18:00".
+func parseMinuteRange(s string) (*minuteRange, error) {
+
+	minute := func(s string) (int, error) {
+		hm := strings.SplitN(s, ":", 2)
+		if len(hm) != 2 {
+			return 0, errors.Errorf("bad auto_approve time %q (expected HH:MM)", s)
+		}
+		h, err := strconv.Atoi(hm[0])
+		if err != nil || h < 0 || h > 24 {
+			return 0, errors.Errorf("bad auto_approve time %q (expected HH:MM)", s)
+		}
+		m, err := strconv.Atoi(hm[1])
+		if err != nil || m < 0 || m > 59 || h*60+m > 24*60 {
+			return 0, errors.Errorf("bad auto_approve time %q (expected HH:MM)", s)
+		}
+		return h*60 + m, nil
+	}
+
+	bounds := strings.SplitN(s, "-", 2)
+	if len(bounds) != 2 {
+		return nil, errors.Errorf("bad auto_approve hours %q (expected HH:MM-HH:MM)", s)
+	}
+	from, err := minute(strings.TrimSpace(bounds[0]))
+	if err != nil {
+		return nil, err
+	}
+	to, err := minute(strings.TrimSpace(bounds[1]))
+	if err != nil {
+		return nil, err
+	}
+	if from == to {
+		return nil, errors.Errorf("bad auto_approve hours %q (empty range)", s)
+	}
+
+	return &minuteRange{From: from, To: to}, nil
+}
+
+func (r minuteRange) contains(minute int) bool {
+	if r.From < r.To {
+		return minute >= r.From && minute < r.To
+	}
+	return minute >= r.From || minute < r.To
+}
+
+func (r minuteRange) String() string {
+	return fmt.Sprintf("%02d:%02d-%02d:%02d", r.From/60, r.From%60, r.To/60, r.To%60)
+}
+
+// matches returns whether the input satisfies all conditions of the rule.
+func (rule *autoApproveRule) matches(in autoApproveInput) bool {
+
+	if len(rule.Groups) > 0 {
+		member := false
+		for _, g := range rule.Groups {
+			if containsFold(in.Groups, g) {
+				member = true
+				break
+			}
+		}
+		if !member {
+			return false
+		}
+	}
+
+	if len(rule.Environments) > 0 && !containsFold(rule.Environments, in.Environment) {
+		return false
+	}
+
+	if rule.MaxTTL > 0 && (in.TTL <= 0 || in.TTL > rule.MaxTTL) {
+		return false
+	}
+
+	t := in.Time.UTC()
+	if rule.Location != "" {
+		loc, err := time.LoadLocation(rule.Location)
+		if err != nil {
+			return false
+		}
+		t = in.Time.In(loc)
+	}
+
+	if len(rule.Days) > 0 {
+		allowed := false
+		for _, d := range rule.Days {
+			if t.Weekday() == d {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return false
+		}
+	}
+
+	if rule.Hours != nil && !rule.Hours.contains(t.Hour()*60+t.Minute()) {
+		return false
+	}
+
+	return true
+}
+
+func (rule *autoApproveRule) String() string {
+
+	var conditions []string
+	if len(rule.Groups) > 0 {
+		conditions = append(conditions, "group="+strings.Join(rule.Groups, "|"))
+	}
+	if len(rule.Days) > 0 {
+		days := make([]string, 0, len(rule.Days))
+		for _, d := range rule.Days {
+			days = append(days, weekdays[d])
+		}
+		conditions = append(conditions, "days="+strings.Join(days, "|"))
+	}
+	if rule.Hours != nil {
+		conditions = append(conditions, "hours="+rule.Hours.String())
+	}
+	if rule.Location != "" {
+		conditions = append(conditions, "tz="+rule.Location)
+	}
+	if rule.MaxTTL > 0 {
+		conditions = append(conditions, "max_ttl="+rule.MaxTTL.String())
+	}
+	if len(rule.Environments) > 0 {
+		conditions = append(conditions, "environment="+strings.Join(rule.Environments, "|"))
+	}
+
+	return strings.Join(conditions, ",")
+}
+
+func autoApproveRulesString(rules []*autoApproveRule) []string {
+	res := make([]string, 0, len(rules))
+	for _, rule := range rules {
+		res = append(res, rule.String())
+	}
+	return res
+}
+
+// matchAutoApproveRule returns the first of the rules that the input
+// satisfies, or nil.
+func matchAutoApproveRule(rules []*autoApproveRule, in autoApproveInput) *autoApproveRule {
+	for _, rule := range rules {
+		if rule.matches(in) {
+			return rule
+		}
+	}
+	return nil
+}
+
+// autoApprove approves the request with the synthetic approver if the caller
+// satisfies any of the role's auto_approve rules, and returns the rule.
+func (b *backend) autoApprove(r *logical.Request, role *roleStorageEntry, sr *requestStorageEntry, ttl time.Duration, now time.Time) *autoApproveRule {
+
+	if len(role.AutoApprove) == 0 {
+		return nil
+	}
+
+	var groups []string
+	if callerGroups, err := b.callerGroups(r); err == nil {
+		for _, g := range callerGroups {
+			groups = append(groups, g.Name, g.ID)
+		}
+	}
+
+	rule := matchAutoApproveRule(role.AutoApprove, autoApproveInput{
+		Groups:      groups,
+		Environment: role.SecretEnvironment,
+		TTL:         ttl,
+		Time:        now,
+	})
+	if rule == nil {
+		return nil
+	}
+
+	sr.AutoApproved = true
+	sr.ApproverIDs = append(sr.ApproverIDs, autoApproverID)
+	sr.Approvals = append(sr.Approvals, requestApproval{
+		ApproverID: autoApproverID,
+		Comment:    "auto_approve rule " + rule.String(),
+		ApprovedAt: now,
+	})
+
+	return rule
+}
+
+// recordAutoApproval records the approval by the synthetic approver in history
+// and notifies the role's channels.
+func (b *backend) recordAutoApproval(ctx context.Context, s logical.Storage, cfg *configStorageEntry, role *roleStorageEntry, name string, sr *requestStorageEntry, rule *autoApproveRule, resp *logical.Response) error {
+
+	events := []notificationEvent{
+		{Type: eventRequestApproved, Actor: autoApproverID, Role: name, Nonce: sr.Nonce, Reason: "auto_approve rule " + rule.String()},
+		{Type: eventRequestFullyApproved, Actor: autoApproverID, Role: name, Nonce: sr.Nonce, Reason: sr.Reason},
+	}
+
+	for _, event := range events {
+		if err := b.appendHistory(ctx, s, event); err != nil {
+			return errors.Wrapf(err, "failed to record history")
+		}
+		if _, err := b.notify(cfg, role.NotifySlackChannels, sr.SlackThreads, event); err != nil {
+			resp.AddWarning(err.Error())
+		}
+	}
+
+	return nil
+}
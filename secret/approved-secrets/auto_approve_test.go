@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestAutoApprove_Parse(t *testing.T) {
+
+	rule, err := parseAutoApproveRule("group=dev|qa, days=fri-mon, hours=22:00-06:00, tz=UTC, max_ttl=1h, environment=dta")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "group=dev|qa,days=fri|sat|sun|mon,hours=22:00-06:00,tz=UTC,max_ttl=1h0m0s,environment=dta"
+	if rule.String() != expected {
+		t.Fatalf("Unexpected rule: expected %q got %q\n", expected, rule.String())
+	}
+
+	for _, s := range []string{"", "tz=UTC", "group", "days=someday", "hours=08:00", "hours=25:00-26:00", "max_ttl=forever", "owner=me"} {
+		if _, err := parseAutoApproveRule(s); err == nil {
+			t.Fatalf("Expected error for rule %q\n", s)
+		}
+	}
+}
+
+func TestAutoApprove_Matches(t *testing.T) {
+
+	rule, err := parseAutoApproveRule("group=dev,days=mon-fri,hours=08:00-18:00,max_ttl=1h,environment=dta")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	monday := time.Date(2020, 3, 2, 10, 0, 0, 0, time.UTC)
+	in := autoApproveInput{Groups: []string{"DEV"}, Environment: "dta", TTL: time.Hour, Time: monday}
+
+	tests := []struct {
+		name    string
+		modify  func(in autoApproveInput) autoApproveInput
+		matches bool
+	}{
+		{name: "all conditions", modify: func(in autoApproveInput) autoApproveInput { return in }, matches: true},
+		{name: "other group", modify: func(in autoApproveInput) autoApproveInput { in.Groups = []string{"ops"}; return in }},
+		{name: "other environment", modify: func(in autoApproveInput) autoApproveInput { in.Environment = "prd"; return in }},
+		{name: "ttl too long", modify: func(in autoApproveInput) autoApproveInput { in.TTL = 2 * time.Hour; return in }},
+		{name: "weekend", modify: func(in autoApproveInput) autoApproveInput { in.Time = monday.Add(-48 * time.Hour); return in }},
+		{name: "evening", modify: func(in autoApproveInput) autoApproveInput { in.Time = monday.Add(8 * time.Hour); return in }},
+	}
+
+	for _, tc := range tests {
+		if actual := rule.matches(tc.modify(in)); actual != tc.matches {
+			t.Fatalf("%s: expected %t got %t\n", tc.name, tc.matches, actual)
+		}
+	}
+
+	night, err := parseAutoApproveRule("hours=22:00-06:00,tz=Europe/Amsterdam")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !night.matches(autoApproveInput{Time: time.Date(2020, 3, 2, 22, 30, 0, 0, time.UTC)}) {
+		t.Fatalf("Expected night rule to match before midnight\n")
+	}
+	if night.matches(autoApproveInput{Time: time.Date(2020, 3, 2, 5, 30, 0, 0, time.UTC)}) {
+		t.Fatalf("Expected night rule not to match after 06:00 in Amsterdam\n")
+	}
+}
+
+func TestAutoApprove_Request(t *testing.T) {
+	b, storage := getBackendWithEntities(t, "http://127.0.0.1:0")
+	ctx := context.Background()
+
+	rule, err := parseAutoApproveRule("max_ttl=1h,environment=dta")
+	if err != nil {
+		t.Fatal(err)
+	}
+	role := &roleStorageEntry{SecretPath: "secret/dta", SecretEnvironment: "dta", SecretTTL: 8 * time.Hour, SecretMaxTTL: 24 * time.Hour, MinApprovers: 2, MinRejecters: 1, AutoApprove: []*autoApproveRule{rule}}
+	if err := b.roleAccessor.put(ctx, storage, role, "dta"); err != nil {
+		t.Fatal(err)
+	}
+
+	request := func(data map[string]interface{}) *requestStorageEntry {
+		data["reason"] = "debugging"
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "request/dta",
+			Storage:   storage,
+			EntityID:  "bob",
+			Data:      data,
+		})
+		if err != nil || resp == nil || resp.IsError() {
+			t.Fatalf("err:%s resp:%#v\n", err, resp)
+		}
+		sr, err := b.request(ctx, storage, "dta", resp.Data["nonce"].(string))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sr
+	}
+
+	if sr := request(map[string]interface{}{}); sr.State != requestPending || sr.AutoApproved {
+		t.Fatalf("Expected request with default ttl to be pending, got %#v\n", sr)
+	}
+
+	sr := request(map[string]interface{}{"ttl": "30m"})
+	if sr.State != requestApproved || !sr.AutoApproved || sr.TTL != 30*time.Minute {
+		t.Fatalf("Expected request to be approved automatically, got %#v\n", sr)
+	}
+	if len(sr.Approvals) != 1 || sr.Approvals[0].ApproverID != autoApproverID {
+		t.Fatalf("Expected synthetic approver, got %#v\n", sr.Approvals)
+	}
+
+	entry, err := b.history(ctx, storage, "dta", sr.Nonce)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.Outcome != historyOutcomeApproved || len(entry.Approvals) != 1 || entry.Approvals[0].ApproverID != autoApproverID {
+		t.Fatalf("Expected automatic approval in history, got %#v\n", entry)
+	}
+}
@@ -3,8 +3,11 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"sync"
+	"time"
 
 	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/locksutil"
 	"github.com/hashicorp/vault/sdk/logical"
 	"github.com/pkg/errors"
 )
@@ -20,7 +23,40 @@ func backendFactory(ctx context.Context, c *logical.BackendConfig) (logical.Back
 type backend struct {
 	*framework.Backend
 
-	configAccessor, roleAccessor, requestAccessor, issueAccessor *atomicStorageAccessor
+	configAccessor, roleAccessor, requestAccessor, issueAccessor, historyAccessor, tokenHealthAccessor, mfaAccessor, roleVersionAccessor *atomicStorageAccessor
+
+	tidyMutex sync.Mutex
+	lastTidy  time.Time
+
+	// requestLocks serialize the state transitions of each request.
+	requestLocks []*locksutil.LockEntry
+
+	// historyLocks serialize the updates of each history entry.
+	historyLocks []*locksutil.LockEntry
+
+	// leaseMutex serializes the checks and updates of the lease registry.
+	leaseMutex sync.Mutex
+
+	// legacyTokenRoles are the token roles of earlier versions seen by the
+	// last cleanup, guarded by tidyMutex.
+	legacyTokenRoles map[string]bool
+
+	// tokenMutex serializes renewals and rotations of the plugin's token.
+	tokenMutex sync.Mutex
+
+	// tokenRoleMutex serializes the updates of the managed token role.
+	tokenRoleMutex sync.Mutex
+
+	// mfaMutex serializes the verification of TOTP codes, so each code is
+	// accepted once only.
+	mfaMutex sync.Mutex
+
+	// roleMutex serializes the changes of role versions.
+	roleMutex sync.Mutex
+
+	// newTicketTracker returns the configured ticket tracker, replaced by a
+	// stub in tests.
+	newTicketTracker func(*configStorageEntry) (ticketTracker, error)
 }
 
 func newBackend() *backend {
@@ -29,21 +65,40 @@ func newBackend() *backend {
 		roleAccessor:    newAtomicStorageAccessor("role"),
 		requestAccessor: newAtomicStorageAccessor("request"),
 		issueAccessor:   newAtomicStorageAccessor("issue"),
+		historyAccessor: newAtomicStorageAccessor("history"),
+
+		tokenHealthAccessor: newAtomicStorageAccessor("token_health"),
+		mfaAccessor:         newAtomicStorageAccessor("mfa"),
+		roleVersionAccessor: newAtomicStorageAccessor("role_version"),
+
+		requestLocks: locksutil.CreateLocks(),
+		historyLocks: locksutil.CreateLocks(),
+
+		newTicketTracker: newTicketTracker,
 	}
 
 	b.Backend = &framework.Backend{
-		PeriodicFunc: newVaultTokenRenewer(b),
+		PeriodicFunc: newPeriodicFunc(b),
 		Secrets: []*framework.Secret{
 			secretApprovedSecretRequest(b),
 			secretApprovedSecretIssue(b),
 		},
 		BackendType: logical.TypeLogical,
+		PathsSpecial: &logical.Paths{
+			Unauthenticated: []string{"slack/callback"},
+		},
 		Paths: framework.PathAppend(
 			[]*framework.Path{
 				pathConfig(b),
+				pathConfigHealth(b),
+				pathRotateToken(b),
 				pathIssue(b),
 				pathRequest(b),
 				pathApprove(b),
+				pathReject(b),
+				pathCancel(b),
+				pathAdminCancel(b),
+				pathAmend(b),
 				pathListRole(b),
 				pathListRoles(b),
 				pathListRequest(b),
@@ -51,6 +106,25 @@ func newBackend() *backend {
 				pathListIssue(b),
 				pathListIssues(b),
 				pathSollIst(b),
+				pathHistory(b),
+				pathListHistory(b),
+				pathTidyHistory(b),
+				pathBreakGlass(b),
+				pathReview(b),
+				pathListReview(b),
+				pathListLeases(b),
+				pathRevokeLease(b),
+				pathInbox(b),
+				pathMine(b),
+				pathSlackCallback(b),
+				pathMFAEnroll(b),
+				pathAdminMFA(b),
+				pathRoleExport(b),
+				pathRoleImport(b),
+				pathRoleVersions(b),
+				pathRoleVersion(b),
+				pathRoleApprove(b),
+				pathRoleReject(b),
 			},
 			pathsRole(b),
 		),
@@ -59,6 +133,19 @@ func newBackend() *backend {
 	return b
 }
 
+func newPeriodicFunc(b *backend) func(context.Context, *logical.Request) error {
+
+	renewVaultToken := newVaultTokenRenewer(b)
+	return func(ctx context.Context, r *logical.Request) error {
+
+		if err := renewVaultToken(ctx, r); err != nil {
+			return err
+		}
+
+		return b.periodicHousekeeping(ctx, r)
+	}
+}
+
 func newVaultTokenRenewer(b *backend) func(context.Context, *logical.Request) error {
 
 	backend := b
@@ -68,24 +155,14 @@ func newVaultTokenRenewer(b *backend) func(context.Context, *logical.Request) er
 		if err != nil {
 			return nil // Ignore errors to avoid secret engine disable failures.
 		} else if config == nil {
-			return nil // No config, nothing to revoke.
-		}
-
-		clt, err := newVaultClient(ctx, config.VaultAddr, config.VaultToken)
-		if err != nil {
-			return nil // Ignore errors to avoid secret engine disable failures.
-		}
-
-		data := map[string]interface{}{
-			"increment": "72h",
+			return nil // No config, nothing to renew.
 		}
 
-		_, err = clt.Logical().Write("/auth/token/renew-self", data)
-		if err != nil {
-			return nil // Ignore errors to avoid secret engine disable failures.
+		if err = backend.renewVaultToken(ctx, r.Storage, config); err != nil {
+			backend.Logger().Warn("failed to record Vault token health", "error", err)
 		}
 
-		return nil
+		return nil // Ignore errors to avoid secret engine disable failures.
 	}
 }
 
@@ -103,6 +180,11 @@ func (b *backend) role(ctx context.Context, s logical.Storage, name string) (*ro
 		return nil, err
 	}
 
+	if role.ExclusiveLease && role.MaxConcurrentLeases == 0 { // Migrate deprecated exclusive_lease.
+		role.MaxConcurrentLeases = 1
+	}
+	role.ExclusiveLease = false
+
 	return role, nil
 }
 
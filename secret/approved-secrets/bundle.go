@@ -0,0 +1,351 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/hcl"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/pkg/errors"
+)
+
+const (
+	bundleFormatJSON = "json"
+	bundleFormatHCL  = "hcl"
+)
+
+var (
+	roleNameRegex = regexp.MustCompile("^" + framework.GenericNameRegex("name") + "$")
+	hclKeyRegex   = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_-]*$`)
+
+	// reservedRoleNames are taken by paths below roles/.
+	reservedRoleNames = []string{"export", "import"}
+)
+
+// roleDrift is the difference between a desired and the actual set of roles.
+type roleDrift struct {
+	Missing   []string
+	Extra     []string
+	Differing map[string]interface{}
+}
+
+// exportRole returns the fields of the role as written to roles/:name,
+// without fields that are not set.
+func exportRole(role *roleStorageEntry) (map[string]interface{}, error) {
+
+	raw, err := json.Marshal(roleData(role))
+	if err != nil {
+		return nil, err
+	}
+
+	fields := map[string]interface{}{}
+	if err = json.Unmarshal(raw, &fields); err != nil {
+		return nil, err
+	}
+
+	for name, v := range fields {
+		switch v := v.(type) {
+		case nil:
+			delete(fields, name)
+		case string:
+			if v == "" {
+				delete(fields, name)
+			}
+		case bool:
+			if !v {
+				delete(fields, name)
+			}
+		case []interface{}:
+			if len(v) == 0 {
+				delete(fields, name)
+			}
+		case map[string]interface{}:
+			if len(v) == 0 {
+				delete(fields, name)
+			}
+		}
+	}
+
+	return fields, nil
+}
+
+// encodeRoleBundle returns the roles as canonical bundle, with sorted keys.
+func encodeRoleBundle(roles map[string]interface{}, format string) (string, error) {
+
+	switch format {
+	case bundleFormatJSON:
+		bs, err := json.MarshalIndent(map[string]interface{}{"roles": roles}, "", "  ")
+		if err != nil {
+			return "", err
+		}
+		return string(bs) + "\n", nil
+	case bundleFormatHCL:
+		var sb strings.Builder
+		for i, name := range sortedKeys(roles) {
+			if i > 0 {
+				sb.WriteString("\n")
+			}
+			fields, _ := roles[name].(map[string]interface{})
+			fmt.Fprintf(&sb, "role %s %s\n", strconv.Quote(name), encodeHCL(fields, ""))
+		}
+		return sb.String(), nil
+	}
+
+	return "", errors.Errorf("unsupported format %q (expected %s or %s)", format, bundleFormatJSON, bundleFormatHCL)
+}
+
+func encodeHCL(v interface{}, indent string) string {
+
+	switch v := v.(type) {
+	case map[string]interface{}:
+		if len(v) == 0 {
+			return "{}"
+		}
+		var sb strings.Builder
+		sb.WriteString("{\n")
+		for _, k := range sortedKeys(v) {
+			if v[k] == nil {
+				continue
+			}
+			key := k
+			if !hclKeyRegex.MatchString(k) {
+				key = strconv.Quote(k)
+			}
+			fmt.Fprintf(&sb, "%s  %s = %s\n", indent, key, encodeHCL(v[k], indent+"  "))
+		}
+		sb.WriteString(indent + "}")
+		return sb.String()
+	case []interface{}:
+		items := make([]string, 0, len(v))
+		multiline := false
+		for _, item := range v {
+			if _, ok := item.(map[string]interface{}); ok {
+				multiline = true
+			}
+			items = append(items, encodeHCL(item, indent+"  "))
+		}
+		if !multiline {
+			return "[" + strings.Join(items, ", ") + "]"
+		}
+		return "[\n" + indent + "  " + strings.Join(items, ",\n"+indent+"  ") + ",\n" + indent + "]"
+	case string:
+		return strconv.Quote(v)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case nil:
+		return `""`
+	}
+
+	return fmt.Sprint(v)
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// parseRoleBundle parses a JSON bundle like {"roles": {"<name>": {...}}} or an
+// HCL bundle of role "<name>" {...} blocks.
+func parseRoleBundle(s string) (map[string]interface{}, error) {
+
+	if strings.HasPrefix(strings.TrimSpace(s), "{") {
+		var bundle struct {
+			Roles map[string]interface{} `json:"roles"`
+		}
+		dec := json.NewDecoder(strings.NewReader(s))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&bundle); err != nil {
+			return nil, errors.Wrapf(err, "invalid JSON bundle")
+		}
+		return bundle.Roles, nil
+	}
+
+	var raw map[string]interface{}
+	if err := hcl.Unmarshal([]byte(s), &raw); err != nil {
+		return nil, errors.Wrapf(err, "invalid HCL bundle")
+	}
+
+	schema := roleFields()
+	roles := map[string]interface{}{}
+	for key, blocks := range raw {
+		if key != "role" {
+			return nil, errors.Errorf("invalid HCL bundle: unexpected %q (expected role blocks)", key)
+		}
+		list, ok := blocks.([]map[string]interface{})
+		if !ok {
+			return nil, errors.New(`invalid HCL bundle: expected role "<name>" {...} blocks`)
+		}
+		for _, block := range list {
+			for name, body := range block {
+				if _, ok := roles[name]; ok {
+					return nil, errors.Errorf("invalid HCL bundle: duplicate role %q", name)
+				}
+				bodies, _ := body.([]map[string]interface{})
+				fields := map[string]interface{}{}
+				for _, m := range bodies {
+					for field, v := range m {
+						// HCL decodes objects as lists of objects, which
+						// are lists for list fields only.
+						if objects, ok := v.([]map[string]interface{}); ok && schema[field] != nil && schema[field].Type == framework.TypeSlice {
+							items := make([]interface{}, 0, len(objects))
+							for _, item := range objects {
+								items = append(items, collapseHCL(item))
+							}
+							fields[field] = items
+							continue
+						}
+						fields[field] = collapseHCL(v)
+					}
+				}
+				roles[name] = fields
+			}
+		}
+	}
+
+	return roles, nil
+}
+
+// collapseHCL merges the lists of objects decoded by HCL into objects.
+func collapseHCL(v interface{}) interface{} {
+
+	switch v := v.(type) {
+	case []map[string]interface{}:
+		merged := map[string]interface{}{}
+		for _, m := range v {
+			for k, item := range m {
+				merged[k] = collapseHCL(item)
+			}
+		}
+		return merged
+	case map[string]interface{}:
+		res := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			res[k] = collapseHCL(item)
+		}
+		return res
+	case []interface{}:
+		res := make([]interface{}, 0, len(v))
+		for _, item := range v {
+			res = append(res, collapseHCL(item))
+		}
+		return res
+	}
+
+	return v
+}
+
+// roleBundleData returns the roles of the request's roles field or, if not
+// set, of its bundle field.
+func roleBundleData(d *framework.FieldData) (map[string]interface{}, error) {
+
+	if rolesRaw, ok := d.GetOk("roles"); ok {
+		return rolesRaw.(map[string]interface{}), nil
+	}
+
+	if bundle := d.Get("bundle").(string); bundle != "" {
+		return parseRoleBundle(bundle)
+	}
+
+	return nil, errors.New("either field 'roles' or 'bundle' is required")
+}
+
+// bundleRoles validates the roles of a bundle as if each were created with
+// roles/:name.
+func (b *backend) bundleRoles(raw map[string]interface{}) (map[string]*roleStorageEntry, error) {
+
+	schema := roleFields()
+	roles := make(map[string]*roleStorageEntry, len(raw))
+	for _, name := range sortedKeys(raw) {
+		if !roleNameRegex.MatchString(name) || containsFold(reservedRoleNames, name) {
+			return nil, errors.Errorf("invalid role name %q", name)
+		}
+
+		fields, ok := raw[name].(map[string]interface{})
+		if !ok {
+			return nil, errors.Errorf("role %q: expected an object of fields", name)
+		}
+		for field := range fields {
+			if _, ok := schema[field]; !ok || field == "name" {
+				return nil, errors.Errorf("role %q: unknown field %q", name, field)
+			}
+		}
+
+		d := &framework.FieldData{Raw: fields, Schema: schema}
+		if err := d.Validate(); err != nil {
+			return nil, errors.Wrapf(err, "role %q", name)
+		}
+
+		role := &roleStorageEntry{}
+		resp, err := b.setRoleFields(role, d, true)
+		if err != nil {
+			return nil, errors.Wrapf(err, "role %q", name)
+		} else if resp != nil && resp.IsError() {
+			return nil, errors.Errorf("role %q: %s", name, resp.Error())
+		}
+		// Role names are stored in lower case.
+		if _, ok := roles[strings.ToLower(name)]; ok {
+			return nil, errors.Errorf("duplicate role %q", name)
+		}
+		roles[strings.ToLower(name)] = role
+	}
+
+	return roles, nil
+}
+
+// roles returns all roles by name.
+func (b *backend) roles(ctx context.Context, s logical.Storage) (map[string]*roleStorageEntry, error) {
+
+	names, err := b.roleAccessor.list(ctx, s, "")
+	if err != nil {
+		return nil, err
+	}
+
+	roles := make(map[string]*roleStorageEntry, len(names))
+	for _, name := range names {
+		role, err := b.role(ctx, s, name)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read role %q", name)
+		} else if role != nil {
+			roles[name] = role
+		}
+	}
+
+	return roles, nil
+}
+
+// compareRoles returns the roles missing from actual, the extra roles in
+// actual and the differences of roles in both.
+func compareRoles(desired, actual map[string]*roleStorageEntry) roleDrift {
+
+	drift := roleDrift{Missing: []string{}, Extra: []string{}, Differing: map[string]interface{}{}}
+	for name, role := range desired {
+		if current, ok := actual[name]; !ok {
+			drift.Missing = append(drift.Missing, name)
+		} else if diff := roleDiff(current, role); len(diff) > 0 {
+			drift.Differing[name] = diff
+		}
+	}
+	for name := range actual {
+		if _, ok := desired[name]; !ok {
+			drift.Extra = append(drift.Extra, name)
+		}
+	}
+	sort.Strings(drift.Missing)
+	sort.Strings(drift.Extra)
+
+	return drift
+}
+
+func (drift roleDrift) inSync() bool {
+	return len(drift.Missing) == 0 && len(drift.Extra) == 0 && len(drift.Differing) == 0
+}
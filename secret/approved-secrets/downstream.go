@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"strings"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/pkg/errors"
+)
+
+// downstreamSecret identifies the credential fetched from a role's
+// secret_path, so it can be revoked together with the issue.
+type downstreamSecret struct {
+	LeaseID           string `json:"lease_id,omitempty"`
+	TokenAccessor     string `json:"token_accessor,omitempty"`
+	CertificateSerial string `json:"certificate_serial,omitempty"`
+	PKIRevokePath     string `json:"pki_revoke_path,omitempty"`
+}
+
+// newDownstreamSecret captures the lease ID, token accessor or certificate
+// serial of the secret returned by secretPath.
+func newDownstreamSecret(secretPath string, secret *api.Secret) downstreamSecret {
+
+	var ds downstreamSecret
+	if secret == nil {
+		return ds
+	}
+
+	ds.LeaseID = secret.LeaseID
+	if secret.Auth != nil {
+		ds.TokenAccessor = secret.Auth.Accessor
+	}
+
+	if serial, ok := secret.Data["serial_number"].(string); ok && serial != "" {
+		ds.CertificateSerial = serial
+		for _, op := range []string{"/issue/", "/sign/"} {
+			if i := strings.LastIndex(secretPath, op); i > 0 {
+				ds.PKIRevokePath = strings.TrimPrefix(secretPath[:i], "/") + "/revoke"
+				break
+			}
+		}
+	}
+
+	return ds
+}
+
+// revoke revokes the downstream secret by its lease ID, token accessor or
+// certificate serial, in that order of preference.
+func (ds downstreamSecret) revoke(ctx context.Context, cfg *configStorageEntry) error {
+
+	if ds.LeaseID == "" && ds.TokenAccessor == "" && ds.PKIRevokePath == "" {
+		return nil // Nothing to revoke.
+	}
+
+	if cfg == nil {
+		return errors.New("could not find config")
+	}
+
+	clt, err := newVaultClient(ctx, cfg.VaultAddr, cfg.VaultToken)
+	if err != nil {
+		return errors.Wrap(err, "failed to create vault client")
+	}
+
+	switch {
+	case ds.LeaseID != "":
+		_, err = clt.Logical().Write("sys/leases/revoke", map[string]interface{}{"lease_id": ds.LeaseID})
+		return errors.Wrapf(err, "failed to revoke lease %q", ds.LeaseID)
+	case ds.TokenAccessor != "":
+		_, err = clt.Logical().Write("auth/token/revoke-accessor", map[string]interface{}{"accessor": ds.TokenAccessor})
+		return errors.Wrapf(err, "failed to revoke token accessor %q", ds.TokenAccessor)
+	default:
+		_, err = clt.Logical().Write(ds.PKIRevokePath, map[string]interface{}{"serial_number": ds.CertificateSerial})
+		return errors.Wrapf(err, "failed to revoke certificate %q", ds.CertificateSerial)
+	}
+}
+
+// revokeDownstreamSecrets revokes all secrets fetched by an issue.
+func revokeDownstreamSecrets(ctx context.Context, cfg *configStorageEntry, secrets []downstreamSecret) error {
+
+	var failed []string
+	for _, ds := range secrets {
+		if err := ds.revoke(ctx, cfg); err != nil {
+			failed = append(failed, err.Error())
+		}
+	}
+
+	if len(failed) > 0 {
+		return errors.New(strings.Join(failed, "; "))
+	}
+
+	return nil
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/hashicorp/vault/api"
+)
+
+func TestDownstream_New(t *testing.T) {
+
+	tests := []struct {
+		name       string
+		secretPath string
+		secret     *api.Secret
+		expected   downstreamSecret
+	}{
+		{
+			name:       "lease",
+			secretPath: "database/creds/admin",
+			secret:     &api.Secret{LeaseID: "database/creds/admin/abc"},
+			expected:   downstreamSecret{LeaseID: "database/creds/admin/abc"},
+		},
+		{
+			name:       "token",
+			secretPath: "auth/token/create",
+			secret:     &api.Secret{Auth: &api.SecretAuth{Accessor: "accessor"}},
+			expected:   downstreamSecret{TokenAccessor: "accessor"},
+		},
+		{
+			name:       "certificate",
+			secretPath: "yfb-prd/k8s-apiserver/issue/admin",
+			secret:     &api.Secret{Data: map[string]interface{}{"serial_number": "7a:17"}},
+			expected:   downstreamSecret{CertificateSerial: "7a:17", PKIRevokePath: "yfb-prd/k8s-apiserver/revoke"},
+		},
+		{
+			name:       "static",
+			secretPath: "secret/data/static",
+			secret:     &api.Secret{Data: map[string]interface{}{"password": "secret"}},
+			expected:   downstreamSecret{},
+		},
+	}
+
+	for _, tc := range tests {
+		if actual := newDownstreamSecret(tc.secretPath, tc.secret); actual != tc.expected {
+			t.Fatalf("%s: expected %#v got %#v\n", tc.name, tc.expected, actual)
+		}
+	}
+}
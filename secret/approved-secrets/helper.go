@@ -1,67 +1,60 @@
 package main
 
 import (
-	"context"
-	"path"
-
-	"github.com/hashicorp/vault/sdk/helper/strutil"
 	"github.com/hashicorp/vault/sdk/logical"
 	"github.com/pkg/errors"
 )
 
-func (b *backend) verifyCallerRoles(ctx context.Context, r *logical.Request, roles []string) error {
+// primaryRoleMetadataKey is the legacy group metadata key matched against
+// bound_requester_roles and bound_approver_roles.
+const primaryRoleMetadataKey = "primaryRole"
 
-	cfg, err := b.config(ctx, r.Storage)
-	if err != nil {
-		return errors.New("could not find config: " + err.Error())
-	}
+// verifyCallerGroups verifies that the caller's entity is member of one of
+// the Vault identity groups (by name or ID) in groups, or of a group with its
+// primaryRole metadata in roles. If both are empty, any caller is allowed.
+// It returns the names and primary roles of the caller's groups.
+func (b *backend) verifyCallerGroups(r *logical.Request, roles, groups []string) ([]string, error) {
 
-	clt, err := newVaultClient(ctx, cfg.VaultAddr, cfg.VaultToken)
+	callerGroups, err := b.callerGroups(r)
 	if err != nil {
-		return errors.New("failed to create vault client: " + err.Error())
+		return nil, err
 	}
 
-	data := map[string]interface{}{
-		"accessor": r.ClientTokenAccessor,
-	}
+	var names []string
+	allowed := len(roles) == 0 && len(groups) == 0
+	for _, g := range callerGroups {
+		names = append(names, g.Name)
+		if containsFold(groups, g.Name) || containsFold(groups, g.ID) {
+			allowed = true
+		}
 
-	vaultPath := "auth/token/lookup-accessor"
-	secret, err := clt.Logical().Write(vaultPath, data)
-	if err != nil {
-		return errors.Wrapf(err, "failed to read path: %s", vaultPath)
+		if primaryRole, ok := g.Metadata[primaryRoleMetadataKey]; ok && primaryRole != "" {
+			names = append(names, primaryRole)
+			if containsFold(roles, primaryRole) {
+				allowed = true
+			}
+		}
 	}
-	entityID := secret.Data["entity_id"].(string)
 
-	vaultPath = path.Join("/identity/entity/id", entityID)
-	secret, err = clt.Logical().Read(vaultPath)
-	if err != nil {
-		return errors.Wrapf(err, "failed to read path: %s", vaultPath)
+	if !allowed {
+		return nil, errors.New("role(s) or group(s) not allowed")
 	}
 
-	groupIDs := secret.Data["group_ids"].([]interface{})
-	found := false
-	for _, id := range groupIDs {
-		vaultPath = path.Join("/identity/group/id", id.(string))
-		secret, err = clt.Logical().Read(vaultPath)
-		if err != nil {
-			return errors.Wrapf(err, "failed to read path: %s", vaultPath)
-		}
+	return names, nil
+}
 
-		if metadataRaw, ok := secret.Data["metadata"]; ok {
-			if metadata, ok := metadataRaw.(map[string]interface{}); ok {
-				if primaryRoleRaw, ok := metadata["primaryRole"]; ok {
-					if primaryRole, ok := primaryRoleRaw.(string); ok && strutil.StrListContains(roles, primaryRole) {
-						found = true
-						break
-					}
-				}
-			}
-		}
+// callerGroups returns the Vault identity groups of the caller's entity,
+// including inherited groups.
+func (b *backend) callerGroups(r *logical.Request) ([]*logical.Group, error) {
+
+	if r.EntityID == "" {
+		return nil, errors.New("could not get groups cause logical.Request.EntityID is empty (do you have an identity - are you behind an auth method?)")
 	}
 
-	if !found {
-		return errors.New("role(s) not allowed")
+	groups, err := b.System().GroupsForEntity(r.EntityID)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not get groups of entity")
 	}
 
-	return nil
+	return groups, nil
 }
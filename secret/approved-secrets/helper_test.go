@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestHelper_VerifyCallerGroups(t *testing.T) {
+	b := newBackend()
+	config := &logical.BackendConfig{
+		System: &logical.StaticSystemView{
+			GroupsVal: []*logical.Group{
+				{ID: "group-sre-id", Name: "sre-team", Metadata: map[string]string{"primaryRole": "sre"}},
+				{ID: "group-dev-id", Name: "developers"},
+			},
+		},
+		StorageView: &logical.InmemStorage{},
+	}
+	if err := b.Setup(context.Background(), config); err != nil {
+		t.Fatalf("unable to create backend: %v", err)
+	}
+
+	tests := []struct {
+		name          string
+		roles, groups []string
+		allowed       bool
+	}{
+		{name: "unbound", allowed: true},
+		{name: "legacy primary role", roles: []string{"sre"}, allowed: true},
+		{name: "group name", groups: []string{"Developers"}, allowed: true},
+		{name: "group id", groups: []string{"group-dev-id"}, allowed: true},
+		{name: "role or group", roles: []string{"security"}, groups: []string{"developers"}, allowed: true},
+		{name: "not member", roles: []string{"security"}, groups: []string{"security-team"}, allowed: false},
+	}
+
+	r := &logical.Request{EntityID: "entity-id"}
+	for _, tc := range tests {
+		names, err := b.verifyCallerGroups(r, tc.roles, tc.groups)
+		if tc.allowed != (err == nil) {
+			t.Fatalf("%s: expected allowed %t got error %v\n", tc.name, tc.allowed, err)
+		}
+		if err == nil && len(names) != 3 {
+			t.Fatalf("%s: expected groups [sre-team sre developers] got %v\n", tc.name, names)
+		}
+	}
+
+	if _, err := b.verifyCallerGroups(&logical.Request{}, nil, nil); err == nil {
+		t.Fatalf("Expected error for request without entity\n")
+	}
+}
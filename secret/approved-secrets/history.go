@@ -0,0 +1,226 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/helper/locksutil"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	historyOutcomePending   = "pending"
+	historyOutcomeApproved  = "approved"
+	historyOutcomeDenied    = "denied"
+	historyOutcomeExpired   = "expired"
+	historyOutcomeCancelled = "cancelled"
+	historyOutcomeIssued    = "issued"
+	historyOutcomeRevoked   = "revoked"
+
+	historyReviewPending  = "pending_review"
+	historyReviewReviewed = "reviewed"
+)
+
+// appendHistory records the event in the append-only history of the request.
+// History entries outlive requests and issues, which are deleted when issued
+// or revoked, and are only removed by tidy after the retention period.
+func (b *backend) appendHistory(ctx context.Context, s logical.Storage, e notificationEvent) error {
+	return b.updateHistory(ctx, s, e.Role, e.Nonce, func(entry *historyStorageEntry) error {
+		entry.append(e)
+		return nil
+	})
+}
+
+// updateHistory applies update to the history entry of the request, which is
+// created if it does not exist yet. The entry is not stored if update returns
+// an error.
+func (b *backend) updateHistory(ctx context.Context, s logical.Storage, name, nonce string, update func(*historyStorageEntry) error) error {
+
+	lock := locksutil.LockForKey(b.historyLocks, path.Join(strings.ToLower(name), strings.ToLower(nonce)))
+	lock.Lock()
+	defer lock.Unlock()
+
+	entry, err := b.history(ctx, s, name, nonce)
+	if err != nil {
+		return err
+	} else if entry == nil {
+		entry = &historyStorageEntry{
+			Nonce:   nonce,
+			Role:    name,
+			Outcome: historyOutcomePending,
+		}
+	}
+
+	if err = update(entry); err != nil {
+		return err
+	}
+
+	return b.historyAccessor.put(ctx, s, entry, name, nonce)
+}
+
+func (entry *historyStorageEntry) append(e notificationEvent) {
+
+	now := time.Now()
+	entry.Events = append(entry.Events, historyEvent{
+		Type:   e.Type,
+		Actor:  e.Actor,
+		Reason: e.Reason,
+		Time:   now,
+	})
+
+	switch e.Type {
+	case eventRequestCreated:
+		entry.RequesterID = e.Actor
+		entry.Reason = e.Reason
+		entry.Ticket = e.Ticket
+		entry.TicketURL = e.TicketURL
+		entry.CreatedAt = now
+	case eventRequestApproved:
+		entry.Approvals = append(entry.Approvals, requestApproval{ApproverID: e.Actor, Comment: e.Reason, ApprovedAt: now})
+	case eventRequestRejected:
+		entry.Rejections = append(entry.Rejections, requestRejection{RejecterID: e.Actor, Comment: e.Reason, RejectedAt: now})
+	case eventRequestFullyApproved:
+		entry.Outcome = historyOutcomeApproved
+	case eventRequestDenied:
+		entry.Outcome = historyOutcomeDenied
+	case eventRequestExpired:
+		entry.Outcome = historyOutcomeExpired
+	case eventRequestCancelled:
+		entry.Outcome = historyOutcomeCancelled
+	case eventRequestAmended:
+		entry.Reason = e.Reason
+		entry.Approvals = nil
+		entry.Outcome = historyOutcomePending
+	case eventSecretIssued:
+		entry.IssuedAt = now
+		entry.Outcome = historyOutcomeIssued
+	case eventSecretBreakGlass:
+		entry.IssuedAt = now
+		entry.Outcome = historyOutcomeIssued
+		entry.BreakGlass = true
+		entry.ReviewStatus = historyReviewPending
+	case eventSecretRevoked:
+		entry.RevokedAt = now
+		entry.Outcome = historyOutcomeRevoked
+	case eventReviewAcknowledged:
+		entry.Reviews = append(entry.Reviews, requestApproval{ApproverID: e.Actor, Comment: e.Reason, ApprovedAt: now})
+		if len(entry.Reviews) >= entry.ReviewsRequired {
+			entry.ReviewStatus = historyReviewReviewed
+		}
+	case eventReviewOverdue:
+		entry.ReviewOverdue = true
+	}
+}
+
+func (b *backend) history(ctx context.Context, s logical.Storage, name, nonce string) (*historyStorageEntry, error) {
+
+	entry, err := b.historyAccessor.get(ctx, s, name, nonce)
+	if err != nil {
+		return nil, err
+	} else if entry == nil {
+		return nil, nil // Not found.
+	}
+
+	history := &historyStorageEntry{}
+	if err := json.Unmarshal(entry.Value, history); err != nil {
+		return nil, err
+	}
+
+	return history, nil
+}
+
+// completed returns whether no further events are expected for the entry.
+func (h *historyStorageEntry) completed() bool {
+	if h.ReviewStatus == historyReviewPending {
+		return false
+	}
+	switch h.Outcome {
+	case historyOutcomeDenied, historyOutcomeExpired, historyOutcomeCancelled, historyOutcomeRevoked:
+		return true
+	}
+	return false
+}
+
+// lastEventAt returns the time of the most recent event.
+func (h *historyStorageEntry) lastEventAt() time.Time {
+	if len(h.Events) == 0 {
+		return h.CreatedAt
+	}
+	return h.Events[len(h.Events)-1].Time
+}
+
+// involves returns whether identity requested or approved the request.
+func (h *historyStorageEntry) involves(identity string) bool {
+	if strings.ToLower(h.RequesterID) == strings.ToLower(identity) {
+		return true
+	}
+	for _, a := range h.Approvals {
+		if strings.ToLower(a.ApproverID) == strings.ToLower(identity) {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *historyStorageEntry) data() map[string]interface{} {
+	data := map[string]interface{}{
+		"nonce":        h.Nonce,
+		"role":         h.Role,
+		"requester_id": h.RequesterID,
+		"reason":       h.Reason,
+		"created_at":   h.CreatedAt,
+		"approvals":    h.Approvals,
+		"rejections":   h.Rejections,
+		"outcome":      h.Outcome,
+		"events":       h.Events,
+	}
+	if !h.IssuedAt.IsZero() {
+		data["issued_at"] = h.IssuedAt
+	}
+	if !h.RevokedAt.IsZero() {
+		data["revoked_at"] = h.RevokedAt
+	}
+	if h.BreakGlass {
+		data["break_glass"] = true
+		data["review_status"] = h.ReviewStatus
+		data["review_deadline"] = h.ReviewDeadline
+		data["review_overdue"] = h.ReviewOverdue
+		data["reviews_required"] = h.ReviewsRequired
+		data["reviews"] = h.Reviews
+	}
+	return data
+}
+
+type historyEvent struct {
+	Type   string    `json:"type"`
+	Actor  string    `json:"actor"`
+	Reason string    `json:"reason,omitempty"`
+	Time   time.Time `json:"time"`
+}
+
+type historyStorageEntry struct {
+	Nonce       string             `json:"nonce"`
+	Role        string             `json:"role"`
+	RequesterID string             `json:"requester_id"`
+	Reason      string             `json:"reason"`
+	Ticket      string             `json:"ticket,omitempty"`
+	TicketURL   string             `json:"ticket_url,omitempty"`
+	CreatedAt   time.Time          `json:"created_at"`
+	Approvals   []requestApproval  `json:"approvals"`
+	Rejections  []requestRejection `json:"rejections"`
+	IssuedAt    time.Time          `json:"issued_at"`
+	RevokedAt   time.Time          `json:"revoked_at"`
+	Outcome     string             `json:"outcome"`
+	Events      []historyEvent     `json:"events"`
+
+	BreakGlass      bool              `json:"break_glass,omitempty"`
+	ReviewStatus    string            `json:"review_status,omitempty"`
+	ReviewDeadline  time.Time         `json:"review_deadline,omitempty"`
+	ReviewsRequired int               `json:"reviews_required,omitempty"`
+	Reviews         []requestApproval `json:"reviews,omitempty"`
+	ReviewOverdue   bool              `json:"review_overdue,omitempty"`
+	SlackThreads    map[string]string `json:"slack_threads,omitempty"`
+}
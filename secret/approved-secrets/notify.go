@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ashwanthkumar/slack-go-webhook"
+	multierror "github.com/hashicorp/go-multierror"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/pkg/errors"
+)
+
+const (
+	eventRequestCreated       = "request.created"
+	eventRequestApproved      = "request.approved"
+	eventRequestFullyApproved = "request.fully_approved"
+	eventRequestRejected      = "request.rejected"
+	eventRequestDenied        = "request.denied"
+	eventRequestExpired       = "request.expired"
+	eventRequestCancelled     = "request.cancelled"
+	eventRequestAmended       = "request.amended"
+	eventSecretIssued         = "secret.issued"
+	eventSecretRevoked        = "secret.revoked"
+	eventSecretBreakGlass     = "secret.break_glass_issued"
+	eventReviewAcknowledged   = "review.acknowledged"
+	eventReviewOverdue        = "review.overdue"
+)
+
+type notificationEvent struct {
+	Type      string
+	Actor     string
+	Role      string
+	Nonce     string
+	Reason    string
+	Ticket    string
+	TicketURL string
+}
+
+func (e notificationEvent) text() string {
+	switch e.Type {
+	case eventRequestCreated:
+		return fmt.Sprintf("%s requests role *%q*", e.Actor, e.Role)
+	case eventRequestApproved:
+		return fmt.Sprintf("%s approved request for role *%q*", e.Actor, e.Role)
+	case eventRequestFullyApproved:
+		return fmt.Sprintf("Request for role *%q* is fully approved and can be issued", e.Role)
+	case eventRequestRejected:
+		return fmt.Sprintf("%s rejected request for role *%q*", e.Actor, e.Role)
+	case eventRequestDenied:
+		return fmt.Sprintf("%s denied request for role *%q*", e.Actor, e.Role)
+	case eventRequestExpired:
+		return fmt.Sprintf("Request for role *%q* expired", e.Role)
+	case eventRequestCancelled:
+		return fmt.Sprintf("%s cancelled request for role *%q*", e.Actor, e.Role)
+	case eventRequestAmended:
+		return fmt.Sprintf("%s amended request for role *%q*, previous approvals are reset", e.Actor, e.Role)
+	case eventSecretIssued:
+		return fmt.Sprintf("%s issued secret for role *%q*", e.Actor, e.Role)
+	case eventSecretRevoked:
+		return fmt.Sprintf("Secret for role *%q* revoked", e.Role)
+	case eventSecretBreakGlass:
+		return fmt.Sprintf("<!channel> :rotating_light: BREAK GLASS: %s issued secret for role *%q* without approval, review required", e.Actor, e.Role)
+	case eventReviewAcknowledged:
+		return fmt.Sprintf("%s reviewed break glass issue for role *%q*", e.Actor, e.Role)
+	case eventReviewOverdue:
+		return fmt.Sprintf("<!channel> :rotating_light: Review of break glass issue by %s for role *%q* is overdue", e.Actor, e.Role)
+	}
+	return fmt.Sprintf("%s: %s for role *%q*", e.Type, e.Actor, e.Role)
+}
+
+func (e notificationEvent) payload() slack.Payload {
+
+	attach := slack.Attachment{}
+	if e.Type == eventRequestCreated || e.Type == eventRequestAmended {
+		attach.AddField(slack.Field{Value: fmt.Sprintf("```vault-helper approved-secret-approve -role %s -nonce %s```", e.Role, e.Nonce)})
+		attach.AddField(slack.Field{Value: fmt.Sprintf("```vault-helper approved-secret-issue -role %s -nonce %s```", e.Role, e.Nonce)})
+	} else {
+		attach.AddField(slack.Field{Value: fmt.Sprintf("*Event:* %s", e.Type), Short: true})
+		attach.AddField(slack.Field{Value: fmt.Sprintf("*Nonce:* %s", e.Nonce), Short: true})
+	}
+	if e.Reason != "" {
+		attach.AddField(slack.Field{Value: fmt.Sprintf("*Reason:* %s", e.Reason)})
+	}
+	if e.Ticket != "" {
+		attach.AddField(slack.Field{Value: fmt.Sprintf("*Ticket:* %s", ticketLink(e.Ticket, e.TicketURL))})
+	}
+	if e.Type == eventSecretBreakGlass || e.Type == eventReviewOverdue {
+		color := "danger"
+		attach.Color = &color
+	}
+
+	return slack.Payload{
+		Text:        e.text(),
+		Username:    "Vault Approved Secrets Plugin",
+		Attachments: []slack.Attachment{attach},
+	}
+}
+
+// blocks returns Block Kit blocks with Approve and Reject buttons for events
+// that ask for approval, or nil. The buttons are handled by slack/callback.
+func (e notificationEvent) blocks() []interface{} {
+	if e.Type != eventRequestCreated && e.Type != eventRequestAmended {
+		return nil
+	}
+	return requestBlocks(e, "", true)
+}
+
+// requestBlocks returns the Block Kit blocks of the event's request message,
+// with status below the reason and, if actions is set, Approve and Reject
+// buttons.
+func requestBlocks(e notificationEvent, status string, actions bool) []interface{} {
+
+	mrkdwn := func(text string) map[string]interface{} {
+		return map[string]interface{}{"type": "mrkdwn", "text": text}
+	}
+
+	fields := []interface{}{mrkdwn(fmt.Sprintf("*Nonce:* %s", e.Nonce))}
+	if e.Reason != "" {
+		fields = append(fields, mrkdwn(fmt.Sprintf("*Reason:* %s", e.Reason)))
+	}
+	if e.Ticket != "" {
+		fields = append(fields, mrkdwn(fmt.Sprintf("*Ticket:* %s", ticketLink(e.Ticket, e.TicketURL))))
+	}
+	if status != "" {
+		fields = append(fields, mrkdwn(fmt.Sprintf("*Status:* %s", status)))
+	}
+
+	blocks := []interface{}{
+		map[string]interface{}{"type": "section", "text": mrkdwn(e.text())},
+		map[string]interface{}{"type": "context", "elements": fields},
+	}
+
+	if actions {
+		value := e.Role + "/" + e.Nonce
+		button := func(actionID, text, style string) map[string]interface{} {
+			return map[string]interface{}{
+				"type":      "button",
+				"action_id": actionID,
+				"style":     style,
+				"value":     value,
+				"text":      map[string]interface{}{"type": "plain_text", "text": text},
+			}
+		}
+		blocks = append(blocks, map[string]interface{}{
+			"type":     "actions",
+			"elements": []interface{}{button(slackActionApprove, "Approve", "primary"), button(slackActionReject, "Reject", "danger")},
+		})
+	}
+
+	return blocks
+}
+
+// notify sends the event to the given Slack channels. If a Slack bot token is
+// configured, messages are posted as replies in the thread of the original
+// request message. The returned map holds the thread timestamp per channel,
+// which must be passed again for subsequent events of the same request. A
+// failing channel does not prevent notifying the others; the errors of all
+// failing channels are returned together.
+func (b *backend) notify(cfg *configStorageEntry, channels []string, threads map[string]string, e notificationEvent) (map[string]string, error) {
+
+	if threads == nil {
+		threads = map[string]string{}
+	}
+
+	var result *multierror.Error
+	payload := e.payload()
+	for _, c := range channels {
+		if cfg.SlackBotToken != "" {
+			ts, err := newSlackClient(slackAPIURL, cfg.SlackBotToken).postMessage(c, threads[c], payload, e.blocks())
+			if err != nil {
+				result = multierror.Append(result, errors.Wrapf(err, "failed to send Slack notification to channel %q", c))
+				continue
+			}
+			if _, ok := threads[c]; !ok {
+				threads[c] = ts
+			}
+		} else if cfg.SlackWebhookURL != "" {
+			payload.Channel = c
+			if err := sendWebhook(cfg.SlackWebhookURL, payload); err != nil {
+				result = multierror.Append(result, errors.Wrapf(err, "failed to send Slack notification to channel %q", c))
+			}
+		}
+	}
+
+	return threads, result.ErrorOrNil()
+}
+
+// recordLeaseEnd records the event of a lease revocation in history and
+// notifies the role's channels. Failures are logged instead of returned to
+// prevent failing revocations.
+func (b *backend) recordLeaseEnd(ctx context.Context, r *logical.Request, roleName string, threads map[string]string, e notificationEvent) (*logical.Response, error) {
+
+	if err := b.appendHistory(ctx, r.Storage, e); err != nil {
+		b.Logger().Warn("failed to record history", "event", e.Type, "role", roleName, "nonce", e.Nonce, "error", err)
+	}
+
+	role, err := b.role(ctx, r.Storage, roleName)
+	if err != nil || role == nil {
+		return nil, nil // Role is gone, nothing to notify.
+	}
+
+	cfg, err := b.config(ctx, r.Storage)
+	if err != nil || cfg == nil {
+		return nil, nil // No config, nothing to notify.
+	}
+
+	if _, err = b.notify(cfg, role.NotifySlackChannels, threads, e); err != nil {
+		b.Logger().Warn("failed to notify", "event", e.Type, "role", roleName, "nonce", e.Nonce, "error", err)
+	}
+
+	return nil, nil
+}
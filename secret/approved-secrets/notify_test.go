@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ashwanthkumar/slack-go-webhook"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// slackWebhook records the texts of the payloads posted to it.
+type slackWebhook struct {
+	*httptest.Server
+	mu    sync.Mutex
+	texts []string
+}
+
+func newSlackWebhook() *slackWebhook {
+	wh := &slackWebhook{}
+	wh.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload slack.Payload
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		wh.mu.Lock()
+		wh.texts = append(wh.texts, payload.Channel+" "+payload.Text)
+		wh.mu.Unlock()
+		w.Write([]byte("ok"))
+	}))
+	return wh
+}
+
+// reset returns the texts posted since the last reset.
+func (wh *slackWebhook) reset() []string {
+	wh.mu.Lock()
+	defer wh.mu.Unlock()
+	texts := wh.texts
+	wh.texts = nil
+	return texts
+}
+
+func TestNotify_Events(t *testing.T) {
+	vault := breakGlassVault()
+	defer vault.Close()
+	webhook := newSlackWebhook()
+	defer webhook.Close()
+
+	b, storage := getBackendWithEntities(t, vault.URL)
+	ctx := context.Background()
+
+	cfg, err := b.config(ctx, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.SlackWebhookURL = webhook.URL
+	if err = b.configAccessor.put(ctx, storage, cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	role := &roleStorageEntry{SecretPath: "secret/admin", SecretPathMethod: "GET", SecretTTL: time.Hour, SecretMaxTTL: time.Hour, MinApprovers: 2, MinRejecters: 2, NotifySlackChannels: []string{"#ops"}}
+	if err = b.roleAccessor.put(ctx, storage, role, "admin"); err != nil {
+		t.Fatal(err)
+	}
+
+	handle := func(path, entityID string, data map[string]interface{}) *logical.Response {
+		resp, err := b.HandleRequest(ctx, &logical.Request{Operation: logical.UpdateOperation, Path: path, Storage: storage, EntityID: entityID, Data: data})
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("%s: err:%s resp:%#v\n", path, err, resp)
+		}
+		return resp
+	}
+	expect := func(step string, expected ...string) {
+		texts := webhook.reset()
+		if len(texts) != len(expected) {
+			t.Fatalf("%s: expected %d notification(s), got %q\n", step, len(expected), texts)
+		}
+		for i, text := range texts {
+			if !strings.HasPrefix(text, "#ops ") || !strings.Contains(text, expected[i]) {
+				t.Fatalf("%s: expected notification %q, got %q\n", step, expected[i], text)
+			}
+		}
+	}
+
+	nonce := handle("request/admin", "requester", map[string]interface{}{"reason": "deploy"}).Data["nonce"].(string)
+	expect("create", `requester requests role *"admin"*`)
+
+	handle("approve/admin", "one", map[string]interface{}{"nonce": nonce})
+	expect("approve", `one approved request for role *"admin"*`)
+
+	handle("approve/admin", "two", map[string]interface{}{"nonce": nonce})
+	expect("fully approve", `two approved request for role *"admin"*`, `Request for role *"admin"* is fully approved`)
+
+	handle("issue/admin/"+nonce, "requester", nil)
+	expect("issue", `requester issued secret for role *"admin"*`)
+
+	handle("leases/admin/"+nonce+"/revoke", "", nil)
+	expect("revoke", `Secret for role *"admin"* revoked`)
+
+	nonce = handle("request/admin", "requester", map[string]interface{}{"reason": "deploy again"}).Data["nonce"].(string)
+	expect("create", `requester requests role *"admin"*`)
+
+	handle("reject/admin", "one", map[string]interface{}{"nonce": nonce, "comment": "no"})
+	expect("reject", `one rejected request for role *"admin"*`)
+}
+
+func TestNotify_Timeout(t *testing.T) {
+	hung := make(chan struct{})
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-hung
+	}))
+	defer webhook.Close()
+	defer close(hung)
+
+	timeout := slackHTTPClient.Timeout
+	slackHTTPClient.Timeout = 100 * time.Millisecond
+	defer func() { slackHTTPClient.Timeout = timeout }()
+
+	b, _ := getBackend(t)
+	start := time.Now()
+	_, err := b.notify(&configStorageEntry{SlackWebhookURL: webhook.URL}, []string{"#ops"}, nil, notificationEvent{Type: eventRequestCreated, Actor: "requester", Role: "admin", Nonce: "nonce"})
+	if err == nil {
+		t.Fatalf("Expected error for hung Slack webhook\n")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("Expected notification to time out, took %s\n", elapsed)
+	}
+}
+
+func TestNotify_FailingChannel(t *testing.T) {
+	var mu sync.Mutex
+	var posted []string
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var msg slackMessage
+		json.NewDecoder(r.Body).Decode(&msg)
+		if msg.Channel == "#broken" {
+			json.NewEncoder(w).Encode(slackResponse{Error: "channel_not_found"})
+			return
+		}
+		mu.Lock()
+		posted = append(posted, msg.Channel)
+		mu.Unlock()
+		json.NewEncoder(w).Encode(slackResponse{OK: true, TS: "ts-" + msg.Channel})
+	}))
+	defer api.Close()
+
+	apiURL := slackAPIURL
+	slackAPIURL = api.URL
+	defer func() { slackAPIURL = apiURL }()
+
+	b, _ := getBackend(t)
+	threads, err := b.notify(&configStorageEntry{SlackBotToken: "xoxb-token"}, []string{"#ops", "#broken", "#sre"}, nil, notificationEvent{Type: eventRequestCreated, Actor: "requester", Role: "admin", Nonce: "nonce"})
+	if err == nil || !strings.Contains(err.Error(), `"#broken"`) || strings.Contains(err.Error(), `"#sre"`) {
+		t.Fatalf("Expected error for failing channel only, got %v\n", err)
+	}
+	if len(posted) != 2 || posted[1] != "#sre" {
+		t.Fatalf("Expected channels after the failing channel to be notified, got %v\n", posted)
+	}
+	if len(threads) != 2 || threads["#ops"] != "ts-#ops" || threads["#sre"] != "ts-#sre" {
+		t.Fatalf("Expected threads of notified channels, got %v\n", threads)
+	}
+}
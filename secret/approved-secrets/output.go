@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"text/template"
+
+	"github.com/pkg/errors"
+)
+
+// outputTemplateFuncs are the functions available in a role's
+// output_template next to the text/template builtins.
+var outputTemplateFuncs = template.FuncMap{
+	"b64enc": func(s string) string {
+		return base64.StdEncoding.EncodeToString([]byte(s))
+	},
+	"b64dec": func(s string) (string, error) {
+		bs, err := base64.StdEncoding.DecodeString(s)
+		return string(bs), err
+	},
+	"indent": func(spaces int, s string) string {
+		pad := strings.Repeat(" ", spaces)
+		return pad + strings.Replace(s, "\n", "\n"+pad, -1)
+	},
+	"join": func(sep string, list []interface{}) string {
+		ss := make([]string, len(list))
+		for i, v := range list {
+			ss[i] = fmt.Sprint(v)
+		}
+		return strings.Join(ss, sep)
+	},
+}
+
+func parseOutputTemplate(text string) (*template.Template, error) {
+	return template.New("output").Funcs(outputTemplateFuncs).Option("missingkey=error").Parse(text)
+}
+
+// renderOutput renders the role's output_template with the secret's data,
+// for example a kubeconfig or .pgpass.
+func renderOutput(text string, data map[string]interface{}) (string, error) {
+
+	tpl, err := parseOutputTemplate(text)
+	if err != nil {
+		return "", errors.Wrap(err, "invalid output_template")
+	}
+
+	var buf bytes.Buffer
+	if err = tpl.Execute(&buf, data); err != nil {
+		return "", errors.Wrap(err, "failed to render output_template")
+	}
+
+	return buf.String(), nil
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestOutput_Render(t *testing.T) {
+
+	data := map[string]interface{}{
+		"hostname": "db.yolt.io",
+		"username": "v-admin",
+		"password": "secret",
+	}
+
+	output, err := renderOutput("{{.hostname}}:5432:*:{{.username}}:{{.password}}", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if output != "db.yolt.io:5432:*:v-admin:secret" {
+		t.Fatalf("Unexpected output: %q\n", output)
+	}
+
+	output, err = renderOutput("{{b64enc .password}}", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if output != "c2VjcmV0" {
+		t.Fatalf("Unexpected output: %q\n", output)
+	}
+
+	// Elements of any type are joined, like numbers of Vault responses.
+	output, err = renderOutput(`{{join "," .hosts}}`, map[string]interface{}{"hosts": []interface{}{"db1", json.Number("5432"), true}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if output != "db1,5432,true" {
+		t.Fatalf("Unexpected output: %q\n", output)
+	}
+
+	if _, err = renderOutput("{{.missing}}", data); err == nil {
+		t.Fatalf("Expected error for missing key\n")
+	}
+	if _, err = renderOutput("{{.hostname", data); err == nil {
+		t.Fatalf("Expected error for invalid template\n")
+	}
+}
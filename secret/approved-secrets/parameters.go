@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/strutil"
+	"github.com/pkg/errors"
+)
+
+// parameterTypes maps the types of request parameters to field types.
+var parameterTypes = map[string]framework.FieldType{
+	"string": framework.TypeString,
+	"int":    framework.TypeInt,
+	"bool":   framework.TypeBool,
+	"list":   framework.TypeCommaStringSlice,
+}
+
+// requestParameter describes a parameter that is filled by the requester,
+// approved with the request and sent to the secret path on issue.
+type requestParameter struct {
+	Type          string      `json:"type"`
+	Description   string      `json:"description,omitempty"`
+	Required      bool        `json:"required,omitempty"`
+	Pattern       string      `json:"pattern,omitempty"`
+	AllowedValues []string    `json:"allowed_values,omitempty"`
+	Default       interface{} `json:"default,omitempty"`
+}
+
+// parseRequestParameters parses a role's parameter schema, for example
+// {"common_name": {"type": "string", "pattern": "[a-z]+\\.yolt\\.io"}}.
+func parseRequestParameters(raw map[string]interface{}) (map[string]*requestParameter, error) {
+
+	schema := make(map[string]*requestParameter, len(raw))
+	for name, specRaw := range raw {
+		bs, err := json.Marshal(specRaw)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid parameter %q", name)
+		}
+
+		dec := json.NewDecoder(bytes.NewReader(bs))
+		dec.DisallowUnknownFields()
+		p := &requestParameter{}
+		if err = dec.Decode(p); err != nil {
+			return nil, errors.Wrapf(err, "invalid parameter %q", name)
+		}
+
+		if p.Type == "" {
+			p.Type = "string"
+		}
+		if _, ok := parameterTypes[p.Type]; !ok {
+			return nil, errors.Errorf("invalid type %q of parameter %q (expected string, int, bool or list)", p.Type, name)
+		}
+
+		if _, err = p.regexp(); err != nil {
+			return nil, errors.Wrapf(err, "invalid pattern of parameter %q", name)
+		}
+
+		schema[name] = p
+	}
+
+	// Defaults must satisfy their own constraints.
+	for name, p := range schema {
+		if p.Default == nil {
+			continue
+		}
+		if _, err := validateRequestParameters(map[string]*requestParameter{name: p}, nil); err != nil {
+			return nil, errors.Wrapf(err, "invalid default")
+		}
+	}
+
+	return schema, nil
+}
+
+func (p *requestParameter) regexp() (*regexp.Regexp, error) {
+	if p.Pattern == "" {
+		return nil, nil
+	}
+	return regexp.Compile("^(?:" + p.Pattern + ")$")
+}
+
+// validateRequestParameters validates raw against schema, applies defaults
+// and returns the typed parameters.
+func validateRequestParameters(schema map[string]*requestParameter, raw map[string]interface{}) (map[string]interface{}, error) {
+
+	d := &framework.FieldData{
+		Raw:    map[string]interface{}{},
+		Schema: map[string]*framework.FieldSchema{},
+	}
+
+	for name, value := range raw {
+		if _, ok := schema[name]; !ok {
+			return nil, errors.Errorf("parameter %q is not allowed", name)
+		}
+		d.Raw[name] = value
+	}
+
+	var names []string
+	for name, p := range schema {
+		names = append(names, name)
+		d.Schema[name] = &framework.FieldSchema{Type: parameterTypes[p.Type]}
+		if _, ok := d.Raw[name]; !ok && p.Default != nil {
+			d.Raw[name] = p.Default
+		}
+	}
+	sort.Strings(names)
+
+	params := make(map[string]interface{})
+	for _, name := range names {
+		p := schema[name]
+		value, ok, err := d.GetOkErr(name)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid value of parameter %q", name)
+		} else if !ok {
+			if p.Required {
+				return nil, errors.Errorf("missing required parameter %q", name)
+			}
+			continue
+		}
+
+		if err = p.check(value); err != nil {
+			return nil, errors.Wrapf(err, "invalid value of parameter %q", name)
+		}
+
+		params[name] = value
+	}
+
+	return params, nil
+}
+
+// check verifies a typed value against the pattern and allowed values.
+func (p *requestParameter) check(value interface{}) error {
+
+	values := []string{fmt.Sprint(value)}
+	if list, ok := value.([]string); ok {
+		values = list
+	}
+
+	re, _ := p.regexp()
+	for _, v := range values {
+		if re != nil && !re.MatchString(v) {
+			return errors.Errorf("%q does not match %q", v, p.Pattern)
+		}
+		if len(p.AllowedValues) > 0 && !strutil.StrListContains(p.AllowedValues, v) {
+			return errors.Errorf("%q not in %s", v, p.AllowedValues)
+		}
+	}
+
+	return nil
+}
+
+// parametersEqual reports whether both parameters are equal after JSON
+// encoding, so stored and typed values can be compared.
+func parametersEqual(a, b map[string]interface{}) bool {
+	aa, err := json.Marshal(a)
+	if err != nil {
+		return false
+	}
+	bb, err := json.Marshal(b)
+	if err != nil {
+		return false
+	}
+	return bytes.Equal(aa, bb)
+}
+
+// rawParameters returns the request data that is not part of the path's
+// field schema, or the ttl passed to the secret path.
+func rawParameters(d *framework.FieldData) map[string]interface{} {
+
+	params := make(map[string]interface{})
+	for k, v := range d.Raw {
+		if _, ok := d.Schema[k]; ok || k == "ttl" {
+			continue
+		}
+		params[k] = v
+	}
+
+	return params
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestParameters_Validate(t *testing.T) {
+
+	schema, err := parseRequestParameters(map[string]interface{}{
+		"common_name": map[string]interface{}{"type": "string", "required": true, "pattern": `[a-z]+\.yolt\.io`},
+		"ip_sans":     map[string]interface{}{"type": "list", "pattern": `10\.0\.\d+\.\d+`},
+		"format":      map[string]interface{}{"allowed_values": []string{"pem", "der"}, "default": "pem"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	params, err := validateRequestParameters(schema, map[string]interface{}{"common_name": "app.yolt.io", "ip_sans": "10.0.0.1,10.0.0.2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]interface{}{"common_name": "app.yolt.io", "ip_sans": []string{"10.0.0.1", "10.0.0.2"}, "format": "pem"}
+	if !parametersEqual(expected, params) {
+		t.Fatalf("Unexpected parameters: expected %#v got %#v\n", expected, params)
+	}
+
+	for _, raw := range []map[string]interface{}{
+		{},
+		{"common_name": "app.example.com"},
+		{"common_name": "app.yolt.io", "ip_sans": "192.168.0.1"},
+		{"common_name": "app.yolt.io", "format": "p12"},
+		{"common_name": "app.yolt.io", "ttl_override": "1h"},
+	} {
+		if _, err := validateRequestParameters(schema, raw); err == nil {
+			t.Fatalf("Expected error for parameters %#v\n", raw)
+		}
+	}
+
+	for _, spec := range []map[string]interface{}{
+		{"type": "float"},
+		{"pattern": "("},
+		{"allowed_values": []string{"a"}, "default": "b"},
+		{"unknown": true},
+	} {
+		if _, err := parseRequestParameters(map[string]interface{}{"p": spec}); err == nil {
+			t.Fatalf("Expected error for parameter spec %#v\n", spec)
+		}
+	}
+}
+
+func TestParameters_Issue(t *testing.T) {
+
+	schema, err := parseRequestParameters(map[string]interface{}{
+		"common_name": map[string]interface{}{"required": true},
+		"port":        map[string]interface{}{"type": "int"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	role := &roleStorageEntry{Parameters: schema}
+
+	// Round trip through storage turns ints into floats.
+	var stored map[string]interface{}
+	bs, _ := json.Marshal(map[string]interface{}{"common_name": "app.yolt.io", "port": 443})
+	if err = json.Unmarshal(bs, &stored); err != nil {
+		t.Fatal(err)
+	}
+	sr := &requestStorageEntry{Parameters: stored}
+
+	if _, err := issueParameters(role, sr, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := issueParameters(role, sr, map[string]interface{}{"port": "443"}); err != nil {
+		t.Fatalf("Expected repeated approved parameter to be allowed: %s\n", err)
+	}
+	if _, err := issueParameters(role, sr, map[string]interface{}{"common_name": "other.yolt.io"}); err == nil {
+		t.Fatalf("Expected deviating parameter to be refused\n")
+	}
+}
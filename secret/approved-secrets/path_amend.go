@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/pkg/errors"
+)
+
+func pathAmend(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "amend/" + framework.GenericNameRegex("name"),
+		Fields: map[string]*framework.FieldSchema{
+			"name": {
+				Type:        framework.TypeString,
+				Description: "Name of role for amend.",
+				Required:    true,
+			},
+			"nonce": {
+				Type:        framework.TypeString,
+				Description: "Nonce generated by request.",
+				Required:    true,
+			},
+			"reason": {
+				Type:        framework.TypeString,
+				Description: "New reason for requesting secret.",
+			},
+		},
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.CreateOperation: b.pathAmendCreateUpdate,
+			logical.UpdateOperation: b.pathAmendCreateUpdate,
+		},
+	}
+}
+
+// pathAmendCreateUpdate changes the reason or parameters of a request. All
+// approvals are reset, so the amended request must be approved again.
+func (b *backend) pathAmendCreateUpdate(ctx context.Context, r *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+
+	name := d.Get("name").(string)
+	role, err := b.role(ctx, r.Storage, name)
+	if err != nil {
+		return nil, err
+	}
+	if role == nil {
+		return logical.ErrorResponse(fmt.Sprintf("role %q does not exists", name)), nil
+	}
+
+	callerID, err := b.callerID(ctx, r)
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	nonce := d.Get("nonce").(string)
+
+	unlock := b.lockRequest(name, nonce)
+	defer unlock()
+
+	sr, err := b.request(ctx, r.Storage, name, nonce)
+	if err != nil {
+		return nil, err
+	}
+	if sr == nil {
+		return logical.ErrorResponse(fmt.Sprintf("request does not exists for role %q with nonce %q (expired or already issued?)", name, nonce)), nil
+	}
+
+	if strings.ToLower(callerID) != strings.ToLower(sr.RequesterID) {
+		return logical.ErrorResponse("only requester %q is allowed to amend request", sr.RequesterID), nil
+	}
+
+	reason, reasonOk := d.GetOk("reason")
+	raw := rawParameters(d)
+	if !reasonOk && len(raw) == 0 {
+		return logical.ErrorResponse("nothing to amend, reason or parameters required"), nil
+	}
+
+	if reasonOk {
+		if reason.(string) == "" {
+			return logical.ErrorResponse("field 'reason' must not be empty"), nil
+		}
+		sr.Reason = reason.(string)
+	}
+
+	if len(raw) > 0 {
+		if len(role.Parameters) == 0 {
+			return logical.ErrorResponse(fmt.Sprintf("role %q has no parameters", name)), nil
+		}
+
+		// Amended parameters replace the approved ones, omitted parameters are kept.
+		merged := make(map[string]interface{})
+		for k, v := range sr.Parameters {
+			merged[k] = v
+		}
+		for k, v := range raw {
+			merged[k] = v
+		}
+		if sr.Parameters, err = validateRequestParameters(role.Parameters, merged); err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		}
+	}
+
+	now := time.Now()
+	if err = sr.transition(requestPending, now); err != nil {
+		return logical.ErrorResponse(fmt.Sprintf("request for role %q with nonce %q cannot be amended: %s", name, nonce, err)), nil
+	}
+
+	// Rejections are kept, so amending cannot undo objections that count
+	// toward min_rejecters.
+	sr.ApproverIDs = []string{}
+	sr.Approvals = nil
+	sr.AutoApproved = false
+
+	if err = evaluatePolicy(policyPhaseRequest, name, role, sr, now); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	ttl := sr.TTL
+	if ttl == 0 {
+		ttl = role.SecretTTL
+	}
+	rule := b.autoApprove(r, role, sr, ttl, now)
+
+	if sr.approved() {
+		if err = sr.transition(requestApproved, now); err != nil {
+			return nil, err
+		}
+	}
+
+	if err = b.putRequest(ctx, r.Storage, sr, name, nonce); err != nil {
+		return nil, err
+	}
+
+	resp := &logical.Response{
+		Data: map[string]interface{}{
+			"nonce":         sr.Nonce,
+			"state":         sr.State,
+			"version":       sr.Version,
+			"expires_at":    sr.ExpiresAt,
+			"requester_id":  sr.RequesterID,
+			"reason":        sr.Reason,
+			"parameters":    sr.Parameters,
+			"approver_ids":  sr.ApproverIDs,
+			"min_approvers": sr.MinApprovers,
+		},
+	}
+
+	cfg, err := b.config(ctx, r.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	event := notificationEvent{
+		Type:      eventRequestAmended,
+		Actor:     callerID,
+		Role:      name,
+		Nonce:     nonce,
+		Reason:    sr.Reason,
+		Ticket:    sr.Ticket,
+		TicketURL: sr.TicketURL,
+	}
+	if err = b.appendHistory(ctx, r.Storage, event); err != nil {
+		return nil, errors.Wrapf(err, "failed to record history")
+	}
+	if _, err = b.notify(cfg, role.NotifySlackChannels, sr.SlackThreads, event); err != nil {
+		resp.AddWarning(err.Error())
+	}
+
+	if rule != nil {
+		resp.Data["auto_approve_rule"] = rule.String()
+		if err = b.recordAutoApproval(ctx, r.Storage, cfg, role, name, sr, rule, resp); err != nil {
+			return nil, err
+		}
+	}
+
+	return resp, nil
+}
@@ -3,8 +3,8 @@ package main
 import (
 	"context"
 	"fmt"
-	"path"
 	"strings"
+	"time"
 
 	"github.com/hashicorp/vault/sdk/framework"
 	"github.com/hashicorp/vault/sdk/logical"
@@ -25,6 +25,14 @@ func pathApprove(b *backend) *framework.Path {
 				Description: "Nonce generated by request.",
 				Required:    true,
 			},
+			"comment": {
+				Type:        framework.TypeString,
+				Description: "Optional comment for the approval.",
+			},
+			"totp": {
+				Type:        framework.TypeString,
+				Description: "TOTP code, required if the role requires MFA.",
+			},
 		},
 		Callbacks: map[logical.Operation]framework.OperationFunc{
 			logical.CreateOperation: b.pathApproveCreateUpdate,
@@ -46,6 +54,9 @@ func (b *backend) pathApproveCreateUpdate(ctx context.Context, r *logical.Reques
 
 	nonce := d.Get("nonce").(string)
 
+	unlock := b.lockRequest(name, nonce)
+	defer unlock()
+
 	sr, err := b.request(ctx, r.Storage, name, nonce)
 	if err != nil {
 		return nil, err
@@ -54,41 +65,105 @@ func (b *backend) pathApproveCreateUpdate(ctx context.Context, r *logical.Reques
 		return logical.ErrorResponse(fmt.Sprintf("request does not exists for role %q with nonce %q (expired or already issued?)", name, nonce)), nil
 	}
 
+	if sr.Rejected {
+		return logical.ErrorResponse(fmt.Sprintf("request for role %q with nonce %q is rejected", name, nonce)), nil
+	}
+
 	approverID, err := b.validateBoundApproverIDs(ctx, r, sr)
 	if err != nil {
 		return logical.ErrorResponse("failed to validate bound_approver_ids: " + err.Error()), nil
 	}
 
-	if err = b.verifyCallerRoles(ctx, r, role.BoundApproverRoles); err != nil {
-		return logical.ErrorResponse("failed to validate bound_requester_roles: " + err.Error()), nil
+	approverGroups, err := b.verifyCallerGroups(r, role.BoundApproverRoles, role.BoundApproverGroups)
+	if err != nil {
+		return logical.ErrorResponse("failed to validate bound_approver_roles and bound_approver_groups: " + err.Error()), nil
 	}
 
+	if err = b.requireTOTP(ctx, r.Storage, role, approverID, d.Get("totp").(string)); err != nil {
+		return logical.ErrorResponse("failed to verify MFA: " + err.Error()), nil
+	}
+
+	now := time.Now()
+	wasApproved := sr.state(now) == requestApproved
+
+	comment := d.Get("comment").(string)
 	sr.ApproverIDs = append(sr.ApproverIDs, strings.ToLower(approverID))
-	storagePath := path.Join("request", name, strings.ToLower(nonce))
-	entry, err := logical.StorageEntryJSON(storagePath, &sr)
-	if err != nil {
-		return nil, err
+	sr.Approvals = append(sr.Approvals, requestApproval{
+		ApproverID: strings.ToLower(approverID),
+		Comment:    comment,
+		Groups:     approverGroups,
+		ApprovedAt: now,
+	})
+
+	if err = evaluatePolicy(policyPhaseApprove, name, role, sr, now); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	next := requestPending
+	if wasApproved || sr.approved() {
+		next = requestApproved
 	}
-	if entry == nil {
-		return nil, fmt.Errorf("failed to create storage entry for request for role %q with nonce %q", name, nonce)
+	if err = sr.transition(next, now); err != nil {
+		return logical.ErrorResponse(fmt.Sprintf("request for role %q with nonce %q cannot be approved: %s", name, nonce, err)), nil
 	}
-	if err = r.Storage.Put(ctx, entry); err != nil {
+
+	if err = b.putRequest(ctx, r.Storage, sr, name, nonce); err != nil {
 		return nil, err
 	}
 
 	data := map[string]interface{}{
 		"nonce":                 sr.Nonce,
+		"state":                 sr.State,
+		"version":               sr.Version,
 		"expires_at":            sr.ExpiresAt,
 		"requester_id":          sr.RequesterID,
+		"reason":                sr.Reason,
 		"approver_ids":          sr.ApproverIDs,
+		"approvals":             sr.Approvals,
 		"min_approvers":         sr.MinApprovers,
+		"approval_rules":        approvalRuleSetsString(sr.ApprovalRules),
+		"approved":              sr.approved(),
 		"bound_requester_ids":   sr.BoundRequesterIDs,
 		"bound_requester_roles": sr.BoundRequesterRoles,
 		"bound_approver_ids":    sr.BoundApproverIDs,
 		"bound_approver_roles":  sr.BoundApproverRoles,
+		"bound_approver_groups": sr.BoundApproverGroups,
+		"parameters":            sr.Parameters,
 	}
 
-	return &logical.Response{Data: data}, nil
+	resp := &logical.Response{Data: data}
+
+	cfg, err := b.config(ctx, r.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	event := notificationEvent{
+		Type:   eventRequestApproved,
+		Actor:  approverID,
+		Role:   name,
+		Nonce:  nonce,
+		Reason: comment,
+	}
+	if err = b.appendHistory(ctx, r.Storage, event); err != nil {
+		return nil, errors.Wrapf(err, "failed to record history")
+	}
+	if _, err = b.notify(cfg, role.NotifySlackChannels, sr.SlackThreads, event); err != nil {
+		resp.AddWarning(err.Error())
+	}
+
+	if !wasApproved && sr.State == requestApproved {
+		event.Type = eventRequestFullyApproved
+		event.Reason = sr.Reason
+		if err = b.appendHistory(ctx, r.Storage, event); err != nil {
+			return nil, errors.Wrapf(err, "failed to record history")
+		}
+		if _, err = b.notify(cfg, role.NotifySlackChannels, sr.SlackThreads, event); err != nil {
+			resp.AddWarning(err.Error())
+		}
+	}
+
+	return resp, nil
 }
 
 func (b *backend) validateBoundApproverIDs(ctx context.Context, r *logical.Request, sr *requestStorageEntry) (string, error) {
@@ -103,8 +178,18 @@ func (b *backend) validateBoundApproverIDs(ctx context.Context, r *logical.Reque
 		return "", errors.New("failed to get caller's identity: " + err.Error())
 	}
 
+	if err = checkApprover(callerID, sr); err != nil {
+		return "", err
+	}
+
+	return callerID, nil
+}
+
+// checkApprover verifies that callerID may approve or reject the request.
+func checkApprover(callerID string, sr *requestStorageEntry) error {
+
 	if strings.ToLower(callerID) == strings.ToLower(sr.RequesterID) {
-		return "", errors.New("cannot approve your own request")
+		return errors.New("cannot approve your own request")
 	}
 
 	if len(sr.BoundApproverIDs) > 0 {
@@ -116,15 +201,21 @@ func (b *backend) validateBoundApproverIDs(ctx context.Context, r *logical.Reque
 			}
 		}
 		if !approved {
-			return "", errors.New(fmt.Sprintf("%q not in %s", callerID, sr.BoundApproverIDs))
+			return errors.New(fmt.Sprintf("%q not in %s", callerID, sr.BoundApproverIDs))
 		}
 	}
 
 	for _, a := range sr.ApproverIDs {
 		if strings.ToLower(callerID) == strings.ToLower(a) {
-			return "", errors.New("already approved by you")
+			return errors.New("already approved by you")
 		}
 	}
 
-	return callerID, nil
+	for _, rejection := range sr.Rejections {
+		if strings.ToLower(callerID) == strings.ToLower(rejection.RejecterID) {
+			return errors.New("already rejected by you")
+		}
+	}
+
+	return nil
 }
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	uuid "github.com/hashicorp/go-uuid"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/pkg/errors"
+)
+
+func pathBreakGlass(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "break-glass/" + framework.GenericNameRegex("name"),
+		Fields: map[string]*framework.FieldSchema{
+			"name": {
+				Type:        framework.TypeString,
+				Description: "Name of role for break glass issue.",
+				Required:    true,
+			},
+			"reason": {
+				Type:        framework.TypeString,
+				Description: "Reason for issuing secret without approval.",
+				Required:    true,
+			},
+			"ttl": {
+				Type:        framework.TypeDurationSecond,
+				Description: "Requested duration in seconds of the secret (capped to break_glass_max_ttl).",
+			},
+			"totp": {
+				Type:        framework.TypeString,
+				Description: "TOTP code, required if the role requires MFA.",
+			},
+		},
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.CreateOperation: b.pathBreakGlassCreateUpdate,
+			logical.UpdateOperation: b.pathBreakGlassCreateUpdate,
+		},
+	}
+}
+
+func (b *backend) pathBreakGlassCreateUpdate(ctx context.Context, r *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+
+	roleName := d.Get("name").(string)
+	role, err := b.role(ctx, r.Storage, roleName)
+	if err != nil {
+		return nil, err
+	} else if role == nil {
+		return logical.ErrorResponse(fmt.Sprintf("role %q does not exists", roleName)), nil
+	}
+
+	if !role.BreakGlass {
+		return logical.ErrorResponse(fmt.Sprintf("break glass is not enabled for role %q", roleName)), nil
+	}
+
+	reason := d.Get("reason").(string)
+	if reason == "" {
+		return logical.ErrorResponse("field 'reason' is mandatory"), nil
+	}
+
+	params := rawParameters(d)
+	if len(role.Parameters) > 0 {
+		if params, err = validateRequestParameters(role.Parameters, params); err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		}
+	}
+
+	for _, field := range role.SecretRequiredFields {
+		if _, ok := params[field]; !ok {
+			return logical.ErrorResponse(fmt.Sprintf("missing required field %q", field)), nil
+		}
+	}
+
+	cfg, err := b.config(ctx, r.Storage)
+	if err != nil {
+		return logical.ErrorResponse("could not find config: " + err.Error()), nil
+	}
+
+	issuerID, err := b.validateBoundRequesterIDs(ctx, r, role)
+	if err != nil {
+		return logical.ErrorResponse("failed to validate bound_requester_ids: " + err.Error()), nil
+	}
+
+	issuerGroups, err := b.verifyCallerGroups(r, role.BoundRequesterRoles, role.BoundRequesterGroups)
+	if err != nil {
+		return logical.ErrorResponse("failed to validate bound_requester_roles and bound_requester_groups: " + err.Error()), nil
+	}
+
+	sr := &requestStorageEntry{RequesterID: issuerID, RequesterGroups: issuerGroups, Reason: reason, Parameters: params}
+	if err = evaluatePolicy(policyPhaseBreakGlass, roleName, role, sr, time.Now()); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	if err = b.requireTOTP(ctx, r.Storage, role, issuerID, d.Get("totp").(string)); err != nil {
+		return logical.ErrorResponse("failed to verify MFA: " + err.Error()), nil
+	}
+
+	nonce, err := uuid.GenerateUUID()
+	if err != nil {
+		return logical.ErrorResponse("failed to create nonce" + err.Error()), nil
+	}
+
+	ttl, ttlWarning := role.secretTTL(d)
+	if ttl > role.BreakGlassMaxTTL {
+		ttlWarning = fmt.Sprintf("Specified ttl is greater than role's break_glass_max_ttl, capped to: %s", role.BreakGlassMaxTTL)
+		ttl = role.BreakGlassMaxTTL
+	}
+
+	event := notificationEvent{
+		Type:   eventRequestCreated,
+		Actor:  issuerID,
+		Role:   roleName,
+		Nonce:  nonce,
+		Reason: reason,
+	}
+	if err = b.appendHistory(ctx, r.Storage, event); err != nil {
+		return nil, errors.Wrapf(err, "failed to record history")
+	}
+
+	issue := &issueStorageEntry{
+		Nonce:      nonce,
+		ExpiresAt:  time.Now().Add(ttl),
+		IssuerID:   issuerID,
+		Reason:     reason,
+		BreakGlass: true,
+	}
+
+	if err = b.acquireLease(ctx, r.Storage, role, roleName, issue); err != nil {
+		return nil, err
+	}
+
+	data, downstream, err := b.fetchSecret(ctx, r, params, cfg, role, issuerID, ttl)
+	if err != nil {
+		if err := b.issueAccessor.delete(ctx, r.Storage, roleName, nonce); err != nil {
+			b.Logger().Warn("failed to release lease", "role", roleName, "nonce", nonce, "error", err)
+		}
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	resp := b.Secret(secretTypeApprovedSecretIssue).Response(data, map[string]interface{}{
+		"nonce":       nonce,
+		"name":        roleName,
+		"reason":      reason,
+		"break_glass": true,
+	})
+
+	if ttlWarning != "" {
+		resp.AddWarning(ttlWarning)
+	}
+
+	event.Type = eventSecretBreakGlass
+	threads, notifyErr := b.notify(cfg, role.NotifySlackChannels, nil, event)
+	if notifyErr != nil {
+		resp.AddWarning(notifyErr.Error())
+	}
+
+	issue.SlackThreads = threads
+	issue.Downstream = downstream
+	if err = b.issueAccessor.put(ctx, r.Storage, issue, roleName, nonce); err != nil {
+		return nil, errors.Wrapf(err, "failed to store issue")
+	}
+
+	err = b.updateHistory(ctx, r.Storage, roleName, nonce, func(entry *historyStorageEntry) error {
+		entry.append(event)
+		entry.ReviewDeadline = time.Now().Add(role.BreakGlassReviewPeriod)
+		entry.ReviewsRequired = role.MinApprovers
+		entry.SlackThreads = threads
+		return nil
+	})
+	if err != nil {
+		resp.AddWarning(fmt.Sprintf("failed to record history: %s", err))
+	}
+
+	resp.Secret.TTL = ttl
+	resp.Secret.MaxTTL = ttl
+	role.wrap(resp)
+
+	return resp, nil
+}
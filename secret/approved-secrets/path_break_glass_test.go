@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func breakGlassVault() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var resp interface{}
+		switch {
+		case strings.HasPrefix(r.URL.Path, "/v1/auth/token/create"):
+			resp = map[string]interface{}{"auth": map[string]interface{}{"client_token": "s.client"}}
+		case strings.HasPrefix(r.URL.Path, "/v1/auth/token/roles/"):
+			w.WriteHeader(http.StatusNoContent)
+			return
+		case r.URL.Path == "/v1/secret/admin":
+			resp = map[string]interface{}{"data": map[string]interface{}{"password": "secret"}}
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(resp)
+	}))
+}
+
+func breakGlass(t *testing.T, b *backend, storage logical.Storage, minApprovers int) string {
+	ctx := context.Background()
+
+	role := &roleStorageEntry{SecretPath: "secret/admin", SecretPathMethod: "GET", SecretTTL: time.Hour, MinApprovers: minApprovers, BreakGlass: true, BreakGlassMaxTTL: time.Hour, BreakGlassReviewPeriod: time.Hour}
+	if err := b.roleAccessor.put(ctx, storage, role, "admin"); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "break-glass/admin",
+		Storage:   storage,
+		EntityID:  "requester",
+		Data:      map[string]interface{}{"reason": "outage"},
+	})
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+	if resp.Data["password"] != "secret" {
+		t.Fatalf("Expected secret to be issued, got %#v\n", resp.Data)
+	}
+
+	return resp.Secret.InternalData["nonce"].(string)
+}
+
+func review(t *testing.T, b *backend, storage logical.Storage, entityID, nonce string) *logical.Response {
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "review/admin/" + nonce,
+		Storage:   storage,
+		EntityID:  entityID,
+		Data:      map[string]interface{}{"comment": "checked by " + entityID},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func TestBreakGlass_Issue(t *testing.T) {
+	vault := breakGlassVault()
+	defer vault.Close()
+
+	b, storage := getBackendWithEntities(t, vault.URL)
+	ctx := context.Background()
+
+	nonce := breakGlass(t, b, storage, 2)
+
+	issue, err := b.issue(ctx, storage, "admin", nonce)
+	if err != nil || issue == nil || !issue.BreakGlass || issue.IssuerID != "requester" {
+		t.Fatalf("Expected break glass lease, got %#v %v\n", issue, err)
+	}
+
+	entry, err := b.history(ctx, storage, "admin", nonce)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !entry.BreakGlass || entry.Outcome != historyOutcomeIssued || entry.ReviewStatus != historyReviewPending || entry.ReviewsRequired != 2 || entry.Reason != "outage" {
+		t.Fatalf("Unexpected history: %#v\n", entry)
+	}
+	if deadline := time.Now().Add(time.Hour); entry.ReviewDeadline.After(deadline) || entry.ReviewDeadline.Before(deadline.Add(-time.Minute)) {
+		t.Fatalf("Expected review deadline in an hour, got %s\n", entry.ReviewDeadline)
+	}
+
+	resp, err := b.HandleRequest(ctx, &logical.Request{Operation: logical.ListOperation, Path: "review/admin/", Storage: storage})
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+	if keys := resp.Data["keys"].([]string); len(keys) != 1 || keys[0] != nonce {
+		t.Fatalf("Expected break glass issue pending review, got %v\n", keys)
+	}
+}
+
+func TestBreakGlass_Review(t *testing.T) {
+	vault := breakGlassVault()
+	defer vault.Close()
+
+	b, storage := getBackendWithEntities(t, vault.URL)
+	ctx := context.Background()
+
+	nonce := breakGlass(t, b, storage, 2)
+
+	if resp := review(t, b, storage, "requester", nonce); resp == nil || !resp.IsError() {
+		t.Fatalf("Expected error when reviewing own break glass issue, got %#v\n", resp)
+	}
+	if resp := review(t, b, storage, "one", nonce); resp == nil || resp.IsError() || resp.Data["review_status"] != historyReviewPending {
+		t.Fatalf("Expected first review to leave review pending, got %#v\n", resp)
+	}
+	if resp := review(t, b, storage, "one", nonce); resp == nil || !resp.IsError() {
+		t.Fatalf("Expected error when reviewing twice, got %#v\n", resp)
+	}
+	if resp := review(t, b, storage, "two", nonce); resp == nil || resp.IsError() || resp.Data["review_status"] != historyReviewReviewed {
+		t.Fatalf("Expected second review to complete review, got %#v\n", resp)
+	}
+	if resp := review(t, b, storage, "three", nonce); resp == nil || !resp.IsError() {
+		t.Fatalf("Expected error when reviewing reviewed break glass issue, got %#v\n", resp)
+	}
+
+	entry, err := b.history(ctx, storage, "admin", nonce)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entry.Reviews) != 2 || entry.Reviews[0].ApproverID != "one" || entry.Reviews[1].Comment != "checked by two" {
+		t.Fatalf("Unexpected reviews: %#v\n", entry.Reviews)
+	}
+
+	if resp := review(t, b, storage, "one", "unknown"); resp == nil || !resp.IsError() {
+		t.Fatalf("Expected error when reviewing unknown break glass issue, got %#v\n", resp)
+	}
+	if entry, err := b.history(ctx, storage, "admin", "unknown"); err != nil || entry != nil {
+		t.Fatalf("Expected no history for unknown break glass issue, got %#v %v\n", entry, err)
+	}
+}
+
+func TestBreakGlass_ConcurrentReview(t *testing.T) {
+	vault := breakGlassVault()
+	defer vault.Close()
+
+	b, storage := getBackendWithEntities(t, vault.URL)
+	ctx := context.Background()
+
+	nonce := breakGlass(t, b, storage, 1)
+
+	const workers = 20
+	var reviewed int32
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if resp := review(t, b, storage, fmt.Sprintf("reviewer-%d", i), nonce); resp != nil && !resp.IsError() {
+				atomic.AddInt32(&reviewed, 1)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	entry, err := b.history(ctx, storage, "admin", nonce)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reviewed != 1 || len(entry.Reviews) != 1 || entry.ReviewStatus != historyReviewReviewed {
+		t.Fatalf("Expected exactly one review, got %d and %#v\n", reviewed, entry.Reviews)
+	}
+}
+
+func TestBreakGlass_ReviewOverdue(t *testing.T) {
+	b, storage := getBackendWithEntities(t, "http://127.0.0.1:0")
+	ctx := context.Background()
+
+	for nonce, deadline := range map[string]time.Time{"overdue": time.Now().Add(-time.Minute), "due": time.Now().Add(time.Hour)} {
+		deadline := deadline
+		err := b.updateHistory(ctx, storage, "admin", nonce, func(entry *historyStorageEntry) error {
+			entry.append(notificationEvent{Type: eventSecretBreakGlass, Actor: "requester", Role: "admin", Nonce: nonce})
+			entry.ReviewDeadline = deadline
+			entry.ReviewsRequired = 1
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Overdue reviews are notified once only.
+	for i := 0; i < 2; i++ {
+		if err := b.checkOverdueReviews(ctx, storage); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	entry, err := b.history(ctx, storage, "admin", "overdue")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !entry.ReviewOverdue || entry.ReviewStatus != historyReviewPending || len(entry.Events) != 2 || entry.Events[1].Type != eventReviewOverdue {
+		t.Fatalf("Expected review to be overdue once, got %#v\n", entry)
+	}
+
+	if entry, err = b.history(ctx, storage, "admin", "due"); err != nil || entry.ReviewOverdue {
+		t.Fatalf("Expected review before deadline not to be overdue, got %#v %v\n", entry, err)
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/pkg/errors"
+)
+
+func pathCancel(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "cancel/" + framework.GenericNameRegex("name"),
+		Fields:  cancelFields(),
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.CreateOperation: b.pathCancelCreateUpdate,
+			logical.UpdateOperation: b.pathCancelCreateUpdate,
+		},
+	}
+}
+
+// pathAdminCancel cancels requests of any requester. Access to it must be
+// restricted to admins by policy.
+func pathAdminCancel(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "admin/cancel/" + framework.GenericNameRegex("name"),
+		Fields:  cancelFields(),
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.CreateOperation: b.pathCancelCreateUpdate,
+			logical.UpdateOperation: b.pathCancelCreateUpdate,
+		},
+	}
+}
+
+func cancelFields() map[string]*framework.FieldSchema {
+	return map[string]*framework.FieldSchema{
+		"name": {
+			Type:        framework.TypeString,
+			Description: "Name of role for cancel.",
+			Required:    true,
+		},
+		"nonce": {
+			Type:        framework.TypeString,
+			Description: "Nonce generated by request.",
+			Required:    true,
+		},
+		"reason": {
+			Type:        framework.TypeString,
+			Description: "Reason for cancelling the request.",
+			Required:    true,
+		},
+	}
+}
+
+func (b *backend) pathCancelCreateUpdate(ctx context.Context, r *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+
+	name := d.Get("name").(string)
+	nonce := d.Get("nonce").(string)
+
+	reason := d.Get("reason").(string)
+	if reason == "" {
+		return logical.ErrorResponse("field 'reason' is mandatory"), nil
+	}
+
+	return b.cancelRequest(ctx, r, name, nonce, reason, strings.HasPrefix(r.Path, "admin/"))
+}
+
+// cancelRequest cancels the request of the caller or, if admin, of any
+// requester.
+func (b *backend) cancelRequest(ctx context.Context, r *logical.Request, name, nonce, reason string, admin bool) (*logical.Response, error) {
+
+	callerID, err := b.callerID(ctx, r)
+	if err != nil || callerID == "" {
+		switch {
+		case admin:
+			callerID = r.DisplayName
+		case err != nil:
+			return logical.ErrorResponse(err.Error()), nil
+		default:
+			return logical.ErrorResponse("caller has no identity"), nil
+		}
+	}
+
+	unlock := b.lockRequest(name, nonce)
+	defer unlock()
+
+	sr, err := b.request(ctx, r.Storage, name, nonce)
+	if err != nil {
+		return nil, err
+	}
+	if sr == nil {
+		return logical.ErrorResponse(fmt.Sprintf("request does not exists for role %q with nonce %q (expired or already issued?)", name, nonce)), nil
+	}
+
+	if !admin && strings.ToLower(callerID) != strings.ToLower(sr.RequesterID) {
+		return logical.ErrorResponse("only requester %q is allowed to cancel request", sr.RequesterID), nil
+	}
+
+	if err = sr.transition(requestCancelled, time.Now()); err != nil {
+		return logical.ErrorResponse(fmt.Sprintf("request for role %q with nonce %q cannot be cancelled: %s", name, nonce, err)), nil
+	}
+
+	if err = b.requestAccessor.delete(ctx, r.Storage, name, nonce); err != nil {
+		return nil, errors.Wrapf(err, "failed to delete request for role %q with nonce %q", name, nonce)
+	}
+
+	event := notificationEvent{
+		Type:   eventRequestCancelled,
+		Actor:  strings.ToLower(callerID),
+		Role:   name,
+		Nonce:  nonce,
+		Reason: reason,
+	}
+	if err = b.appendHistory(ctx, r.Storage, event); err != nil {
+		return nil, errors.Wrapf(err, "failed to record history")
+	}
+
+	resp := &logical.Response{
+		Data: map[string]interface{}{
+			"nonce":        nonce,
+			"state":        sr.State,
+			"requester_id": sr.RequesterID,
+			"reason":       reason,
+		},
+	}
+
+	role, err := b.role(ctx, r.Storage, name)
+	if err != nil || role == nil {
+		return resp, err
+	}
+
+	cfg, err := b.config(ctx, r.Storage)
+	if err != nil || cfg == nil {
+		return resp, err
+	}
+
+	if _, err = b.notify(cfg, role.NotifySlackChannels, sr.SlackThreads, event); err != nil {
+		resp.AddWarning(err.Error())
+	}
+
+	return resp, nil
+}
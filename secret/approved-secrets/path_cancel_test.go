@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestCancel_Requester(t *testing.T) {
+	b, storage := getBackendWithEntities(t, "http://127.0.0.1:0")
+	ctx := context.Background()
+
+	if err := b.roleAccessor.put(ctx, storage, &roleStorageEntry{SecretPath: "secret/admin", MinApprovers: 1}, "admin"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, nonce := range []string{"r1", "r2"} {
+		sr := &requestStorageEntry{Nonce: nonce, State: requestPending, RequesterID: "bob", MinApprovers: 1, ExpiresAt: time.Now().Add(time.Hour)}
+		if err := b.putRequest(ctx, storage, sr, "admin", nonce); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cancel := func(path, entityID, nonce string) *logical.Response {
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation:   logical.UpdateOperation,
+			Path:        path,
+			Storage:     storage,
+			EntityID:    entityID,
+			DisplayName: "root",
+			Data:        map[string]interface{}{"nonce": nonce, "reason": "not needed anymore"},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	if resp := cancel("cancel/admin", "alice", "r1"); resp == nil || !resp.IsError() {
+		t.Fatalf("Expected error when cancelling request of another requester, got %#v\n", resp)
+	}
+	if resp := cancel("cancel/admin", "bob", "r1"); resp == nil || resp.IsError() {
+		t.Fatalf("Expected requester to cancel request, got %#v\n", resp)
+	}
+	if resp := cancel("admin/cancel/admin", "", "r2"); resp == nil || resp.IsError() {
+		t.Fatalf("Expected admin to cancel request, got %#v\n", resp)
+	}
+
+	for _, nonce := range []string{"r1", "r2"} {
+		if sr, err := b.request(ctx, storage, "admin", nonce); err != nil || sr != nil {
+			t.Fatalf("Expected request %s to be deleted, got %#v %v\n", nonce, sr, err)
+		}
+	}
+
+	entry, err := b.history(ctx, storage, "admin", "r2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.Outcome != historyOutcomeCancelled || entry.Events[0].Actor != "root" || entry.Events[0].Reason != "not needed anymore" {
+		t.Fatalf("Unexpected history: %#v\n", entry)
+	}
+}
+
+func TestCancel_RequestDelete(t *testing.T) {
+	b, storage := getBackendWithEntities(t, "http://127.0.0.1:0")
+	ctx := context.Background()
+
+	sr := &requestStorageEntry{Nonce: "r1", State: requestPending, RequesterID: "bob", MinApprovers: 1, ExpiresAt: time.Now().Add(time.Hour)}
+	if err := b.putRequest(ctx, storage, sr, "admin", "r1"); err != nil {
+		t.Fatal(err)
+	}
+
+	del := func(entityID string) *logical.Response {
+		resp, err := b.HandleRequest(ctx, &logical.Request{Operation: logical.DeleteOperation, Path: "request/admin/r1", Storage: storage, EntityID: entityID})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	if resp := del("alice"); resp == nil || !resp.IsError() {
+		t.Fatalf("Expected error when deleting request of another requester, got %#v\n", resp)
+	}
+	if sr, err := b.request(ctx, storage, "admin", "r1"); err != nil || sr == nil {
+		t.Fatalf("Expected request to remain, got %#v %v\n", sr, err)
+	}
+
+	if resp := del("bob"); resp == nil || resp.IsError() {
+		t.Fatalf("Expected requester to delete request, got %#v\n", resp)
+	}
+
+	entry, err := b.history(ctx, storage, "admin", "r1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.Outcome != historyOutcomeCancelled || entry.Events[0].Type != eventRequestCancelled || entry.Events[0].Actor != "bob" || entry.Events[0].Reason != "request deleted" {
+		t.Fatalf("Unexpected history: %#v\n", entry)
+	}
+}
+
+func TestCancel_NoIdentity(t *testing.T) {
+	b, storage := getBackend(t)
+	ctx := context.Background()
+
+	// The entity's alias resolves to an empty identity without an error.
+	b.System().(*logical.StaticSystemView).EntityVal = &logical.Entity{ID: "nameless", Aliases: []*logical.Alias{{}}}
+	if err := b.configAccessor.put(ctx, storage, &configStorageEntry{}); err != nil {
+		t.Fatal(err)
+	}
+
+	sr := &requestStorageEntry{Nonce: "r1", State: requestPending, RequesterID: "bob", MinApprovers: 1, ExpiresAt: time.Now().Add(time.Hour)}
+	if err := b.putRequest(ctx, storage, sr, "admin", "r1"); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "cancel/admin",
+		Storage:   storage,
+		EntityID:  "nameless",
+		Data:      map[string]interface{}{"nonce": "r1", "reason": "not needed anymore"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp == nil || !resp.IsError() || resp.Error().Error() != "caller has no identity" {
+		t.Fatalf("Expected error for caller without identity, got %#v\n", resp)
+	}
+}
+
+func TestAmend_ResetsApprovals(t *testing.T) {
+	b, storage := getBackendWithEntities(t, "http://127.0.0.1:0")
+	ctx := context.Background()
+
+	schema, err := parseRequestParameters(map[string]interface{}{
+		"common_name": map[string]interface{}{"type": "string", "required": true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := b.roleAccessor.put(ctx, storage, &roleStorageEntry{SecretPath: "pki/issue/admin", MinApprovers: 1, Parameters: schema}, "admin"); err != nil {
+		t.Fatal(err)
+	}
+
+	sr := &requestStorageEntry{
+		Nonce:        "r1",
+		State:        requestApproved,
+		RequesterID:  "bob",
+		Reason:       "deploy",
+		Parameters:   map[string]interface{}{"common_name": "a.yolt.io"},
+		MinApprovers: 1,
+		ApproverIDs:  []string{"alice"},
+		Approvals:    []requestApproval{{ApproverID: "alice"}},
+		ExpiresAt:    time.Now().Add(time.Hour),
+	}
+	if err := b.putRequest(ctx, storage, sr, "admin", "r1"); err != nil {
+		t.Fatal(err)
+	}
+
+	amend := func(entityID string, data map[string]interface{}) *logical.Response {
+		data["nonce"] = "r1"
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "amend/admin",
+			Storage:   storage,
+			EntityID:  entityID,
+			Data:      data,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	if resp := amend("alice", map[string]interface{}{"reason": "other"}); resp == nil || !resp.IsError() {
+		t.Fatalf("Expected error when amending request of another requester, got %#v\n", resp)
+	}
+	if resp := amend("bob", map[string]interface{}{}); resp == nil || !resp.IsError() {
+		t.Fatalf("Expected error when amending nothing, got %#v\n", resp)
+	}
+	if resp := amend("bob", map[string]interface{}{"unknown": "x"}); resp == nil || !resp.IsError() {
+		t.Fatalf("Expected error for unknown parameter, got %#v\n", resp)
+	}
+
+	resp := amend("bob", map[string]interface{}{"reason": "deploy b", "common_name": "b.yolt.io"})
+	if resp == nil || resp.IsError() {
+		t.Fatalf("Expected requester to amend request, got %#v\n", resp)
+	}
+
+	stored, err := b.request(ctx, storage, "admin", "r1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.State != requestPending || len(stored.ApproverIDs) != 0 || len(stored.Approvals) != 0 {
+		t.Fatalf("Expected approvals to be reset, got %#v\n", stored)
+	}
+	if stored.Reason != "deploy b" || stored.Parameters["common_name"] != "b.yolt.io" {
+		t.Fatalf("Expected reason and parameters to be amended, got %#v\n", stored)
+	}
+}
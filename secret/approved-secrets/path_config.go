@@ -17,8 +17,7 @@ func pathConfig(b *backend) *framework.Path {
 		Fields: map[string]*framework.FieldSchema{
 			"vault_token": {
 				Type:        framework.TypeString,
-				Description: `Vault token from which an orphaned token for issuing secrets is generated (typically Vault root token). The vault_policies is attached to the orphaned token.`,
-				Required:    true,
+				Description: `Vault token from which an orphaned token for issuing secrets is generated (typically Vault root token). The vault_policies is attached to the orphaned token. Not required if approle_role_id is set.`,
 			},
 			"vault_addr": {
 				Type:        framework.TypeString,
@@ -29,6 +28,23 @@ func pathConfig(b *backend) *framework.Path {
 				Type:        framework.TypeCommaStringSlice,
 				Description: `Vault policies attached to the created orphaned Vault token.`,
 			},
+			"approle_mount": {
+				Type:        framework.TypeString,
+				Default:     "approle",
+				Description: `Mount path of the AppRole auth method to (re-)mint the plugin's token from.`,
+			},
+			"approle_role_id": {
+				Type:        framework.TypeString,
+				Description: `AppRole role ID to (re-)mint the plugin's token from. If set, the token is re-minted when renewal fails or it expires soon.`,
+			},
+			"approle_secret_id": {
+				Type:        framework.TypeString,
+				Description: `AppRole secret ID to (re-)mint the plugin's token from.`,
+			},
+			"token_role_name": {
+				Type:        framework.TypeString,
+				Description: `Name of the token role managed by the plugin to create tokens for issuing secrets. Defaults to a name derived from the mount path.`,
+			},
 			"approval_ttl": {
 				Type:        framework.TypeDurationSecond,
 				Default:     "1h",
@@ -38,10 +54,52 @@ func pathConfig(b *backend) *framework.Path {
 				Type:        framework.TypeString,
 				Description: `Identity template definition (for example: "{{identity.entity.aliases.auth_plugin_05c79452.name}}"). If not set, alias name of first identity is taken.`,
 			},
+			"history_retention": {
+				Type:        framework.TypeDurationSecond,
+				Default:     0,
+				Description: `Duration in seconds after which completed requests are removed from history by tidy. If 0, history is kept forever.`,
+			},
 			"slack_webhook_url": {
 				Type:        framework.TypeString,
 				Description: `Address of Slack webhook URL to post alerts.`,
 			},
+			"slack_bot_token": {
+				Type:        framework.TypeString,
+				Description: `Slack bot token to post alerts with the Web API. If set, it takes precedence over slack_webhook_url and follow-up notifications are threaded with the request message.`,
+			},
+			"slack_signing_secret": {
+				Type:        framework.TypeString,
+				Description: `Signing secret of the Slack app, to verify requests to slack/callback. Required for the Approve and Reject buttons.`,
+			},
+			"slack_user_entities": {
+				Type:        framework.TypeKVPairs,
+				Description: `Mapping of Slack user IDs to Vault entity IDs, to approve and reject with the buttons in Slack messages.`,
+			},
+			"ticket_tracker": {
+				Type:        framework.TypeString,
+				Description: `Issue tracker to validate tickets of roles with ticket_validate, either "jira" or "http" (generic HTTP JSON API).`,
+			},
+			"ticket_tracker_url": {
+				Type:        framework.TypeString,
+				Description: `Base URL of the issue tracker.`,
+			},
+			"ticket_tracker_username": {
+				Type:        framework.TypeString,
+				Description: `Username for basic authentication with Jira. If not set, ticket_tracker_token is sent as bearer token.`,
+			},
+			"ticket_tracker_token": {
+				Type:        framework.TypeString,
+				Description: `API token for the issue tracker.`,
+			},
+			"role_governance_groups": {
+				Type:        framework.TypeCommaStringSlice,
+				Description: `Vault identity group names or IDs whose members approve role changes. If set, changes of roles are pending versions until approved.`,
+			},
+			"role_governance_min_approvers": {
+				Type:        framework.TypeInt,
+				Default:     1,
+				Description: `Minimum number of members of role_governance_groups, other than the proposer, that must approve a role change (>=1).`,
+			},
 		},
 		Callbacks: map[logical.Operation]framework.OperationFunc{
 			logical.CreateOperation: b.pathConfigCreateUpdate,
@@ -59,6 +117,7 @@ func (b *backend) pathConfigCreateUpdate(ctx context.Context, r *logical.Request
 	} else if config == nil {
 		config = &configStorageEntry{}
 	}
+	previous := *config
 
 	if approvalTTLRaw, ok := d.GetOk("approval_ttl"); ok {
 		config.ApprovalTTL = time.Second * time.Duration(approvalTTLRaw.(int))
@@ -66,8 +125,6 @@ func (b *backend) pathConfigCreateUpdate(ctx context.Context, r *logical.Request
 		config.ApprovalTTL = time.Second * time.Duration(d.GetDefaultOrZero("approval_ttl").(int))
 	}
 
-	config.VaultToken = d.Get("vault_token").(string)
-
 	if vaultPoliciesRaw, ok := d.GetOk("vault_policies"); ok {
 		config.VaultPolicies = vaultPoliciesRaw.([]string)
 	}
@@ -78,6 +135,37 @@ func (b *backend) pathConfigCreateUpdate(ctx context.Context, r *logical.Request
 		config.VaultAddr = d.GetDefaultOrZero("vault_addr").(string)
 	}
 
+	if appRoleMountRaw, ok := d.GetOk("approle_mount"); ok {
+		config.AppRoleMount = appRoleMountRaw.(string)
+	} else if config.AppRoleMount == "" {
+		config.AppRoleMount = d.GetDefaultOrZero("approle_mount").(string)
+	}
+
+	if appRoleRoleIDRaw, ok := d.GetOk("approle_role_id"); ok {
+		config.AppRoleRoleID = appRoleRoleIDRaw.(string)
+	}
+
+	if appRoleSecretIDRaw, ok := d.GetOk("approle_secret_id"); ok {
+		config.AppRoleSecretID = appRoleSecretIDRaw.(string)
+	}
+
+	vaultToken := d.Get("vault_token").(string)
+	if vaultToken == "" && config.AppRoleRoleID == "" {
+		return logical.ErrorResponse("vault_token or approle_role_id is required"), nil
+	}
+
+	previousTokenRoleName := config.TokenRoleName
+	if tokenRoleNameRaw, ok := d.GetOk("token_role_name"); ok {
+		config.TokenRoleName = tokenRoleNameRaw.(string)
+	}
+	if config.TokenRoleName == "" {
+		config.TokenRoleName = defaultTokenRoleName(r.MountPoint)
+	}
+
+	if historyRetentionRaw, ok := d.GetOk("history_retention"); ok {
+		config.HistoryRetention = time.Second * time.Duration(historyRetentionRaw.(int))
+	}
+
 	if identityTemplateRaw, ok := d.GetOk("identity_template"); ok {
 		config.IdentityTemplate = identityTemplateRaw.(string)
 	}
@@ -86,26 +174,82 @@ func (b *backend) pathConfigCreateUpdate(ctx context.Context, r *logical.Request
 		config.SlackWebhookURL = slackWebhookURLRaw.(string)
 	}
 
-	clt, err := newVaultClient(ctx, config.VaultAddr, config.VaultToken)
-	if err != nil {
-		return logical.ErrorResponse(fmt.Sprintf("failed to create Vault client: %s", err)), nil
+	if slackBotTokenRaw, ok := d.GetOk("slack_bot_token"); ok {
+		config.SlackBotToken = slackBotTokenRaw.(string)
+	}
+
+	if slackSigningSecretRaw, ok := d.GetOk("slack_signing_secret"); ok {
+		config.SlackSigningSecret = slackSigningSecretRaw.(string)
+	}
+
+	if slackUserEntitiesRaw, ok := d.GetOk("slack_user_entities"); ok {
+		config.SlackUserEntities = slackUserEntitiesRaw.(map[string]string)
+	}
+
+	if ticketTrackerRaw, ok := d.GetOk("ticket_tracker"); ok {
+		config.TicketTracker = ticketTrackerRaw.(string)
+	}
+	switch config.TicketTracker {
+	case "", ticketTrackerJira, ticketTrackerHTTP:
+	default:
+		return logical.ErrorResponse(fmt.Sprintf("ticket_tracker must be %q or %q", ticketTrackerJira, ticketTrackerHTTP)), nil
+	}
+
+	if ticketTrackerURLRaw, ok := d.GetOk("ticket_tracker_url"); ok {
+		config.TicketTrackerURL = ticketTrackerURLRaw.(string)
+	}
+
+	if ticketTrackerUsernameRaw, ok := d.GetOk("ticket_tracker_username"); ok {
+		config.TicketTrackerUsername = ticketTrackerUsernameRaw.(string)
+	}
+
+	if ticketTrackerTokenRaw, ok := d.GetOk("ticket_tracker_token"); ok {
+		config.TicketTrackerToken = ticketTrackerTokenRaw.(string)
+	}
+
+	if roleGovernanceGroupsRaw, ok := d.GetOk("role_governance_groups"); ok {
+		config.RoleGovernanceGroups = roleGovernanceGroupsRaw.([]string)
+	}
+
+	if roleGovernanceMinApproversRaw, ok := d.GetOk("role_governance_min_approvers"); ok {
+		config.RoleGovernanceMinApprovers = roleGovernanceMinApproversRaw.(int)
+	} else if config.RoleGovernanceMinApprovers == 0 {
+		config.RoleGovernanceMinApprovers = d.GetDefaultOrZero("role_governance_min_approvers").(int)
 	}
 
-	data := map[string]interface{}{
-		"policies":     config.VaultPolicies,
-		"ttl":          "72h",
-		"renewable":    true,
-		"display_name": "approved-secrets-plugin",
-		"meta":         map[string]interface{}{"created_by": "approved-secrets-plugin"},
+	if config.RoleGovernanceMinApprovers < 1 {
+		return logical.ErrorResponse("bad role_governance_min_approvers (must be >= 1)"), nil
 	}
 
-	secret, err := clt.Logical().Write("/auth/token/create-orphan", data)
+	if err = b.verifyRoleGovernanceChange(r, &previous, config); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	b.tokenMutex.Lock()
+	defer b.tokenMutex.Unlock()
+
+	secret, err := mintVaultToken(ctx, config, vaultToken)
 	if err != nil {
-		return logical.ErrorResponse(fmt.Sprintf("failed to create orphaned Vault token: %s", err)), nil
+		return logical.ErrorResponse(err.Error()), nil
 	}
 
 	config.VaultToken = secret.Auth.ClientToken
 
+	if err = b.reconcileTokenRole(ctx, r.Storage, config); err != nil {
+		return logical.ErrorResponse(fmt.Sprintf("failed to reconcile token role: %s", err)), nil
+	}
+
+	var resp logical.Response
+	if previousTokenRoleName != "" && previousTokenRoleName != config.TokenRoleName {
+		clt, err := newVaultClient(ctx, config.VaultAddr, config.VaultToken)
+		if err == nil {
+			err = deleteTokenRole(clt, previousTokenRoleName)
+		}
+		if err != nil {
+			resp.AddWarning(err.Error())
+		}
+	}
+
 	entry, err := logical.StorageEntryJSON("config", config)
 	if err != nil {
 		return nil, errors.Wrapf(err, "failed to generate storage entry")
@@ -115,11 +259,21 @@ func (b *backend) pathConfigCreateUpdate(ctx context.Context, r *logical.Request
 		return nil, errors.Wrapf(err, "failed to write configuration to storage")
 	}
 
-	return &logical.Response{
-		Data: map[string]interface{}{
-			"vault_token": config.VaultToken,
-		},
-	}, nil
+	now := time.Now()
+	health := &tokenHealthStorageEntry{
+		LastRenewal: now,
+		ExpiresAt:   now.Add(time.Duration(secret.Auth.LeaseDuration) * time.Second),
+	}
+	if err = b.tokenHealthAccessor.put(ctx, r.Storage, health); err != nil {
+		return nil, errors.Wrapf(err, "failed to write token health to storage")
+	}
+
+	resp.Data = map[string]interface{}{
+		"vault_token":     config.VaultToken,
+		"token_role_name": config.TokenRoleName,
+	}
+
+	return &resp, nil
 }
 
 func (b *backend) pathConfigRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
@@ -131,16 +285,44 @@ func (b *backend) pathConfigRead(ctx context.Context, req *logical.Request, data
 		return nil, logical.CodedError(http.StatusNotFound, "no config found")
 	}
 
-	return &logical.Response{
+	health, err := b.tokenHealth(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &logical.Response{
 		Data: map[string]interface{}{
 			"approval_ttl":      (int)(cfg.ApprovalTTL / time.Second),
 			"vault_addr":        cfg.VaultAddr,
 			"vault_token":       "<sensitive>",
 			"vault_policies":    cfg.VaultPolicies,
 			"identity_template": cfg.IdentityTemplate,
+			"approle_mount":     cfg.AppRoleMount,
+			"approle_role_id":   cfg.AppRoleRoleID,
+			"approle_secret_id": "<sensitive>",
+			"token_role_name":   cfg.TokenRoleName,
+			"token_health":      health.data(),
+			"history_retention": (int)(cfg.HistoryRetention / time.Second),
 			"slack_webhook_url": "<sensitive>",
+			"slack_bot_token":   "<sensitive>",
+
+			"slack_signing_secret": "<sensitive>",
+			"slack_user_entities":  cfg.SlackUserEntities,
+
+			"ticket_tracker":          cfg.TicketTracker,
+			"ticket_tracker_url":      cfg.TicketTrackerURL,
+			"ticket_tracker_username": cfg.TicketTrackerUsername,
+			"ticket_tracker_token":    "<sensitive>",
+
+			"role_governance_groups":        cfg.RoleGovernanceGroups,
+			"role_governance_min_approvers": cfg.RoleGovernanceMinApprovers,
 		},
-	}, nil
+	}
+	for _, warning := range health.warnings() {
+		resp.AddWarning(warning)
+	}
+
+	return resp, nil
 }
 
 type configStorageEntry struct {
@@ -149,5 +331,31 @@ type configStorageEntry struct {
 	VaultToken       string        `json:"vault_token" structs:"vault_token"`
 	VaultPolicies    []string      `json:"vault_policies" structs:"vault_policies"`
 	IdentityTemplate string        `json:"identity_template" structs:"identity_template"`
+	AppRoleMount     string        `json:"approle_mount" structs:"approle_mount"`
+	AppRoleRoleID    string        `json:"approle_role_id" structs:"approle_role_id"`
+	AppRoleSecretID  string        `json:"approle_secret_id" structs:"approle_secret_id"`
+	TokenRoleName    string        `json:"token_role_name" structs:"token_role_name"`
+	HistoryRetention time.Duration `json:"history_retention" structs:"history_retention"`
 	SlackWebhookURL  string        `json:"slack_webhook_url" structs:"slack_webhook_url"`
+	SlackBotToken    string        `json:"slack_bot_token" structs:"slack_bot_token"`
+
+	SlackSigningSecret string            `json:"slack_signing_secret" structs:"slack_signing_secret"`
+	SlackUserEntities  map[string]string `json:"slack_user_entities" structs:"slack_user_entities"`
+
+	TicketTracker         string `json:"ticket_tracker" structs:"ticket_tracker"`
+	TicketTrackerURL      string `json:"ticket_tracker_url" structs:"ticket_tracker_url"`
+	TicketTrackerUsername string `json:"ticket_tracker_username" structs:"ticket_tracker_username"`
+	TicketTrackerToken    string `json:"ticket_tracker_token" structs:"ticket_tracker_token"`
+
+	RoleGovernanceGroups       []string `json:"role_governance_groups" structs:"role_governance_groups"`
+	RoleGovernanceMinApprovers int      `json:"role_governance_min_approvers" structs:"role_governance_min_approvers"`
+}
+
+// roleGovernanceMinApprovers returns role_governance_min_approvers, which is
+// not set in configurations written by earlier versions.
+func (cfg *configStorageEntry) roleGovernanceMinApprovers() int {
+	if cfg.RoleGovernanceMinApprovers < 1 {
+		return 1
+	}
+	return cfg.RoleGovernanceMinApprovers
 }
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func pathHistory(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "history/" + framework.GenericNameRegex("name") + "/" + framework.GenericNameRegex("nonce"),
+		Fields: map[string]*framework.FieldSchema{
+			"name": {
+				Type:        framework.TypeString,
+				Description: "Name of role of the request.",
+				Required:    true,
+			},
+			"nonce": {
+				Type:        framework.TypeString,
+				Description: "Nonce generated by request.",
+				Required:    true,
+			},
+		},
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ReadOperation: b.pathHistoryRead,
+		},
+	}
+}
+
+func pathListHistory(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "history/" + framework.GenericNameRegex("name") + "/?$",
+		Fields: map[string]*framework.FieldSchema{
+			"name": {
+				Type:        framework.TypeString,
+				Description: "Name of role for history.",
+				Required:    true,
+			},
+			"since": {
+				Type:        framework.TypeTime,
+				Description: "Only include requests created at or after this time (RFC3339 or epoch).",
+			},
+			"until": {
+				Type:        framework.TypeTime,
+				Description: "Only include requests created before this time (RFC3339 or epoch).",
+			},
+			"identity": {
+				Type:        framework.TypeString,
+				Description: "Only include requests requested or approved by this identity.",
+			},
+		},
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ListOperation: b.pathHistoryList,
+			logical.ReadOperation: b.pathHistoryList,
+		},
+	}
+}
+
+func (b *backend) pathHistoryRead(ctx context.Context, r *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+
+	name := d.Get("name").(string)
+	nonce := d.Get("nonce").(string)
+	entry, err := b.history(ctx, r.Storage, name, nonce)
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return nil, nil
+	}
+
+	return &logical.Response{Data: entry.data()}, nil
+}
+
+func (b *backend) pathHistoryList(ctx context.Context, r *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+
+	name := d.Get("name").(string)
+	nonces, err := b.historyAccessor.list(ctx, r.Storage, name)
+	if err != nil {
+		return nil, err
+	}
+
+	since := d.Get("since").(time.Time)
+	until := d.Get("until").(time.Time)
+	identity := d.Get("identity").(string)
+
+	keys := make([]string, 0, len(nonces))
+	keyInfo := make(map[string]interface{})
+	for _, nonce := range nonces {
+		entry, err := b.history(ctx, r.Storage, name, nonce)
+		if err != nil {
+			return nil, err
+		} else if entry == nil {
+			continue
+		}
+
+		if !since.IsZero() && entry.CreatedAt.Before(since) {
+			continue
+		}
+		if !until.IsZero() && !entry.CreatedAt.Before(until) {
+			continue
+		}
+		if identity != "" && !entry.involves(identity) {
+			continue
+		}
+
+		keys = append(keys, nonce)
+		keyInfo[nonce] = map[string]interface{}{
+			"requester_id": entry.RequesterID,
+			"created_at":   entry.CreatedAt,
+			"outcome":      entry.Outcome,
+		}
+	}
+
+	return logical.ListResponseWithInfo(keys, keyInfo), nil
+}
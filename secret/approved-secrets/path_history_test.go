@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestHistory_List(t *testing.T) {
+	b, storage := getBackend(t)
+	ctx := context.Background()
+
+	events := []notificationEvent{
+		{Type: eventRequestCreated, Actor: "some@yolt.com", Role: "k8s-admin", Nonce: "n1", Reason: "incident"},
+		{Type: eventRequestApproved, Actor: "one@yolt.com", Role: "k8s-admin", Nonce: "n1"},
+		{Type: eventSecretIssued, Actor: "some@yolt.com", Role: "k8s-admin", Nonce: "n1"},
+		{Type: eventRequestCreated, Actor: "other@yolt.com", Role: "k8s-admin", Nonce: "n2", Reason: "maintenance"},
+		{Type: eventRequestExpired, Actor: "other@yolt.com", Role: "k8s-admin", Nonce: "n2"},
+	}
+	for _, e := range events {
+		if err := b.appendHistory(ctx, storage, e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	req := &logical.Request{
+		Operation: logical.ListOperation,
+		Path:      "history/k8s-admin/",
+		Storage:   storage,
+		Data:      map[string]interface{}{"identity": "one@yolt.com"},
+	}
+
+	resp, err := b.HandleRequest(ctx, req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	keys := resp.Data["keys"].([]string)
+	if len(keys) != 1 || keys[0] != "n1" {
+		t.Fatalf("Unexpected keys: expected [n1] got %v\n", keys)
+	}
+
+	req.Data = map[string]interface{}{"until": time.Now().Add(-time.Minute).Format(time.RFC3339)}
+	resp, err = b.HandleRequest(ctx, req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	if _, ok := resp.Data["keys"]; ok {
+		t.Fatalf("Unexpected keys: expected none got %v\n", resp.Data["keys"])
+	}
+
+	entry, err := b.history(ctx, storage, "k8s-admin", "n1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if entry.Reason != "incident" || entry.Outcome != historyOutcomeIssued || len(entry.Approvals) != 1 || entry.IssuedAt.IsZero() {
+		t.Fatalf("Unexpected history entry: %#v\n", entry)
+	}
+}
+
+func TestHistory_Tidy(t *testing.T) {
+	b, storage := getBackend(t)
+	ctx := context.Background()
+
+	events := []notificationEvent{
+		{Type: eventRequestCreated, Actor: "some@yolt.com", Role: "k8s-admin", Nonce: "n1", Reason: "incident"},
+		{Type: eventRequestExpired, Actor: "some@yolt.com", Role: "k8s-admin", Nonce: "n1"},
+		{Type: eventRequestCreated, Actor: "some@yolt.com", Role: "k8s-admin", Nonce: "n2", Reason: "incident"},
+	}
+	for _, e := range events {
+		if err := b.appendHistory(ctx, storage, e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	deleted, err := b.tidyHistory(ctx, storage, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted != 0 {
+		t.Fatalf("Unexpected deleted entries: expected 0 got %d\n", deleted)
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	deleted, err = b.tidyHistory(ctx, storage, time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Pending requests are kept regardless of retention.
+	if deleted != 1 {
+		t.Fatalf("Unexpected deleted entries: expected 1 got %d\n", deleted)
+	}
+}
+
+func TestHistory_RejectedLeaseRevoke(t *testing.T) {
+	b, storage := getBackendWithEntities(t, "http://127.0.0.1:0")
+	ctx := context.Background()
+
+	if err := b.roleAccessor.put(ctx, storage, &roleStorageEntry{SecretPath: "secret/admin", MinApprovers: 1, MinRejecters: 1}, "admin"); err != nil {
+		t.Fatal(err)
+	}
+
+	sr := &requestStorageEntry{Nonce: "nonce", State: requestPending, RequesterID: "requester", MinApprovers: 1, ExpiresAt: time.Now().Add(time.Hour)}
+	if err := b.putRequest(ctx, storage, sr, "admin", "nonce"); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "reject/admin",
+		Storage:   storage,
+		EntityID:  "approver",
+		Data:      map[string]interface{}{"nonce": "nonce", "comment": "no"},
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	// The lease of the request ends after it was rejected.
+	resp, err = b.secretApprovedSecretRequestRevoke(ctx, &logical.Request{
+		Storage: storage,
+		Secret:  &logical.Secret{InternalData: map[string]interface{}{"name": "admin", "nonce": "nonce"}},
+	}, nil)
+	if err != nil || resp != nil {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	entry, err := b.history(ctx, storage, "admin", "nonce")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.Outcome != historyOutcomeDenied || entry.Events[len(entry.Events)-1].Type != eventRequestDenied {
+		t.Fatalf("Expected rejected request to stay denied, got %#v\n", entry)
+	}
+	if sr, err := b.request(ctx, storage, "admin", "nonce"); err != nil || sr != nil {
+		t.Fatalf("Expected request to be deleted, got %#v %v\n", sr, err)
+	}
+}
+
+func TestHistory_ConcurrentAppend(t *testing.T) {
+	b, storage := getBackend(t)
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := b.appendHistory(ctx, storage, notificationEvent{Type: eventRequestApproved, Actor: "one@yolt.com", Role: "k8s-admin", Nonce: "n1"}); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	entry, err := b.history(ctx, storage, "k8s-admin", "n1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entry.Events) != 20 {
+		t.Fatalf("Expected 20 events, got %d\n", len(entry.Events))
+	}
+}
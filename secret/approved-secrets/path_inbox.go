@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/pkg/errors"
+)
+
+func pathInbox(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "inbox/?$",
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ListOperation: b.pathInboxList,
+			logical.ReadOperation: b.pathInboxList,
+		},
+	}
+}
+
+func pathMine(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "mine/?$",
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ListOperation: b.pathMineList,
+			logical.ReadOperation: b.pathMineList,
+		},
+	}
+}
+
+// pathInboxList lists the pending requests of all roles the caller may
+// approve. Keys are the paths of the requests, e.g. request/admin/<nonce>.
+func (b *backend) pathInboxList(ctx context.Context, r *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+
+	callerID, err := b.callerID(ctx, r)
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	keys := make([]string, 0)
+	keyInfo := make(map[string]interface{})
+	now := time.Now()
+	err = b.walkRequests(ctx, r.Storage, func(name string, sr *requestStorageEntry) error {
+
+		if sr.state(now) != requestPending || checkApprover(callerID, sr) != nil {
+			return nil
+		}
+
+		role, err := b.role(ctx, r.Storage, name)
+		if err != nil {
+			return err
+		} else if role == nil {
+			return nil
+		}
+
+		if _, err = b.verifyCallerGroups(r, role.BoundApproverRoles, role.BoundApproverGroups); err != nil {
+			return nil
+		}
+
+		key := path.Join("request", name, sr.Nonce)
+		keys = append(keys, key)
+		keyInfo[key] = requestInfo(name, sr, now)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return logical.ListResponseWithInfo(keys, keyInfo), nil
+}
+
+// pathMineList lists the caller's own requests and active issues. Keys are
+// the paths of the requests and issues, e.g. issue/admin/<nonce>.
+func (b *backend) pathMineList(ctx context.Context, r *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+
+	callerID, err := b.callerID(ctx, r)
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	keys := make([]string, 0)
+	keyInfo := make(map[string]interface{})
+	now := time.Now()
+	err = b.walkRequests(ctx, r.Storage, func(name string, sr *requestStorageEntry) error {
+
+		if strings.ToLower(sr.RequesterID) != strings.ToLower(callerID) {
+			return nil
+		}
+
+		key := path.Join("request", name, sr.Nonce)
+		keys = append(keys, key)
+		keyInfo[key] = requestInfo(name, sr, now)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	roles, err := b.issueAccessor.list(ctx, r.Storage, "")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list issues")
+	}
+
+	for _, name := range roles {
+		name = strings.TrimSuffix(name, "/")
+		nonces, err := b.issueAccessor.list(ctx, r.Storage, name)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to list issues of role %q", name)
+		}
+
+		for _, nonce := range nonces {
+			issue, err := b.issue(ctx, r.Storage, name, nonce)
+			if err != nil {
+				return nil, err
+			} else if issue == nil || !now.Before(issue.ExpiresAt) || strings.ToLower(issue.IssuerID) != strings.ToLower(callerID) {
+				continue
+			}
+
+			key := path.Join("issue", name, issue.Nonce)
+			keys = append(keys, key)
+			keyInfo[key] = map[string]interface{}{
+				"role":         name,
+				"state":        requestIssued,
+				"reason":       issue.Reason,
+				"approver_ids": issue.ApproverIDs,
+				"expires_at":   issue.ExpiresAt,
+				"break_glass":  issue.BreakGlass,
+			}
+		}
+	}
+
+	return logical.ListResponseWithInfo(keys, keyInfo), nil
+}
+
+// callerID returns the caller's identity, like validateBoundApproverIDs.
+func (b *backend) callerID(ctx context.Context, r *logical.Request) (string, error) {
+
+	cfg, err := b.config(ctx, r.Storage)
+	if err != nil {
+		return "", errors.New("could not find config: " + err.Error())
+	} else if cfg == nil {
+		return "", errors.New("could not find config")
+	}
+
+	callerID, err := b.getCallerIdentity(r, cfg.IdentityTemplate)
+	if err != nil {
+		return "", errors.New("failed to get caller's identity: " + err.Error())
+	}
+
+	return callerID, nil
+}
+
+// walkRequests calls fn for the requests of all roles.
+func (b *backend) walkRequests(ctx context.Context, s logical.Storage, fn func(name string, sr *requestStorageEntry) error) error {
+
+	roles, err := b.requestAccessor.list(ctx, s, "")
+	if err != nil {
+		return errors.Wrap(err, "failed to list requests")
+	}
+
+	for _, name := range roles {
+		name = strings.TrimSuffix(name, "/")
+		nonces, err := b.requestAccessor.list(ctx, s, name)
+		if err != nil {
+			return errors.Wrapf(err, "failed to list requests of role %q", name)
+		}
+
+		for _, nonce := range nonces {
+			sr, err := b.request(ctx, s, name, nonce)
+			if err != nil {
+				return err
+			} else if sr == nil {
+				continue
+			}
+
+			if err = fn(name, sr); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// requestInfo returns the key info of a request in inbox and mine.
+func requestInfo(name string, sr *requestStorageEntry, now time.Time) map[string]interface{} {
+
+	info := map[string]interface{}{
+		"role":          name,
+		"state":         sr.state(now),
+		"requester_id":  sr.RequesterID,
+		"reason":        sr.Reason,
+		"ticket":        sr.Ticket,
+		"approver_ids":  sr.ApproverIDs,
+		"min_approvers": sr.MinApprovers,
+		"expires_at":    sr.ExpiresAt,
+	}
+	if !sr.CreatedAt.IsZero() {
+		info["age"] = int64(now.Sub(sr.CreatedAt) / time.Second)
+	}
+
+	return info
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestInbox_Mine(t *testing.T) {
+	b, storage := getBackendWithEntities(t, "http://127.0.0.1:0")
+	ctx := context.Background()
+
+	if err := b.roleAccessor.put(ctx, storage, &roleStorageEntry{SecretPath: "secret/admin", BoundApproverIDs: []string{"alice"}}, "admin"); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.roleAccessor.put(ctx, storage, &roleStorageEntry{SecretPath: "secret/dev", MinApprovers: 2}, "dev"); err != nil {
+		t.Fatal(err)
+	}
+
+	expiresAt := time.Now().Add(time.Hour)
+	requests := map[string]*requestStorageEntry{
+		"admin/r1": {Nonce: "r1", State: requestPending, RequesterID: "bob", BoundApproverIDs: []string{"alice"}, MinApprovers: 1, ExpiresAt: expiresAt},
+		"dev/r2":   {Nonce: "r2", State: requestPending, RequesterID: "bob", MinApprovers: 2, ExpiresAt: expiresAt},
+		"dev/r3":   {Nonce: "r3", State: requestPending, RequesterID: "alice", MinApprovers: 2, ExpiresAt: expiresAt},
+		"dev/r4":   {Nonce: "r4", State: requestPending, RequesterID: "bob", ApproverIDs: []string{"alice"}, MinApprovers: 2, ExpiresAt: expiresAt},
+		"dev/r5":   {Nonce: "r5", State: requestRejected, RequesterID: "bob", MinApprovers: 2, ExpiresAt: expiresAt},
+	}
+	for key, sr := range requests {
+		name := key[:len(key)-3]
+		if err := b.putRequest(ctx, storage, sr, name, sr.Nonce); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	issue := &issueStorageEntry{Nonce: "i1", IssuerID: "bob", ExpiresAt: expiresAt}
+	if err := b.issueAccessor.put(ctx, storage, issue, "dev", "i1"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path, entityID string
+		expected       []string
+	}{
+		{path: "inbox", entityID: "alice", expected: []string{"request/admin/r1", "request/dev/r2"}},
+		{path: "inbox", entityID: "carol", expected: []string{"request/dev/r2", "request/dev/r3", "request/dev/r4"}},
+		{path: "mine", entityID: "bob", expected: []string{"issue/dev/i1", "request/admin/r1", "request/dev/r2", "request/dev/r4", "request/dev/r5"}},
+		{path: "mine", entityID: "carol", expected: []string{}},
+	}
+
+	for _, tc := range tests {
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation: logical.ListOperation,
+			Path:      tc.path,
+			Storage:   storage,
+			EntityID:  tc.entityID,
+		})
+		if err != nil || resp == nil || resp.IsError() {
+			t.Fatalf("err:%s resp:%#v\n", err, resp)
+		}
+
+		keys, _ := resp.Data["keys"].([]string)
+		sort.Strings(keys)
+		if len(keys) != len(tc.expected) || (len(keys) > 0 && !reflect.DeepEqual(keys, tc.expected)) {
+			t.Fatalf("%s of %s: expected %v got %v\n", tc.path, tc.entityID, tc.expected, keys)
+		}
+	}
+}
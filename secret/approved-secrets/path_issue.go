@@ -2,10 +2,7 @@ package main
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
-	"net/http"
-	"path"
 	"regexp"
 	"strings"
 	"time"
@@ -29,6 +26,10 @@ func pathIssue(b *backend) *framework.Path {
 				Description: "Nonce generated by approved request.",
 				Required:    true,
 			},
+			"totp": {
+				Type:        framework.TypeString,
+				Description: "TOTP code, required if the role requires MFA.",
+			},
 		},
 		ExistenceCheck: b.pathIssueExistenceCheck,
 		Callbacks: map[logical.Operation]framework.OperationFunc{
@@ -56,11 +57,37 @@ func (b *backend) pathIssueDelete(ctx context.Context, r *logical.Request, d *fr
 
 	name := d.Get("name").(string)
 	nonce := d.Get("nonce").(string)
-	if err := b.issueAccessor.delete(ctx, r.Storage, path.Join(name, nonce)); err != nil {
+
+	b.leaseMutex.Lock()
+	defer b.leaseMutex.Unlock()
+
+	issue, err := b.issue(ctx, r.Storage, name, nonce)
+	if err != nil {
+		return nil, err
+	} else if issue == nil {
+		return nil, nil
+	}
+
+	cfg, err := b.config(ctx, r.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = revokeDownstreamSecrets(ctx, cfg, issue.Downstream); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	if err := b.issueAccessor.delete(ctx, r.Storage, name, nonce); err != nil {
 		return nil, err
 	}
 
-	return nil, nil
+	return b.recordLeaseEnd(ctx, r, name, issue.SlackThreads, notificationEvent{
+		Type:   eventSecretRevoked,
+		Actor:  issue.IssuerID,
+		Role:   name,
+		Nonce:  nonce,
+		Reason: "issue deleted",
+	})
 }
 
 func pathListIssue(b *backend) *framework.Path {
@@ -123,6 +150,9 @@ func (b *backend) pathIssueRead(ctx context.Context, r *logical.Request, d *fram
 			"expires_at":   issue.ExpiresAt,
 			"issuer_id":    issue.IssuerID,
 			"approver_ids": issue.ApproverIDs,
+			"reason":       issue.Reason,
+			"approvals":    issue.Approvals,
+			"break_glass":  issue.BreakGlass,
 		},
 	}
 
@@ -139,13 +169,11 @@ func (b *backend) pathIssueCreateUpdate(ctx context.Context, r *logical.Request,
 		return logical.ErrorResponse(fmt.Sprintf("role %q does not exists", roleName)), nil
 	}
 
-	for _, field := range role.SecretRequiredFields {
-		if _, ok := d.GetOk(field); !ok {
-			return logical.ErrorResponse(fmt.Sprintf("missing required field %q", field)), nil
-		}
-	}
-
 	nonce := d.Get("nonce").(string)
+
+	unlock := b.lockRequest(roleName, nonce)
+	defer unlock()
+
 	sr, err := b.request(ctx, r.Storage, roleName, nonce)
 	if err != nil {
 		return nil, err
@@ -154,6 +182,17 @@ func (b *backend) pathIssueCreateUpdate(ctx context.Context, r *logical.Request,
 		return logical.ErrorResponse(fmt.Sprintf("request does not exists for role %q with nonce %q (expired or already issued?)", roleName, nonce)), nil
 	}
 
+	params, err := issueParameters(role, sr, rawParameters(d))
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	for _, field := range role.SecretRequiredFields {
+		if _, ok := params[field]; !ok {
+			return logical.ErrorResponse(fmt.Sprintf("missing required field %q", field)), nil
+		}
+	}
+
 	cfg, err := b.config(ctx, r.Storage)
 	if err != nil {
 		return logical.ErrorResponse("could not find config: " + err.Error()), nil
@@ -168,120 +207,224 @@ func (b *backend) pathIssueCreateUpdate(ctx context.Context, r *logical.Request,
 		return logical.ErrorResponse("only requester %q is allowed to issue secret", sr.RequesterID), nil
 	}
 
-	if len(sr.ApproverIDs) < sr.MinApprovers {
-		return logical.ErrorResponse("request must be approved by at least %d (got %d)", sr.MinApprovers, len(sr.ApproverIDs)), nil
+	if sr.Rejected {
+		return logical.ErrorResponse("request is rejected by %d approver(s)", len(sr.Rejections)), nil
 	}
 
-	if role.ExclusiveLease {
-		leases, err := r.Storage.List(ctx, "issue/"+roleName+"/")
-		if err != nil {
-			return nil, err
-		}
-		if len(leases) > 0 {
-			issue, err := b.issue(ctx, r.Storage, roleName, leases[0])
-			if err != nil {
-				return logical.ErrorResponse("failed to read lease: \n", issue.ExpiresAt), nil
-			}
-
-			if time.Now().After(issue.ExpiresAt) {
-				err := r.Storage.Delete(ctx, path.Join("issue", roleName, nonce))
-				if err != nil {
-					return logical.ErrorResponse(fmt.Sprintf("failed to delete issue for role %q with nonce %q: %s", roleName, nonce, err.Error())), nil
-				}
-			}
-
-			return nil, logical.CodedError(http.StatusForbidden, fmt.Sprintf("role's secret exclusive_lease is set, but active lease found:\n\nnonce: %s\nexpiry: %s", leases[0], issue.ExpiresAt))
-		}
+	if !sr.AutoApproved && len(sr.ApproverIDs) < sr.MinApprovers {
+		return logical.ErrorResponse("request must be approved by at least %d (got %d)", sr.MinApprovers, len(sr.ApproverIDs)), nil
 	}
 
-	config, err := b.config(ctx, r.Storage)
-	if err != nil {
-		return logical.ErrorResponse("could not find config"), nil
+	if !sr.approved() {
+		return logical.ErrorResponse("request does not satisfy any of the approval rules %s", approvalRuleSetsString(sr.ApprovalRules)), nil
 	}
 
-	clt, err := newVaultClient(ctx, config.VaultAddr, config.VaultToken)
-	if err != nil {
-		return logical.ErrorResponse(fmt.Sprintf("failed to create vault client: %s", err)), nil
+	// Approvals are not bound to the window: a request approved ahead of
+	// time is issuable once the window opens.
+	now := time.Now()
+	if !sr.inWindow(now) {
+		return logical.ErrorResponse("request may only be issued between %s and %s", sr.NotBefore.Format(time.RFC3339), sr.NotAfter.Format(time.RFC3339)), nil
 	}
 
-	tokenData := map[string]interface{}{"policies": cfg.VaultPolicies}
-	secret, err := createClientToken(clt, tokenData, issuerID)
-	if err != nil {
-		return logical.ErrorResponse("could not create Vault client token: " + err.Error()), nil
+	if err = evaluatePolicy(policyPhaseIssue, roleName, role, sr, now); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
 	}
 
-	clt.SetToken(secret.Auth.ClientToken)
-
-	var ttl time.Duration
-	if rawTTL, ok := d.GetOk("ttl"); ok {
-		ttl = time.Second * time.Duration(rawTTL.(int))
-	} else {
-		ttl = role.SecretTTL
+	if err = b.requireTOTP(ctx, r.Storage, role, issuerID, d.Get("totp").(string)); err != nil {
+		return logical.ErrorResponse("failed to verify MFA: " + err.Error()), nil
 	}
 
-	var ttlWarning string
-	if ttl > role.SecretMaxTTL {
-		ttlWarning = fmt.Sprintf("Specified ttl is greater than role-secret's max TTL, capped to max TTL: %s", role.SecretMaxTTL)
-		ttl = role.SecretMaxTTL
+	// Reserve the request, so it is issued once only.
+	if err = sr.transition(requestIssued, now); err != nil {
+		return logical.ErrorResponse(fmt.Sprintf("request for role %q with nonce %q cannot be issued: %s", roleName, nonce, err)), nil
+	}
+	if err = b.putRequest(ctx, r.Storage, sr, roleName, nonce); err != nil {
+		return nil, err
 	}
 
-	if strings.ToUpper(role.SecretPathMethod) == "POST" {
-		data := d.Raw
-		data["ttl"] = ttl / time.Second
-		for k, v := range b.applyIdentityTemplateToSecretData(r, role.SecretData) {
-			data[k] = v
-		}
+	ttl, ttlWarning := role.secretTTL(d)
+	if sr.TTL > 0 && ttl > sr.TTL {
+		ttl = sr.TTL
+	}
+	if !sr.NotAfter.IsZero() && ttl > sr.NotAfter.Sub(now) {
+		ttl = sr.NotAfter.Sub(now)
+		ttlWarning = fmt.Sprintf("Specified ttl exceeds the request's not_after, capped to: %s", ttl)
+	}
 
-		if role.SecretType == "vault-token" {
-			secret, err = createClientToken(clt, data, issuerID)
-		} else {
-			secret, err = clt.Logical().Write(role.SecretPath, data)
-		}
-	} else {
-		secret, err = clt.Logical().Read(role.SecretPath)
+	issue := &issueStorageEntry{
+		Nonce:        nonce,
+		ExpiresAt:    now.Add(ttl),
+		IssuerID:     sr.RequesterID,
+		ApproverIDs:  sr.ApproverIDs,
+		Reason:       sr.Reason,
+		Approvals:    sr.Approvals,
+		SlackThreads: sr.SlackThreads,
 	}
-	if err != nil {
-		return logical.ErrorResponse(fmt.Sprintf("failed to %s secret %q: %s", role.SecretPathMethod, role.SecretPath, err)), nil
+
+	if err = b.acquireLease(ctx, r.Storage, role, roleName, issue); err != nil {
+		b.releaseRequest(ctx, r.Storage, sr, roleName, nonce)
+		return nil, err
 	}
 
-	data := secret.Data
-	if role.SecretType == "vault-token" { // Got a vault token
-		bytes, _ := json.Marshal(*secret.Auth)
-		data = map[string]interface{}{}
-		json.Unmarshal(bytes, &data)
+	data, downstream, err := b.fetchSecret(ctx, r, params, cfg, role, issuerID, ttl)
+	if err != nil {
+		if err := b.issueAccessor.delete(ctx, r.Storage, roleName, nonce); err != nil {
+			b.Logger().Warn("failed to release lease", "role", roleName, "nonce", nonce, "error", err)
+		}
+		b.releaseRequest(ctx, r.Storage, sr, roleName, nonce)
+		return logical.ErrorResponse(err.Error()), nil
 	}
 
 	resp := b.Secret(secretTypeApprovedSecretIssue).Response(data, map[string]interface{}{
-		"nonce": nonce,
-		"name":  roleName,
+		"nonce":        nonce,
+		"name":         roleName,
+		"reason":       sr.Reason,
+		"approver_ids": sr.ApproverIDs,
+		"approvals":    sr.Approvals,
 	})
 
 	if ttlWarning != "" {
 		resp.AddWarning(ttlWarning)
 	}
 
-	issue := &issueStorageEntry{
-		Nonce:       nonce,
-		ExpiresAt:   time.Now().Add(ttl),
-		IssuerID:    sr.RequesterID,
-		ApproverIDs: sr.ApproverIDs,
-	}
-
+	issue.Downstream = downstream
 	if err = b.issueAccessor.put(ctx, r.Storage, issue, roleName, nonce); err != nil {
-		return nil, errors.Wrapf(err, "failed to store request")
+		// Without a stored issue the secrets could not be revoked anymore.
+		if err := revokeDownstreamSecrets(ctx, cfg, downstream); err != nil {
+			b.Logger().Warn("failed to revoke secrets of unstored issue", "role", roleName, "nonce", nonce, "error", err)
+		}
+		if err := b.issueAccessor.delete(ctx, r.Storage, roleName, nonce); err != nil {
+			b.Logger().Warn("failed to release lease", "role", roleName, "nonce", nonce, "error", err)
+		}
+		b.releaseRequest(ctx, r.Storage, sr, roleName, nonce)
+		return nil, errors.Wrapf(err, "failed to store issue")
 	}
 
 	resp.Secret.TTL = ttl
 	resp.Secret.MaxTTL = ttl
+	role.wrap(resp)
 
-	err = r.Storage.Delete(ctx, path.Join("request", roleName, nonce))
+	err = b.requestAccessor.delete(ctx, r.Storage, roleName, nonce)
 	if err != nil {
 		resp.AddWarning(fmt.Sprintf("failed to delete request for role %q with nonce %q: %s", roleName, nonce, err.Error()))
 	}
 
+	event := notificationEvent{
+		Type:  eventSecretIssued,
+		Actor: issuerID,
+		Role:  roleName,
+		Nonce: nonce,
+	}
+	if err = b.appendHistory(ctx, r.Storage, event); err != nil {
+		resp.AddWarning(fmt.Sprintf("failed to record history: %s", err))
+	}
+	if _, err = b.notify(cfg, role.NotifySlackChannels, issue.SlackThreads, event); err != nil {
+		resp.AddWarning(err.Error())
+	}
+
 	return resp, nil
 }
 
+// issueParameters returns the parameters sent to the secret path on issue.
+// For roles with a parameter schema, these are the approved parameters of
+// the request, which raw may repeat but not deviate from.
+func issueParameters(role *roleStorageEntry, sr *requestStorageEntry, raw map[string]interface{}) (map[string]interface{}, error) {
+
+	if len(role.Parameters) == 0 {
+		return raw, nil
+	}
+
+	approved, err := validateRequestParameters(role.Parameters, sr.Parameters)
+	if err != nil {
+		return nil, errors.Wrap(err, "approved parameters do not satisfy the role's parameters anymore")
+	}
+
+	merged := make(map[string]interface{})
+	for k, v := range sr.Parameters {
+		merged[k] = v
+	}
+	for k, v := range raw {
+		merged[k] = v
+	}
+
+	actual, err := validateRequestParameters(role.Parameters, merged)
+	if err != nil {
+		return nil, err
+	}
+	if !parametersEqual(approved, actual) {
+		return nil, errors.New("parameters deviate from the approved parameters")
+	}
+
+	return approved, nil
+}
+
+// fetchSecret runs the role's issuance pipeline with a Vault token created
+// for issuerID and returns the combined secret's data and what is needed to
+// revoke the fetched secrets.
+func (b *backend) fetchSecret(ctx context.Context, r *logical.Request, params map[string]interface{}, cfg *configStorageEntry, role *roleStorageEntry, issuerID string, ttl time.Duration) (map[string]interface{}, []downstreamSecret, error) {
+
+	clt, err := newVaultClient(ctx, cfg.VaultAddr, cfg.VaultToken)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create vault client: %s", err)
+	}
+
+	tokenRole, err := b.tokenRole(ctx, r, cfg, issuerID)
+	if err != nil {
+		return nil, nil, errors.New("could not get token role: " + err.Error())
+	}
+
+	tokenData := map[string]interface{}{"policies": cfg.VaultPolicies}
+	secret, err := createClientToken(clt, tokenRole, tokenData, issuerID)
+	if err != nil {
+		return nil, nil, errors.New("could not create Vault client token: " + err.Error())
+	}
+
+	clt.SetToken(secret.Auth.ClientToken)
+
+	c := &stepContext{
+		r:         r,
+		tokenRole: tokenRole,
+		system:    b.System(),
+		params:    params,
+		outputs:   map[string]map[string]interface{}{},
+		ttl:       ttl,
+	}
+
+	result := map[string]interface{}{}
+	var downstream []downstreamSecret
+	for _, step := range role.pipeline() {
+		data, secret, err := b.runStep(clt, step, c, issuerID)
+		if err == nil {
+			downstream = append(downstream, newDownstreamSecret(step.Path, secret))
+			err = step.mapOutput(data, result)
+		}
+		if err != nil {
+			if err := revokeDownstreamSecrets(ctx, cfg, downstream); err != nil {
+				b.Logger().Warn("failed to revoke secrets of failed pipeline", "step", step.Name, "error", err)
+			}
+			if len(role.Steps) > 0 {
+				return nil, nil, errors.Wrapf(err, "step %q failed", step.Name)
+			}
+			return nil, nil, err
+		}
+
+		c.outputs[step.Name] = data
+	}
+
+	if role.OutputTemplate != "" {
+		output, err := renderOutput(role.OutputTemplate, result)
+		if err != nil {
+			if err := revokeDownstreamSecrets(ctx, cfg, downstream); err != nil {
+				b.Logger().Warn("failed to revoke secrets of failed output", "error", err)
+			}
+			return nil, nil, err
+		}
+		result["output"] = output
+	}
+
+	return result, downstream, nil
+}
+
 func (b *backend) applyIdentityTemplateToSecretData(r *logical.Request, secretData map[string]interface{}) map[string]interface{} {
 
 	data := map[string]interface{}{}
@@ -309,8 +452,13 @@ func (b *backend) applyIdentityTemplateToSecretData(r *logical.Request, secretDa
 }
 
 type issueStorageEntry struct {
-	Nonce       string    `json:"nonce"`
-	ExpiresAt   time.Time `json:"expires_at"`
-	IssuerID    string    `json:"issuer_id"`
-	ApproverIDs []string  `json:"approver_ids"`
+	Nonce        string             `json:"nonce"`
+	ExpiresAt    time.Time          `json:"expires_at"`
+	IssuerID     string             `json:"issuer_id"`
+	ApproverIDs  []string           `json:"approver_ids"`
+	Reason       string             `json:"reason"`
+	Approvals    []requestApproval  `json:"approvals"`
+	BreakGlass   bool               `json:"break_glass"`
+	SlackThreads map[string]string  `json:"slack_threads"`
+	Downstream   []downstreamSecret `json:"downstream_secrets"`
 }
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/pkg/errors"
+)
+
+// failingStorage fails to store the keys for which failPut returns true.
+type failingStorage struct {
+	logical.Storage
+	failPut func(key string) bool
+}
+
+func (s *failingStorage) Put(ctx context.Context, entry *logical.StorageEntry) error {
+	if s.failPut(entry.Key) {
+		return errors.New("storage unavailable")
+	}
+	return s.Storage.Put(ctx, entry)
+}
+
+func TestIssue_StoreFailure(t *testing.T) {
+
+	var mu sync.Mutex
+	var revoked []string
+	vault := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var resp interface{}
+		switch {
+		case strings.HasPrefix(r.URL.Path, "/v1/auth/token/create/"):
+			resp = map[string]interface{}{"auth": map[string]interface{}{"client_token": "s.client"}}
+		case strings.HasPrefix(r.URL.Path, "/v1/auth/token/roles/"):
+			w.WriteHeader(http.StatusNoContent)
+			return
+		case r.URL.Path == "/v1/pki/issue/admin":
+			resp = map[string]interface{}{"data": map[string]interface{}{"certificate": "CERT", "serial_number": "7a:17"}}
+		case r.URL.Path == "/v1/pki/revoke":
+			var body map[string]interface{}
+			json.NewDecoder(r.Body).Decode(&body)
+			mu.Lock()
+			revoked = append(revoked, body["serial_number"].(string))
+			mu.Unlock()
+			w.WriteHeader(http.StatusNoContent)
+			return
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(resp)
+	}))
+	defer vault.Close()
+
+	b, inmem := getBackendWithEntities(t, vault.URL)
+	ctx := context.Background()
+
+	// The lease is acquired, but the issue with its secrets is not stored.
+	var issuePuts int
+	storage := &failingStorage{Storage: inmem, failPut: func(key string) bool {
+		if !strings.HasPrefix(key, "issue/") {
+			return false
+		}
+		issuePuts++
+		return issuePuts > 1
+	}}
+
+	role := &roleStorageEntry{SecretPath: "pki/issue/admin", SecretPathMethod: "POST", SecretTTL: time.Hour, MinApprovers: 1}
+	if err := b.roleAccessor.put(ctx, storage, role, "admin"); err != nil {
+		t.Fatal(err)
+	}
+
+	sr := &requestStorageEntry{Nonce: "nonce", State: requestApproved, RequesterID: "requester", MinApprovers: 1, ApproverIDs: []string{"one"}, ExpiresAt: time.Now().Add(time.Hour)}
+	if err := b.putRequest(ctx, storage, sr, "admin", "nonce"); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "issue/admin/nonce",
+		Storage:   storage,
+		EntityID:  "requester",
+	})
+	if err == nil && (resp == nil || !resp.IsError()) {
+		t.Fatalf("Expected error when issue cannot be stored, got %#v\n", resp)
+	}
+
+	if len(revoked) != 1 || revoked[0] != "7a:17" {
+		t.Fatalf("Expected certificate to be revoked, got %v\n", revoked)
+	}
+	if issue, err := b.issue(ctx, storage, "admin", "nonce"); err != nil || issue != nil {
+		t.Fatalf("Expected lease to be released, got %#v %v\n", issue, err)
+	}
+	if stored, err := b.request(ctx, storage, "admin", "nonce"); err != nil || stored.state(time.Now()) != requestApproved {
+		t.Fatalf("Expected request to be released, got %#v %v\n", stored, err)
+	}
+}
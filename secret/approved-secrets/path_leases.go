@@ -0,0 +1,245 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/pkg/errors"
+)
+
+func pathListLeases(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "leases/" + framework.GenericNameRegex("name") + "/?$",
+		Fields: map[string]*framework.FieldSchema{
+			"name": {
+				Type:        framework.TypeString,
+				Description: "Name of role for leases.",
+				Required:    true,
+			},
+		},
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ListOperation: b.pathLeasesList,
+			logical.ReadOperation: b.pathLeasesList,
+		},
+	}
+}
+
+func pathRevokeLease(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "leases/" + framework.GenericNameRegex("name") + "/" + framework.GenericNameRegex("nonce") + "/revoke",
+		Fields: map[string]*framework.FieldSchema{
+			"name": {
+				Type:        framework.TypeString,
+				Description: "Name of role of lease.",
+				Required:    true,
+			},
+			"nonce": {
+				Type:        framework.TypeString,
+				Description: "Nonce of issue holding the lease.",
+				Required:    true,
+			},
+			"reason": {
+				Type:        framework.TypeString,
+				Description: "Optional reason for releasing the lease.",
+			},
+		},
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.CreateOperation: b.pathLeaseRevoke,
+			logical.UpdateOperation: b.pathLeaseRevoke,
+		},
+	}
+}
+
+func (b *backend) pathLeasesList(ctx context.Context, r *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+
+	name := d.Get("name").(string)
+	role, err := b.role(ctx, r.Storage, name)
+	if err != nil {
+		return nil, err
+	} else if role == nil {
+		return logical.ErrorResponse(fmt.Sprintf("role %q does not exists", name)), nil
+	}
+
+	b.leaseMutex.Lock()
+	leases, err := b.activeLeases(ctx, r.Storage, name)
+	b.leaseMutex.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]string, 0)
+	keyInfo := make(map[string]interface{})
+	for _, issue := range leases {
+		keys = append(keys, issue.Nonce)
+		keyInfo[issue.Nonce] = map[string]interface{}{
+			"issuer_id":   issue.IssuerID,
+			"expires_at":  issue.ExpiresAt,
+			"break_glass": issue.BreakGlass,
+		}
+	}
+
+	resp := logical.ListResponseWithInfo(keys, keyInfo)
+	resp.Data["max_concurrent_leases"] = role.MaxConcurrentLeases
+
+	return resp, nil
+}
+
+func (b *backend) pathLeaseRevoke(ctx context.Context, r *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+
+	name := d.Get("name").(string)
+	nonce := d.Get("nonce").(string)
+
+	b.leaseMutex.Lock()
+	defer b.leaseMutex.Unlock()
+
+	issue, err := b.issue(ctx, r.Storage, name, nonce)
+	if err != nil {
+		return nil, err
+	} else if issue == nil {
+		return logical.ErrorResponse(fmt.Sprintf("lease does not exist for role %q with nonce %q", name, nonce)), nil
+	}
+
+	cfg, err := b.config(ctx, r.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = revokeDownstreamSecrets(ctx, cfg, issue.Downstream); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	if err = b.issueAccessor.delete(ctx, r.Storage, name, nonce); err != nil {
+		return nil, errors.Wrapf(err, "failed to release lease for role %q with nonce %q", name, nonce)
+	}
+
+	actor := r.DisplayName
+	if cfg != nil {
+		if callerID, err := b.getCallerIdentity(r, cfg.IdentityTemplate); err == nil {
+			actor = strings.ToLower(callerID)
+		}
+	}
+
+	reason := fmt.Sprintf("lease released by %s", actor)
+	if comment := d.Get("reason").(string); comment != "" {
+		reason += ": " + comment
+	}
+
+	return b.recordLeaseEnd(ctx, r, name, issue.SlackThreads, notificationEvent{
+		Type:   eventSecretRevoked,
+		Actor:  actor,
+		Role:   name,
+		Nonce:  nonce,
+		Reason: reason,
+	})
+}
+
+// activeLeases returns the unexpired issues of a role. Expired issues are
+// released from the registry once their downstream secrets are revoked. The
+// caller must hold leaseMutex.
+func (b *backend) activeLeases(ctx context.Context, s logical.Storage, name string) ([]*issueStorageEntry, error) {
+
+	nonces, err := b.issueAccessor.list(ctx, s, name)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to list leases of role %q", name)
+	}
+
+	var cfg *configStorageEntry
+	now := time.Now()
+	var leases []*issueStorageEntry
+	for _, nonce := range nonces {
+		issue, err := b.issue(ctx, s, name, nonce)
+		if err != nil {
+			return nil, err
+		} else if issue == nil {
+			continue
+		}
+
+		if now.Before(issue.ExpiresAt) {
+			leases = append(leases, issue)
+			continue
+		}
+
+		if len(issue.Downstream) > 0 {
+			if cfg == nil {
+				if cfg, err = b.config(ctx, s); err != nil {
+					return nil, err
+				}
+			}
+			// Kept for the next sweep or the revocation by Vault, but no
+			// longer counted as active.
+			if err = revokeDownstreamSecrets(ctx, cfg, issue.Downstream); err != nil {
+				b.Logger().Warn("failed to revoke downstream secret", "role", name, "nonce", nonce, "error", err)
+				continue
+			}
+		}
+
+		if err = b.issueAccessor.delete(ctx, s, name, nonce); err != nil {
+			return nil, errors.Wrapf(err, "failed to release expired lease for role %q with nonce %q", name, nonce)
+		}
+
+		event := notificationEvent{
+			Type:   eventSecretRevoked,
+			Actor:  issue.IssuerID,
+			Role:   name,
+			Nonce:  nonce,
+			Reason: "secret lease expired",
+		}
+		if err = b.appendHistory(ctx, s, event); err != nil {
+			b.Logger().Warn("failed to record history", "event", event.Type, "role", name, "nonce", nonce, "error", err)
+		}
+	}
+
+	return leases, nil
+}
+
+// acquireLease registers issue as active lease of the role, unless the
+// role's max_concurrent_leases is reached.
+func (b *backend) acquireLease(ctx context.Context, s logical.Storage, role *roleStorageEntry, name string, issue *issueStorageEntry) error {
+
+	b.leaseMutex.Lock()
+	defer b.leaseMutex.Unlock()
+
+	leases, err := b.activeLeases(ctx, s, name)
+	if err != nil {
+		return err
+	}
+
+	if role.MaxConcurrentLeases > 0 && len(leases) >= role.MaxConcurrentLeases {
+		var active []string
+		for _, lease := range leases {
+			active = append(active, fmt.Sprintf("nonce: %s\nexpiry: %s", lease.Nonce, lease.ExpiresAt))
+		}
+		return logical.CodedError(http.StatusForbidden, fmt.Sprintf("role's max_concurrent_leases of %d is reached, active lease(s) found:\n\n%s", role.MaxConcurrentLeases, strings.Join(active, "\n\n")))
+	}
+
+	if err = b.issueAccessor.put(ctx, s, issue, name, issue.Nonce); err != nil {
+		return errors.Wrapf(err, "failed to store issue")
+	}
+
+	return nil
+}
+
+// expireLeases releases the expired leases of all roles.
+func (b *backend) expireLeases(ctx context.Context, s logical.Storage) error {
+
+	b.leaseMutex.Lock()
+	defer b.leaseMutex.Unlock()
+
+	roles, err := b.issueAccessor.list(ctx, s, "")
+	if err != nil {
+		return errors.Wrap(err, "failed to list leases")
+	}
+
+	for _, name := range roles {
+		if _, err = b.activeLeases(ctx, s, strings.TrimSuffix(name, "/")); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
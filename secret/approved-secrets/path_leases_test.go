@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestLeases_MaxConcurrent(t *testing.T) {
+	b, storage := getBackend(t)
+	ctx := context.Background()
+
+	role := &roleStorageEntry{MaxConcurrentLeases: 1}
+	expired := &issueStorageEntry{Nonce: "expired", ExpiresAt: time.Now().Add(-time.Minute)}
+	if err := b.issueAccessor.put(ctx, storage, expired, "test", expired.Nonce); err != nil {
+		t.Fatal(err)
+	}
+
+	first := &issueStorageEntry{Nonce: "first", ExpiresAt: time.Now().Add(time.Hour)}
+	if err := b.acquireLease(ctx, storage, role, "test", first); err != nil {
+		t.Fatalf("Expected expired lease to be released: %s\n", err)
+	}
+
+	second := &issueStorageEntry{Nonce: "second", ExpiresAt: time.Now().Add(time.Hour)}
+	if err := b.acquireLease(ctx, storage, role, "test", second); err == nil {
+		t.Fatalf("Expected max_concurrent_leases to be enforced\n")
+	}
+
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "leases/test/first/revoke",
+		Storage:   storage,
+	}
+	resp, err := b.HandleRequest(ctx, req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	if err := b.acquireLease(ctx, storage, role, "test", second); err != nil {
+		t.Fatalf("Expected lease to be acquired after release: %s\n", err)
+	}
+
+	leases, err := b.activeLeases(ctx, storage, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(leases) != 1 || leases[0].Nonce != "second" {
+		t.Fatalf("Unexpected active leases: %#v\n", leases)
+	}
+}
+
+func TestLeases_ExpireRevokesDownstream(t *testing.T) {
+
+	var revoked []string
+	fail := false
+	vault := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		if r.URL.Path != "/v1/sys/leases/revoke" || fail {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		revoked = append(revoked, body["lease_id"].(string))
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer vault.Close()
+
+	b, storage := getBackendWithEntities(t, vault.URL)
+	ctx := context.Background()
+
+	expired := &issueStorageEntry{Nonce: "expired", ExpiresAt: time.Now().Add(-time.Minute), Downstream: []downstreamSecret{{LeaseID: "database/creds/admin/abc"}}}
+	if err := b.issueAccessor.put(ctx, storage, expired, "test", expired.Nonce); err != nil {
+		t.Fatal(err)
+	}
+
+	// Revocation fails: the lease is no longer active, but kept to be revoked.
+	fail = true
+	if err := b.expireLeases(ctx, storage); err != nil {
+		t.Fatal(err)
+	}
+	if issue, err := b.issue(ctx, storage, "test", "expired"); err != nil || issue == nil {
+		t.Fatalf("Expected expired lease to be kept after failed revocation, got %#v %v\n", issue, err)
+	}
+	b.leaseMutex.Lock()
+	leases, err := b.activeLeases(ctx, storage, "test")
+	b.leaseMutex.Unlock()
+	if err != nil || len(leases) != 0 {
+		t.Fatalf("Expected no active leases, got %#v %v\n", leases, err)
+	}
+
+	fail = false
+	if err := b.expireLeases(ctx, storage); err != nil {
+		t.Fatal(err)
+	}
+	if len(revoked) != 1 || revoked[0] != "database/creds/admin/abc" {
+		t.Fatalf("Expected downstream lease to be revoked, got %v\n", revoked)
+	}
+	if issue, err := b.issue(ctx, storage, "test", "expired"); err != nil || issue != nil {
+		t.Fatalf("Expected expired lease to be released, got %#v %v\n", issue, err)
+	}
+}
+
+func TestLeases_RevokedOnce(t *testing.T) {
+	b, storage := getBackend(t)
+	ctx := context.Background()
+
+	expired := &issueStorageEntry{Nonce: "expired", IssuerID: "some@yolt.com", ExpiresAt: time.Now().Add(-time.Minute)}
+	if err := b.issueAccessor.put(ctx, storage, expired, "test", expired.Nonce); err != nil {
+		t.Fatal(err)
+	}
+
+	// The sweep releases the lease before Vault revokes it.
+	if err := b.expireLeases(ctx, storage); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := b.secretApprovedSecretIssueRevoke(ctx, &logical.Request{
+		Storage: storage,
+		Secret:  &logical.Secret{InternalData: map[string]interface{}{"name": "test", "nonce": "expired"}},
+	}, nil)
+	if err != nil || resp != nil {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	entry, err := b.history(ctx, storage, "test", "expired")
+	if err != nil {
+		t.Fatal(err)
+	}
+	revoked := 0
+	for _, e := range entry.Events {
+		if e.Type == eventSecretRevoked {
+			revoked++
+		}
+	}
+	if revoked != 1 {
+		t.Fatalf("Expected revocation to be recorded once, got %#v\n", entry.Events)
+	}
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/pkg/errors"
+)
+
+const (
+	// TOTP parameters of RFC 6238, as supported by common authenticator apps.
+	totpPeriod     = 30
+	totpDigits     = 6
+	totpSecretSize = 20
+	totpIssuer     = "approved-secrets"
+)
+
+func pathMFAEnroll(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "mfa/enroll",
+		Fields: map[string]*framework.FieldSchema{
+			"totp": {
+				Type:        framework.TypeString,
+				Description: "Code of the current TOTP secret, required to enroll again.",
+			},
+		},
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ReadOperation:   b.pathMFAEnrollRead,
+			logical.CreateOperation: b.pathMFAEnrollCreateUpdate,
+			logical.UpdateOperation: b.pathMFAEnrollCreateUpdate,
+		},
+	}
+}
+
+func pathAdminMFA(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "admin/mfa/" + framework.MatchAllRegex("identity"),
+		Fields: map[string]*framework.FieldSchema{
+			"identity": {
+				Type:        framework.TypeString,
+				Description: "Identity of which to remove the TOTP secret.",
+				Required:    true,
+			},
+		},
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.DeleteOperation: b.pathAdminMFADelete,
+		},
+	}
+}
+
+type mfaStorageEntry struct {
+	Secret     string    `json:"secret"`
+	EnrolledAt time.Time `json:"enrolled_at"`
+
+	// LastCounter is the time step of the last accepted code, codes of this
+	// or earlier time steps are refused to prevent replays.
+	LastCounter uint64 `json:"last_counter"`
+}
+
+func (b *backend) mfaEnrollment(ctx context.Context, s logical.Storage, identity string) (*mfaStorageEntry, error) {
+
+	entry, err := b.mfaAccessor.get(ctx, s, identity)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get TOTP secret")
+	} else if entry == nil {
+		return nil, nil
+	}
+
+	var enrollment mfaStorageEntry
+	if err := entry.DecodeJSON(&enrollment); err != nil {
+		return nil, errors.Wrapf(err, "failed to decode TOTP secret")
+	}
+
+	return &enrollment, nil
+}
+
+func (b *backend) pathMFAEnrollRead(ctx context.Context, r *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+
+	callerID, err := b.callerID(ctx, r)
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	enrollment, err := b.mfaEnrollment(ctx, r.Storage, callerID)
+	if err != nil {
+		return nil, err
+	}
+
+	data := map[string]interface{}{
+		"identity": callerID,
+		"enrolled": enrollment != nil,
+	}
+	if enrollment != nil {
+		data["enrolled_at"] = enrollment.EnrolledAt
+	}
+
+	return &logical.Response{Data: data}, nil
+}
+
+// pathMFAEnrollCreateUpdate generates a TOTP secret for the caller's
+// identity. Replacing an enrolled secret requires a code of that secret, so a
+// leaked Vault token is not enough to take over a second factor.
+func (b *backend) pathMFAEnrollCreateUpdate(ctx context.Context, r *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+
+	callerID, err := b.callerID(ctx, r)
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	enrollment, err := b.mfaEnrollment(ctx, r.Storage, callerID)
+	if err != nil {
+		return nil, err
+	}
+	if enrollment != nil {
+		if err = b.verifyTOTP(ctx, r.Storage, callerID, d.Get("totp").(string), time.Now()); err != nil {
+			return logical.ErrorResponse("identity %q is already enrolled: %s", callerID, err), nil
+		}
+	}
+
+	raw := make([]byte, totpSecretSize)
+	if _, err = rand.Read(raw); err != nil {
+		return nil, errors.Wrapf(err, "failed to generate TOTP secret")
+	}
+	secret := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(raw)
+
+	b.mfaMutex.Lock()
+	err = b.mfaAccessor.put(ctx, r.Storage, &mfaStorageEntry{Secret: secret, EnrolledAt: time.Now()}, callerID)
+	b.mfaMutex.Unlock()
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to store TOTP secret")
+	}
+
+	query := url.Values{}
+	query.Set("secret", secret)
+	query.Set("issuer", totpIssuer)
+	query.Set("algorithm", "SHA1")
+	query.Set("digits", fmt.Sprint(totpDigits))
+	query.Set("period", fmt.Sprint(totpPeriod))
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"identity": callerID,
+			"secret":   secret,
+			"url":      "otpauth://totp/" + url.PathEscape(totpIssuer+":"+callerID) + "?" + query.Encode(),
+		},
+	}, nil
+}
+
+func (b *backend) pathAdminMFADelete(ctx context.Context, r *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+
+	identity := d.Get("identity").(string)
+
+	b.mfaMutex.Lock()
+	defer b.mfaMutex.Unlock()
+
+	if err := b.mfaAccessor.delete(ctx, r.Storage, identity); err != nil {
+		return nil, errors.Wrapf(err, "failed to delete TOTP secret")
+	}
+
+	return nil, nil
+}
+
+// requireTOTP verifies the code if the role requires MFA.
+func (b *backend) requireTOTP(ctx context.Context, s logical.Storage, role *roleStorageEntry, identity, code string) error {
+
+	if !role.RequireMFA {
+		return nil
+	}
+
+	return b.verifyTOTP(ctx, s, identity, code, time.Now())
+}
+
+// verifyTOTP verifies the code against the identity's TOTP secret, allowing
+// one time step of clock skew. Each time step is accepted once only.
+func (b *backend) verifyTOTP(ctx context.Context, s logical.Storage, identity, code string, now time.Time) error {
+
+	b.mfaMutex.Lock()
+	defer b.mfaMutex.Unlock()
+
+	enrollment, err := b.mfaEnrollment(ctx, s, identity)
+	if err != nil {
+		return err
+	} else if enrollment == nil {
+		return errors.New(fmt.Sprintf("identity %q has no TOTP secret, enroll with mfa/enroll", identity))
+	}
+
+	if code == "" {
+		return errors.New("field 'totp' is mandatory")
+	}
+
+	secret, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(strings.ToUpper(enrollment.Secret))
+	if err != nil {
+		return errors.Wrapf(err, "failed to decode TOTP secret")
+	}
+
+	counter := uint64(now.Unix()) / totpPeriod
+	for _, c := range []uint64{counter - 1, counter, counter + 1} {
+		if c <= enrollment.LastCounter {
+			continue // Already used.
+		}
+		if hmac.Equal([]byte(totpCode(secret, c)), []byte(code)) {
+			enrollment.LastCounter = c
+			if err = b.mfaAccessor.put(ctx, s, enrollment, identity); err != nil {
+				return errors.Wrapf(err, "failed to store TOTP secret")
+			}
+			return nil
+		}
+	}
+
+	return errors.New("invalid or already used totp code")
+}
+
+// totpCode returns the code of the time step counter, see RFC 4226 and 6238.
+func totpCode(secret []byte, counter uint64) string {
+
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], counter)
+
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	return fmt.Sprintf("%0*d", totpDigits, value%1000000)
+}
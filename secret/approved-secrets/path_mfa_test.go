@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"encoding/base32"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestMFA_TOTPCode(t *testing.T) {
+
+	// Test vector of RFC 6238 for SHA1, truncated to 6 digits.
+	if code := totpCode([]byte("12345678901234567890"), 59/totpPeriod); code != "287082" {
+		t.Fatalf("Expected code 287082, got %s\n", code)
+	}
+}
+
+func TestMFA_Approve(t *testing.T) {
+	b, storage := getBackendWithEntities(t, "http://127.0.0.1:0")
+	ctx := context.Background()
+
+	if err := b.roleAccessor.put(ctx, storage, &roleStorageEntry{SecretPath: "secret/admin", MinApprovers: 1, RequireMFA: true}, "admin"); err != nil {
+		t.Fatal(err)
+	}
+	sr := &requestStorageEntry{Nonce: "r1", State: requestPending, RequesterID: "bob", MinApprovers: 1, ExpiresAt: time.Now().Add(time.Hour)}
+	if err := b.putRequest(ctx, storage, sr, "admin", "r1"); err != nil {
+		t.Fatal(err)
+	}
+
+	call := func(path string, data map[string]interface{}) *logical.Response {
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      path,
+			Storage:   storage,
+			EntityID:  "alice",
+			Data:      data,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	if resp := call("approve/admin", map[string]interface{}{"nonce": "r1"}); resp == nil || !resp.IsError() {
+		t.Fatalf("Expected error for approver without TOTP secret, got %#v\n", resp)
+	}
+
+	resp := call("mfa/enroll", map[string]interface{}{})
+	if resp == nil || resp.IsError() {
+		t.Fatalf("Expected enrollment, got %#v\n", resp)
+	}
+	secret, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(resp.Data["secret"].(string))
+	if err != nil {
+		t.Fatal(err)
+	}
+	code := totpCode(secret, uint64(time.Now().Unix())/totpPeriod)
+
+	if resp := call("mfa/enroll", map[string]interface{}{}); resp == nil || !resp.IsError() {
+		t.Fatalf("Expected error when enrolling again without code, got %#v\n", resp)
+	}
+	if resp := call("approve/admin", map[string]interface{}{"nonce": "r1", "totp": "000000"}); resp == nil || !resp.IsError() {
+		t.Fatalf("Expected error for invalid code, got %#v\n", resp)
+	}
+	if resp := call("approve/admin", map[string]interface{}{"nonce": "r1", "totp": code}); resp == nil || resp.IsError() {
+		t.Fatalf("Expected approval with valid code, got %#v\n", resp)
+	}
+
+	// The code is accepted once only.
+	if err := b.verifyTOTP(ctx, storage, "alice", code, time.Now()); err == nil {
+		t.Fatalf("Expected replayed code to be refused\n")
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/pkg/errors"
+)
+
+func pathReject(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "reject/" + framework.GenericNameRegex("name"),
+		Fields: map[string]*framework.FieldSchema{
+			"name": {
+				Type:        framework.TypeString,
+				Description: "Name of role for reject.",
+				Required:    true,
+			},
+			"nonce": {
+				Type:        framework.TypeString,
+				Description: "Nonce generated by request.",
+				Required:    true,
+			},
+			"comment": {
+				Type:        framework.TypeString,
+				Description: "Reason for rejecting the request.",
+				Required:    true,
+			},
+		},
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.CreateOperation: b.pathRejectCreateUpdate,
+			logical.UpdateOperation: b.pathRejectCreateUpdate,
+		},
+	}
+}
+
+func (b *backend) pathRejectCreateUpdate(ctx context.Context, r *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+
+	name := d.Get("name").(string)
+	role, err := b.role(ctx, r.Storage, name)
+	if err != nil {
+		return nil, err
+	}
+	if role == nil {
+		return logical.ErrorResponse(fmt.Sprintf("role %q does not exists", name)), nil
+	}
+
+	comment := d.Get("comment").(string)
+	if comment == "" {
+		return logical.ErrorResponse("field 'comment' is mandatory"), nil
+	}
+
+	nonce := d.Get("nonce").(string)
+
+	unlock := b.lockRequest(name, nonce)
+	defer unlock()
+
+	sr, err := b.request(ctx, r.Storage, name, nonce)
+	if err != nil {
+		return nil, err
+	}
+	if sr == nil {
+		return logical.ErrorResponse(fmt.Sprintf("request does not exists for role %q with nonce %q (expired or already issued?)", name, nonce)), nil
+	}
+
+	if sr.Rejected {
+		return logical.ErrorResponse(fmt.Sprintf("request for role %q with nonce %q is already rejected", name, nonce)), nil
+	}
+
+	rejecterID, err := b.validateBoundApproverIDs(ctx, r, sr)
+	if err != nil {
+		return logical.ErrorResponse("failed to validate bound_approver_ids: " + err.Error()), nil
+	}
+
+	if _, err = b.verifyCallerGroups(r, role.BoundApproverRoles, role.BoundApproverGroups); err != nil {
+		return logical.ErrorResponse("failed to validate bound_approver_roles and bound_approver_groups: " + err.Error()), nil
+	}
+
+	now := time.Now()
+	next := sr.state(now)
+
+	sr.Rejections = append(sr.Rejections, requestRejection{
+		RejecterID: strings.ToLower(rejecterID),
+		Comment:    comment,
+		RejectedAt: now,
+	})
+	sr.Rejected = len(sr.Rejections) >= sr.minRejecters()
+	if sr.Rejected {
+		next = requestRejected
+	}
+	if err = sr.transition(next, now); err != nil {
+		return logical.ErrorResponse(fmt.Sprintf("request for role %q with nonce %q cannot be rejected: %s", name, nonce, err)), nil
+	}
+
+	if err = b.putRequest(ctx, r.Storage, sr, name, nonce); err != nil {
+		return nil, err
+	}
+
+	data := map[string]interface{}{
+		"nonce":         sr.Nonce,
+		"state":         sr.State,
+		"expires_at":    sr.ExpiresAt,
+		"requester_id":  sr.RequesterID,
+		"approver_ids":  sr.ApproverIDs,
+		"rejections":    sr.Rejections,
+		"rejected":      sr.Rejected,
+		"min_rejecters": sr.minRejecters(),
+	}
+
+	resp := &logical.Response{Data: data}
+
+	cfg, err := b.config(ctx, r.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	event := notificationEvent{
+		Type:   eventRequestRejected,
+		Actor:  rejecterID,
+		Role:   name,
+		Nonce:  nonce,
+		Reason: comment,
+	}
+	if err = b.appendHistory(ctx, r.Storage, event); err != nil {
+		return nil, errors.Wrapf(err, "failed to record history")
+	}
+	if sr.Rejected {
+		event.Type = eventRequestDenied
+		if err = b.appendHistory(ctx, r.Storage, event); err != nil {
+			return nil, errors.Wrapf(err, "failed to record history")
+		}
+	}
+	if _, err = b.notify(cfg, role.NotifySlackChannels, sr.SlackThreads, event); err != nil {
+		resp.AddWarning(err.Error())
+	}
+
+	return resp, nil
+}
+
+type requestRejection struct {
+	RejecterID string    `json:"rejecter_id"`
+	Comment    string    `json:"comment"`
+	RejectedAt time.Time `json:"rejected_at"`
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestReject_MinRejecters(t *testing.T) {
+	b, storage := getBackendWithEntities(t, "http://127.0.0.1:0")
+	ctx := context.Background()
+
+	if err := b.roleAccessor.put(ctx, storage, &roleStorageEntry{SecretPath: "secret/admin", MinApprovers: 2, MinRejecters: 2}, "admin"); err != nil {
+		t.Fatal(err)
+	}
+
+	sr := &requestStorageEntry{Nonce: "nonce", State: requestPending, RequesterID: "requester", MinApprovers: 2, MinRejecters: 2, ExpiresAt: time.Now().Add(time.Hour)}
+	if err := b.putRequest(ctx, storage, sr, "admin", "nonce"); err != nil {
+		t.Fatal(err)
+	}
+
+	handle := func(path, entityID string) *logical.Response {
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      path,
+			Storage:   storage,
+			EntityID:  entityID,
+			Data:      map[string]interface{}{"nonce": "nonce", "comment": "objection by " + entityID},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	// One rejection below min_rejecters leaves the request pending.
+	resp := handle("reject/admin", "one")
+	if resp == nil || resp.IsError() || resp.Data["rejected"] != false || resp.Data["state"] != requestPending {
+		t.Fatalf("Expected partial rejection, got %#v\n", resp)
+	}
+
+	for _, path := range []string{"reject/admin", "approve/admin"} {
+		resp = handle(path, "one")
+		if resp == nil || !resp.IsError() || !strings.Contains(resp.Error().Error(), "already rejected by you") {
+			t.Fatalf("%s: expected error for rejecter, got %#v\n", path, resp)
+		}
+	}
+
+	entry, err := b.history(ctx, storage, "admin", "nonce")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.Outcome != historyOutcomePending || len(entry.Events) != 1 || entry.Events[0].Type != eventRequestRejected {
+		t.Fatalf("Expected partial rejection in history, got %#v\n", entry)
+	}
+
+	// Reaching min_rejecters rejects the request.
+	resp = handle("reject/admin", "two")
+	if resp == nil || resp.IsError() || resp.Data["rejected"] != true || resp.Data["state"] != requestRejected {
+		t.Fatalf("Expected rejection, got %#v\n", resp)
+	}
+	if rejections := resp.Data["rejections"].([]requestRejection); len(rejections) != 2 || rejections[1].RejecterID != "two" || rejections[1].Comment != "objection by two" {
+		t.Fatalf("Unexpected rejections: %#v\n", rejections)
+	}
+
+	if resp = handle("reject/admin", "three"); resp == nil || !resp.IsError() {
+		t.Fatalf("Expected error when rejecting rejected request, got %#v\n", resp)
+	}
+
+	if entry, err = b.history(ctx, storage, "admin", "nonce"); err != nil {
+		t.Fatal(err)
+	}
+	if entry.Outcome != historyOutcomeDenied || entry.Events[len(entry.Events)-1].Type != eventRequestDenied {
+		t.Fatalf("Expected denial in history, got %#v\n", entry)
+	}
+}
+
+func TestReject_AmendKeepsRejections(t *testing.T) {
+	b, storage := getBackendWithEntities(t, "http://127.0.0.1:0")
+	ctx := context.Background()
+
+	if err := b.roleAccessor.put(ctx, storage, &roleStorageEntry{SecretPath: "secret/admin", MinApprovers: 1, MinRejecters: 2}, "admin"); err != nil {
+		t.Fatal(err)
+	}
+
+	sr := &requestStorageEntry{Nonce: "nonce", State: requestPending, RequesterID: "requester", Reason: "deploy", MinApprovers: 1, MinRejecters: 2, ExpiresAt: time.Now().Add(time.Hour)}
+	if err := b.putRequest(ctx, storage, sr, "admin", "nonce"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, req := range []struct {
+		path, entityID string
+		data           map[string]interface{}
+	}{
+		{"reject/admin", "one", map[string]interface{}{"nonce": "nonce", "comment": "no"}},
+		{"amend/admin", "requester", map[string]interface{}{"nonce": "nonce", "reason": "deploy b"}},
+	} {
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      req.path,
+			Storage:   storage,
+			EntityID:  req.entityID,
+			Data:      req.data,
+		})
+		if err != nil || resp == nil || resp.IsError() {
+			t.Fatalf("%s: err:%s resp:%#v\n", req.path, err, resp)
+		}
+	}
+
+	stored, err := b.request(ctx, storage, "admin", "nonce")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.State != requestPending || len(stored.Rejections) != 1 || stored.Rejections[0].RejecterID != "one" {
+		t.Fatalf("Expected amended request to keep rejections, got %#v\n", stored)
+	}
+}
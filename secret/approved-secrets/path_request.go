@@ -3,11 +3,9 @@ package main
 import (
 	"context"
 	"fmt"
-	"path"
 	"strings"
 	"time"
 
-	"github.com/ashwanthkumar/slack-go-webhook"
 	uuid "github.com/hashicorp/go-uuid"
 	"github.com/hashicorp/vault/sdk/framework"
 	"github.com/hashicorp/vault/sdk/logical"
@@ -33,6 +31,22 @@ func pathRequest(b *backend) *framework.Path {
 				Description: "Reason for requesting secret.",
 				Required:    true,
 			},
+			"ticket": {
+				Type:        framework.TypeString,
+				Description: "Ticket justifying the request, required if the role has a ticket_pattern.",
+			},
+			"ttl": {
+				Type:        framework.TypeDurationSecond,
+				Description: "Requested duration in seconds of the secret (capped to secret_max_ttl). Defaults to the role's secret_ttl.",
+			},
+			"not_before": {
+				Type:        framework.TypeTime,
+				Description: "Start of the window in which the secret may be issued (RFC3339 or epoch). Approvals given before the window count once it opens.",
+			},
+			"not_after": {
+				Type:        framework.TypeTime,
+				Description: "End of the window in which the secret may be issued (RFC3339 or epoch). Defaults to not_before plus approval_ttl.",
+			},
 		},
 		ExistenceCheck: b.pathRequestExistenceCheck,
 		Callbacks: map[logical.Operation]framework.OperationFunc{
@@ -89,28 +103,41 @@ func (b *backend) pathRequestRead(ctx context.Context, r *logical.Request, d *fr
 
 	resp := &logical.Response{
 		Data: map[string]interface{}{
-			"nonce":                nonce,
-			"expires_at":           sr.ExpiresAt,
-			"requester_id":         sr.RequesterID,
-			"approver_ids":         sr.ApproverIDs,
-			"bound_approver_ids":   sr.BoundApproverIDs,
-			"bound_approver_roles": sr.BoundApproverRoles,
+			"nonce":                 nonce,
+			"state":                 sr.state(time.Now()),
+			"version":               sr.Version,
+			"expires_at":            sr.ExpiresAt,
+			"not_before":            sr.NotBefore,
+			"not_after":             sr.NotAfter,
+			"requester_id":          sr.RequesterID,
+			"reason":                sr.Reason,
+			"ticket":                sr.Ticket,
+			"ticket_url":            sr.TicketURL,
+			"ttl":                   int64(sr.TTL / time.Second),
+			"auto_approved":         sr.AutoApproved,
+			"parameters":            sr.Parameters,
+			"approver_ids":          sr.ApproverIDs,
+			"approvals":             sr.Approvals,
+			"bound_approver_ids":    sr.BoundApproverIDs,
+			"bound_approver_roles":  sr.BoundApproverRoles,
+			"bound_approver_groups": sr.BoundApproverGroups,
+			"rejections":            sr.Rejections,
+			"rejected":              sr.Rejected,
 		},
 	}
 
 	return resp, nil
 }
 
+// pathRequestDelete cancels the request like cancel/:name.
 func (b *backend) pathRequestDelete(ctx context.Context, r *logical.Request, d *framework.FieldData) (*logical.Response, error) {
 
-	roleName := d.Get("name").(string)
-	nonce := d.Get("nonce").(string)
-
-	if err := b.requestAccessor.delete(ctx, r.Storage, path.Join(roleName, nonce)); err != nil {
-		return nil, err
+	reason := d.Get("reason").(string)
+	if reason == "" {
+		reason = "request deleted"
 	}
 
-	return nil, nil
+	return b.cancelRequest(ctx, r, d.Get("name").(string), d.Get("nonce").(string), reason, false)
 }
 
 func (b *backend) pathRequestExistenceCheck(ctx context.Context, r *logical.Request, d *framework.FieldData) (bool, error) {
@@ -166,66 +193,178 @@ func (b *backend) pathRequestCreateUpdate(ctx context.Context, r *logical.Reques
 		return logical.ErrorResponse("failed to validate bound_requester_ids: " + err.Error()), nil
 	}
 
-	if err = b.verifyCallerRoles(ctx, r, role.BoundRequesterRoles); err != nil {
-		return logical.ErrorResponse("failed to validate bound_requester_roles: " + err.Error()), nil
+	requesterGroups, err := b.verifyCallerGroups(r, role.BoundRequesterRoles, role.BoundRequesterGroups)
+	if err != nil {
+		return logical.ErrorResponse("failed to validate bound_requester_roles and bound_requester_groups: " + err.Error()), nil
+	}
+
+	tk, err := b.validateTicket(cfg, role, d.Get("ticket").(string), requesterID)
+	if err != nil {
+		return logical.ErrorResponse("failed to validate ticket: " + err.Error()), nil
+	} else if tk == nil {
+		tk = &ticket{}
+	}
+
+	var params map[string]interface{}
+	if len(role.Parameters) > 0 {
+		if params, err = validateRequestParameters(role.Parameters, rawParameters(d)); err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		}
+	}
+
+	now := time.Now()
+	notBefore := d.Get("not_before").(time.Time)
+	notAfter := d.Get("not_after").(time.Time)
+	expiresAt, err := requestExpiry(now, notBefore, notAfter, cfg.ApprovalTTL, b.System().MaxLeaseTTL())
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+	if !notBefore.IsZero() && notAfter.IsZero() {
+		notAfter = expiresAt
 	}
 
 	request := &requestStorageEntry{
 		Nonce:               nonce,
-		ExpiresAt:           time.Now().Add(cfg.ApprovalTTL),
+		CreatedAt:           now,
+		ExpiresAt:           expiresAt,
+		NotBefore:           notBefore,
+		NotAfter:            notAfter,
 		RequesterID:         requesterID,
+		RequesterGroups:     requesterGroups,
 		BoundRequesterIDs:   role.BoundRequesterIDs,
 		BoundRequesterRoles: role.BoundRequesterRoles,
 		BoundApproverIDs:    role.BoundApproverIDs,
 		BoundApproverRoles:  role.BoundApproverRoles,
+		BoundApproverGroups: role.BoundApproverGroups,
 		MinApprovers:        role.MinApprovers,
+		ApprovalRules:       role.ApprovalRules,
+		Reason:              reason,
+		Ticket:              tk.Key,
+		TicketURL:           tk.URL,
+		Parameters:          params,
 		ApproverIDs:         []string{},
+		MinRejecters:        role.MinRejecters,
 	}
 
-	if err = b.requestAccessor.put(ctx, r.Storage, request, roleName, nonce); err != nil {
-		return nil, errors.Wrapf(err, "failed to store request")
+	ttl, ttlWarning := role.secretTTL(d)
+	if _, ok := d.GetOk("ttl"); ok {
+		request.TTL = ttl
 	}
 
+	if err = evaluatePolicy(policyPhaseRequest, roleName, role, request, now); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	rule := b.autoApprove(r, role, request, ttl, now)
+	request.State = request.state(now)
+
+	event := notificationEvent{
+		Type:      eventRequestCreated,
+		Actor:     requesterID,
+		Role:      roleName,
+		Nonce:     nonce,
+		Reason:    reason,
+		Ticket:    tk.Key,
+		TicketURL: tk.URL,
+	}
+	if err = b.appendHistory(ctx, r.Storage, event); err != nil {
+		return nil, errors.Wrapf(err, "failed to record history")
+	}
+
+	threads, notifyErr := b.notify(cfg, role.NotifySlackChannels, nil, event)
+	request.SlackThreads = threads
+
+	if err = b.putRequest(ctx, r.Storage, request, roleName, nonce); err != nil {
+		return nil, err
+	}
+
+	if notifyErr != nil {
+		return logical.ErrorResponse(notifyErr.Error()), nil
+	}
+
+	requestTTL := expiresAt.Sub(now)
 	resp := b.Secret(secretTypeApprovedSecretRequest).Response(map[string]interface{}{
-		"nonce":                 nonce,
-		"ttl":                   fmt.Sprintf("%s", cfg.ApprovalTTL),
-		"min_approvers":         role.MinApprovers,
-		"bound_requester_ids":   role.BoundRequesterIDs,
-		"bound_requester_roles": role.BoundRequesterRoles,
-		"bound_approver_ids":    role.BoundApproverIDs,
-		"bound_approver_roles":  role.BoundApproverRoles,
-		"secret_path":           role.SecretPath,
-		"requester_id":          requesterID,
+		"nonce":                  nonce,
+		"state":                  request.State,
+		"ttl":                    fmt.Sprintf("%s", requestTTL),
+		"not_before":             request.NotBefore,
+		"not_after":              request.NotAfter,
+		"min_approvers":          role.MinApprovers,
+		"approval_rules":         approvalRuleSetsString(role.ApprovalRules),
+		"bound_requester_ids":    role.BoundRequesterIDs,
+		"bound_requester_roles":  role.BoundRequesterRoles,
+		"bound_approver_ids":     role.BoundApproverIDs,
+		"bound_approver_roles":   role.BoundApproverRoles,
+		"bound_requester_groups": role.BoundRequesterGroups,
+		"bound_approver_groups":  role.BoundApproverGroups,
+		"secret_path":            role.SecretPath,
+		"requester_id":           requesterID,
+		"reason":                 reason,
+		"ticket":                 tk.Key,
+		"ticket_url":             tk.URL,
+		"parameters":             params,
 	}, map[string]interface{}{
 		"nonce": nonce,
 		"name":  roleName,
 	})
 
-	resp.Secret.TTL = cfg.ApprovalTTL
-	resp.Secret.MaxTTL = cfg.ApprovalTTL
-
-	attach := slack.Attachment{}
-	attach.AddField(slack.Field{Value: fmt.Sprintf("```vault-helper approved-secret-approve -role %s -nonce %s```", roleName, nonce)})
-	attach.AddField(slack.Field{Value: fmt.Sprintf("```vault-helper approved-secret-issue -role %s -nonce %s```", roleName, nonce)})
-	attach.AddField(slack.Field{Value: fmt.Sprintf("*Reason:* %s", reason)})
+	resp.Secret.TTL = requestTTL
+	resp.Secret.MaxTTL = requestTTL
 
-	payload := slack.Payload{
-		Text:        fmt.Sprintf("%s requests role *%q*", requesterID, roleName),
-		Username:    "Vault Approved Secrets Plugin",
-		Attachments: []slack.Attachment{attach},
+	if ttlWarning != "" {
+		resp.AddWarning(ttlWarning)
 	}
 
-	for _, c := range role.NotifySlackChannels {
-		payload.Channel = c
-		errs := slack.Send(cfg.SlackWebhookURL, "", payload)
-		if len(errs) > 0 {
-			return logical.ErrorResponse(fmt.Sprintf("failed to send Slack notification to channel %q: %s", c, errs[0])), nil
+	if rule != nil {
+		resp.Data["auto_approve_rule"] = rule.String()
+		if err = b.recordAutoApproval(ctx, r.Storage, cfg, role, roleName, request, rule, resp); err != nil {
+			return nil, err
 		}
 	}
 
 	return resp, nil
 }
 
+// requestExpiry returns when a request created at now expires. Without an
+// issue window, requests expire after approvalTTL. With a window, requests
+// can be approved ahead of time and expire at the end of the window, which
+// defaults to notBefore plus approvalTTL.
+func requestExpiry(now, notBefore, notAfter time.Time, approvalTTL, maxTTL time.Duration) (time.Time, error) {
+
+	if notBefore.IsZero() && notAfter.IsZero() {
+		return now.Add(approvalTTL), nil
+	}
+
+	if notAfter.IsZero() {
+		notAfter = notBefore.Add(approvalTTL)
+	}
+
+	if !notAfter.After(now) {
+		return time.Time{}, errors.New("not_after must be in the future")
+	}
+
+	if !notBefore.IsZero() && !notAfter.After(notBefore) {
+		return time.Time{}, errors.New("not_after must be after not_before")
+	}
+
+	if maxTTL > 0 && notAfter.Sub(now) > maxTTL {
+		return time.Time{}, errors.Errorf("not_after must be within the mount's max lease TTL (%s)", maxTTL)
+	}
+
+	return notAfter, nil
+}
+
+// inWindow returns whether the secret may be issued at t.
+func (sr *requestStorageEntry) inWindow(t time.Time) bool {
+	if !sr.NotBefore.IsZero() && t.Before(sr.NotBefore) {
+		return false
+	}
+	if !sr.NotAfter.IsZero() && !t.Before(sr.NotAfter) {
+		return false
+	}
+	return true
+}
+
 func (b *backend) validateBoundRequesterIDs(ctx context.Context, r *logical.Request, role *roleStorageEntry) (string, error) {
 
 	cfg, err := b.config(ctx, r.Storage)
@@ -255,13 +394,74 @@ func (b *backend) validateBoundRequesterIDs(ctx context.Context, r *logical.Requ
 }
 
 type requestStorageEntry struct {
-	Nonce               string    `json:"nonce"`
-	ExpiresAt           time.Time `json:"expires_at"`
-	RequesterID         string    `json:"requester_id"`
-	BoundRequesterIDs   []string  `json:"bound_requester_ids"`
-	BoundRequesterRoles []string  `json:"bound_requester_roles"`
-	BoundApproverIDs    []string  `json:"bound_approver_ids"`
-	BoundApproverRoles  []string  `json:"bound_approver_roles"`
-	MinApprovers        int       `json:"min_approvers"`
-	ApproverIDs         []string  `json:"approver_ids"`
+	Nonce               string                 `json:"nonce"`
+	State               requestState           `json:"state,omitempty"`
+	Version             int                    `json:"version"`
+	CreatedAt           time.Time              `json:"created_at"`
+	ExpiresAt           time.Time              `json:"expires_at"`
+	NotBefore           time.Time              `json:"not_before"`
+	NotAfter            time.Time              `json:"not_after"`
+	RequesterID         string                 `json:"requester_id"`
+	RequesterGroups     []string               `json:"requester_groups,omitempty"`
+	BoundRequesterIDs   []string               `json:"bound_requester_ids"`
+	BoundRequesterRoles []string               `json:"bound_requester_roles"`
+	BoundApproverIDs    []string               `json:"bound_approver_ids"`
+	BoundApproverRoles  []string               `json:"bound_approver_roles"`
+	BoundApproverGroups []string               `json:"bound_approver_groups"`
+	MinApprovers        int                    `json:"min_approvers"`
+	ApprovalRules       []approvalRuleSet      `json:"approval_rules"`
+	Reason              string                 `json:"reason"`
+	Ticket              string                 `json:"ticket,omitempty"`
+	TicketURL           string                 `json:"ticket_url,omitempty"`
+	TTL                 time.Duration          `json:"ttl,omitempty"`
+	AutoApproved        bool                   `json:"auto_approved,omitempty"`
+	Parameters          map[string]interface{} `json:"parameters"`
+	ApproverIDs         []string               `json:"approver_ids"`
+	Approvals           []requestApproval      `json:"approvals"`
+	MinRejecters        int                    `json:"min_rejecters"`
+	Rejections          []requestRejection     `json:"rejections"`
+	Rejected            bool                   `json:"rejected"`
+	SlackThreads        map[string]string      `json:"slack_threads"`
+}
+
+// approved returns whether the request is approved automatically or has at
+// least MinApprovers approvals and, if approval rules are set, satisfies any
+// of the rule sets.
+func (sr *requestStorageEntry) approved() bool {
+
+	if sr.AutoApproved {
+		return true
+	}
+
+	if len(sr.ApproverIDs) < sr.MinApprovers {
+		return false
+	}
+
+	if len(sr.ApprovalRules) == 0 {
+		return true
+	}
+
+	for _, set := range sr.ApprovalRules {
+		if set.satisfied(sr.Approvals) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// minRejecters returns the number of rejections that veto the request.
+// Requests stored before rejections were introduced default to one.
+func (sr *requestStorageEntry) minRejecters() int {
+	if sr.MinRejecters < 1 {
+		return 1
+	}
+	return sr.MinRejecters
+}
+
+type requestApproval struct {
+	ApproverID string    `json:"approver_id"`
+	Comment    string    `json:"comment,omitempty"`
+	Groups     []string  `json:"groups,omitempty"`
+	ApprovedAt time.Time `json:"approved_at"`
 }
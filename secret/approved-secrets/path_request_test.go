@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestRequest_Expiry(t *testing.T) {
+	now := time.Now()
+	approvalTTL := time.Hour
+	maxTTL := 24 * time.Hour
+
+	tests := []struct {
+		name                string
+		notBefore, notAfter time.Time
+		expected            time.Time
+		expectErr           bool
+	}{
+		{name: "no window", expected: now.Add(approvalTTL)},
+		{name: "not_before only", notBefore: now.Add(2 * time.Hour), expected: now.Add(3 * time.Hour)},
+		{name: "window", notBefore: now.Add(2 * time.Hour), notAfter: now.Add(4 * time.Hour), expected: now.Add(4 * time.Hour)},
+		{name: "not_after in past", notAfter: now.Add(-time.Minute), expectErr: true},
+		{name: "not_after before not_before", notBefore: now.Add(2 * time.Hour), notAfter: now.Add(time.Hour), expectErr: true},
+		{name: "beyond max lease TTL", notBefore: now.Add(48 * time.Hour), expectErr: true},
+	}
+
+	for _, tc := range tests {
+		actual, err := requestExpiry(now, tc.notBefore, tc.notAfter, approvalTTL, maxTTL)
+		if tc.expectErr {
+			if err == nil {
+				t.Fatalf("%s: expected error\n", tc.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: %s\n", tc.name, err)
+		}
+		if !actual.Equal(tc.expected) {
+			t.Fatalf("%s: expected %s got %s\n", tc.name, tc.expected, actual)
+		}
+	}
+}
+
+func TestRequest_InWindow(t *testing.T) {
+	now := time.Now()
+	sr := &requestStorageEntry{NotBefore: now.Add(time.Hour), NotAfter: now.Add(2 * time.Hour)}
+
+	if sr.inWindow(now) {
+		t.Fatalf("Expected request not to be issuable before not_before\n")
+	}
+	if !sr.inWindow(now.Add(90 * time.Minute)) {
+		t.Fatalf("Expected request to be issuable within window\n")
+	}
+	if sr.inWindow(now.Add(2 * time.Hour)) {
+		t.Fatalf("Expected request not to be issuable at not_after\n")
+	}
+	if !(&requestStorageEntry{}).inWindow(now) {
+		t.Fatalf("Expected request without window to be issuable\n")
+	}
+}
+
+func TestRequest_ReasonAndComment(t *testing.T) {
+	b, storage := getBackendWithEntities(t, "http://127.0.0.1:0")
+	ctx := context.Background()
+
+	if err := b.roleAccessor.put(ctx, storage, &roleStorageEntry{SecretPath: "secret/admin", SecretTTL: time.Hour, SecretMaxTTL: time.Hour, MinApprovers: 2}, "admin"); err != nil {
+		t.Fatal(err)
+	}
+
+	handle := func(op logical.Operation, path, entityID string, data map[string]interface{}) *logical.Response {
+		resp, err := b.HandleRequest(ctx, &logical.Request{Operation: op, Path: path, Storage: storage, EntityID: entityID, Data: data})
+		if err != nil || resp == nil || resp.IsError() {
+			t.Fatalf("%s: err:%s resp:%#v\n", path, err, resp)
+		}
+		return resp
+	}
+
+	nonce := handle(logical.UpdateOperation, "request/admin", "requester", map[string]interface{}{"reason": "incident 42"}).Data["nonce"].(string)
+	handle(logical.UpdateOperation, "approve/admin", "one", map[string]interface{}{"nonce": nonce, "comment": "verified incident"})
+
+	resp := handle(logical.ReadOperation, "request/admin/"+nonce, "requester", nil)
+	approvals := resp.Data["approvals"].([]requestApproval)
+	if resp.Data["reason"] != "incident 42" || len(approvals) != 1 || approvals[0].ApproverID != "one" || approvals[0].Comment != "verified incident" {
+		t.Fatalf("Unexpected reason or approvals: %#v\n", resp.Data)
+	}
+
+	resp = handle(logical.ReadOperation, "history/admin/"+nonce, "", nil)
+	events := resp.Data["events"].([]historyEvent)
+	approvals = resp.Data["approvals"].([]requestApproval)
+	if resp.Data["reason"] != "incident 42" || len(approvals) != 1 || approvals[0].Comment != "verified incident" {
+		t.Fatalf("Unexpected history reason or approvals: %#v\n", resp.Data)
+	}
+	if len(events) != 2 || events[0].Type != eventRequestCreated || events[0].Reason != "incident 42" || events[1].Type != eventRequestApproved || events[1].Reason != "verified incident" {
+		t.Fatalf("Unexpected history events: %#v\n", events)
+	}
+}
+
+func TestRequest_ApprovedBeforeWindow(t *testing.T) {
+	vault := breakGlassVault()
+	defer vault.Close()
+
+	b, storage := getBackendWithEntities(t, vault.URL)
+	ctx := context.Background()
+
+	if err := b.roleAccessor.put(ctx, storage, &roleStorageEntry{SecretPath: "secret/admin", SecretPathMethod: "GET", SecretTTL: time.Hour, MinApprovers: 1}, "admin"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Approved before its window opens.
+	now := time.Now()
+	sr := &requestStorageEntry{Nonce: "nonce", State: requestApproved, RequesterID: "requester", MinApprovers: 1, ApproverIDs: []string{"one"}, NotBefore: now.Add(time.Hour), NotAfter: now.Add(2 * time.Hour), ExpiresAt: now.Add(2 * time.Hour)}
+	if err := b.putRequest(ctx, storage, sr, "admin", "nonce"); err != nil {
+		t.Fatal(err)
+	}
+
+	issue := func() *logical.Response {
+		resp, err := b.HandleRequest(ctx, &logical.Request{Operation: logical.UpdateOperation, Path: "issue/admin/nonce", Storage: storage, EntityID: "requester"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	if resp := issue(); resp == nil || !resp.IsError() {
+		t.Fatalf("Expected error before window, got %#v\n", resp)
+	}
+
+	stored, err := b.request(ctx, storage, "admin", "nonce")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored.NotBefore = now.Add(-time.Minute)
+	if err = b.putRequest(ctx, storage, stored, "admin", "nonce"); err != nil {
+		t.Fatal(err)
+	}
+
+	if resp := issue(); resp == nil || resp.IsError() {
+		t.Fatalf("Expected approval to count once window opens, got %#v\n", resp)
+	}
+}
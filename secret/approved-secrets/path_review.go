@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/pkg/errors"
+)
+
+func pathReview(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "review/" + framework.GenericNameRegex("name") + "/" + framework.GenericNameRegex("nonce"),
+		Fields: map[string]*framework.FieldSchema{
+			"name": {
+				Type:        framework.TypeString,
+				Description: "Name of role of break glass issue.",
+				Required:    true,
+			},
+			"nonce": {
+				Type:        framework.TypeString,
+				Description: "Nonce generated by break glass issue.",
+				Required:    true,
+			},
+			"comment": {
+				Type:        framework.TypeString,
+				Description: "Optional comment for the review.",
+			},
+		},
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ReadOperation:   b.pathHistoryRead,
+			logical.CreateOperation: b.pathReviewCreateUpdate,
+			logical.UpdateOperation: b.pathReviewCreateUpdate,
+		},
+	}
+}
+
+func pathListReview(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "review/" + framework.GenericNameRegex("name") + "/?$",
+		Fields: map[string]*framework.FieldSchema{
+			"name": {
+				Type:        framework.TypeString,
+				Description: "Name of role for review.",
+				Required:    true,
+			},
+		},
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ListOperation: b.pathReviewList,
+		},
+	}
+}
+
+func (b *backend) pathReviewList(ctx context.Context, r *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+
+	name := d.Get("name").(string)
+	nonces, err := b.historyAccessor.list(ctx, r.Storage, name)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]string, 0)
+	keyInfo := make(map[string]interface{})
+	for _, nonce := range nonces {
+		entry, err := b.history(ctx, r.Storage, name, nonce)
+		if err != nil {
+			return nil, err
+		} else if entry == nil || entry.ReviewStatus != historyReviewPending {
+			continue
+		}
+
+		keys = append(keys, nonce)
+		keyInfo[nonce] = map[string]interface{}{
+			"requester_id":    entry.RequesterID,
+			"reason":          entry.Reason,
+			"review_deadline": entry.ReviewDeadline,
+			"review_overdue":  entry.ReviewOverdue,
+		}
+	}
+
+	return logical.ListResponseWithInfo(keys, keyInfo), nil
+}
+
+func (b *backend) pathReviewCreateUpdate(ctx context.Context, r *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+
+	name := d.Get("name").(string)
+	role, err := b.role(ctx, r.Storage, name)
+	if err != nil {
+		return nil, err
+	}
+	if role == nil {
+		return logical.ErrorResponse(fmt.Sprintf("role %q does not exists", name)), nil
+	}
+
+	if _, err = b.verifyCallerGroups(r, role.BoundApproverRoles, role.BoundApproverGroups); err != nil {
+		return logical.ErrorResponse("failed to validate bound_approver_roles and bound_approver_groups: " + err.Error()), nil
+	}
+
+	nonce := d.Get("nonce").(string)
+	event := notificationEvent{
+		Type:   eventReviewAcknowledged,
+		Role:   name,
+		Nonce:  nonce,
+		Reason: d.Get("comment").(string),
+	}
+
+	// The review is checked and recorded under the lock of the history entry,
+	// so concurrent reviews cannot complete it twice.
+	var entry *historyStorageEntry
+	var invalid error
+	err = b.updateHistory(ctx, r.Storage, name, nonce, func(e *historyStorageEntry) error {
+		if !e.BreakGlass {
+			invalid = errors.Errorf("break glass issue does not exist for role %q with nonce %q", name, nonce)
+		} else if e.ReviewStatus != historyReviewPending {
+			invalid = errors.Errorf("break glass issue for role %q with nonce %q is already reviewed", name, nonce)
+		} else if reviewerID, err := b.validateReviewer(ctx, r, role, e); err != nil {
+			invalid = errors.Wrap(err, "failed to validate bound_approver_ids")
+		} else {
+			event.Actor = strings.ToLower(reviewerID)
+			e.append(event)
+			entry = e
+		}
+		return invalid
+	})
+	if invalid != nil {
+		return logical.ErrorResponse(invalid.Error()), nil
+	} else if err != nil {
+		return nil, errors.Wrapf(err, "failed to record history")
+	}
+
+	resp := &logical.Response{Data: entry.data()}
+
+	cfg, err := b.config(ctx, r.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err = b.notify(cfg, role.NotifySlackChannels, entry.SlackThreads, event); err != nil {
+		resp.AddWarning(err.Error())
+	}
+
+	return resp, nil
+}
+
+func (b *backend) validateReviewer(ctx context.Context, r *logical.Request, role *roleStorageEntry, entry *historyStorageEntry) (string, error) {
+
+	cfg, err := b.config(ctx, r.Storage)
+	if err != nil {
+		return "", errors.New("could not find config: " + err.Error())
+	}
+
+	callerID, err := b.getCallerIdentity(r, cfg.IdentityTemplate)
+	if err != nil {
+		return "", errors.New("failed to get caller's identity: " + err.Error())
+	}
+
+	if strings.ToLower(callerID) == strings.ToLower(entry.RequesterID) {
+		return "", errors.New("cannot review your own break glass issue")
+	}
+
+	if len(role.BoundApproverIDs) > 0 && !containsFold(role.BoundApproverIDs, callerID) {
+		return "", errors.New(fmt.Sprintf("%q not in %s", callerID, role.BoundApproverIDs))
+	}
+
+	for _, review := range entry.Reviews {
+		if strings.ToLower(callerID) == strings.ToLower(review.ApproverID) {
+			return "", errors.New("already reviewed by you")
+		}
+	}
+
+	return callerID, nil
+}
+
+// checkOverdueReviews notifies once about break glass issues that are not
+// reviewed before their review deadline.
+func (b *backend) checkOverdueReviews(ctx context.Context, s logical.Storage) error {
+
+	cfg, err := b.config(ctx, s)
+	if err != nil || cfg == nil {
+		return err
+	}
+
+	roles, err := b.historyAccessor.list(ctx, s, "")
+	if err != nil {
+		return errors.Wrap(err, "failed to list history")
+	}
+
+	now := time.Now()
+	for _, name := range roles {
+		name = strings.TrimSuffix(name, "/")
+		nonces, err := b.historyAccessor.list(ctx, s, name)
+		if err != nil {
+			return errors.Wrapf(err, "failed to list history of role %q", name)
+		}
+
+		for _, nonce := range nonces {
+			entry, err := b.history(ctx, s, name, nonce)
+			if err != nil {
+				return err
+			} else if entry == nil || entry.ReviewStatus != historyReviewPending || entry.ReviewOverdue || now.Before(entry.ReviewDeadline) {
+				continue
+			}
+
+			event := notificationEvent{
+				Type:   eventReviewOverdue,
+				Actor:  entry.RequesterID,
+				Role:   name,
+				Nonce:  nonce,
+				Reason: fmt.Sprintf("review deadline %s passed", entry.ReviewDeadline.Format(time.RFC3339)),
+			}
+			notify := false
+			err = b.updateHistory(ctx, s, name, nonce, func(e *historyStorageEntry) error {
+				// Skipped if reviewed or notified since read.
+				if notify = e.ReviewStatus == historyReviewPending && !e.ReviewOverdue; notify {
+					e.append(event)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			} else if !notify {
+				continue
+			}
+
+			role, err := b.role(ctx, s, name)
+			if err != nil || role == nil {
+				continue
+			}
+
+			if _, err = b.notify(cfg, role.NotifySlackChannels, entry.SlackThreads, event); err != nil {
+				b.Logger().Warn("failed to notify", "event", event.Type, "role", name, "nonce", nonce, "error", err)
+			}
+		}
+	}
+
+	return nil
+}
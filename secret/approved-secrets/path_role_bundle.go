@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/pkg/errors"
+)
+
+func pathRoleExport(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "roles/export",
+		Fields: map[string]*framework.FieldSchema{
+			"format": {
+				Type:        framework.TypeString,
+				Default:     bundleFormatJSON,
+				Description: `Format of the bundle, either "json" or "hcl".`,
+			},
+		},
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ReadOperation: b.pathRoleExportRead,
+		},
+	}
+}
+
+func pathRoleImport(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "roles/import",
+		Fields: map[string]*framework.FieldSchema{
+			"roles": {
+				Type:        framework.TypeMap,
+				Description: `Roles by name, each with the fields of roles/:name, as exported in JSON.`,
+			},
+			"bundle": {
+				Type:        framework.TypeString,
+				Description: `Bundle as exported by roles/export, in JSON or HCL. Ignored if roles is set.`,
+			},
+			"prune": {
+				Type:        framework.TypeBool,
+				Default:     false,
+				Description: `Deletes roles that are not in the bundle.`,
+			},
+			"dry_run": {
+				Type:        framework.TypeBool,
+				Default:     false,
+				Description: `Returns the changes without applying them.`,
+			},
+		},
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.CreateOperation: b.pathRoleImportUpdate,
+			logical.UpdateOperation: b.pathRoleImportUpdate,
+		},
+	}
+}
+
+func (b *backend) pathRoleExportRead(ctx context.Context, r *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+
+	format := d.Get("format").(string)
+	if format != bundleFormatJSON && format != bundleFormatHCL {
+		return logical.ErrorResponse(fmt.Sprintf("format must be %q or %q", bundleFormatJSON, bundleFormatHCL)), nil
+	}
+
+	roles, err := b.roles(ctx, r.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	exported := make(map[string]interface{}, len(roles))
+	for name, role := range roles {
+		if exported[name], err = exportRole(role); err != nil {
+			return nil, errors.Wrapf(err, "failed to export role %q", name)
+		}
+	}
+
+	bundle, err := encodeRoleBundle(exported, format)
+	if err != nil {
+		return nil, err
+	}
+
+	data := map[string]interface{}{"bundle": bundle}
+	if format == bundleFormatJSON {
+		data["roles"] = exported
+	}
+
+	return &logical.Response{Data: data}, nil
+}
+
+func (b *backend) pathRoleImportUpdate(ctx context.Context, r *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+
+	raw, err := roleBundleData(d)
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	desired, err := b.bundleRoles(raw)
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	cfg, err := b.config(ctx, r.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	b.roleMutex.Lock()
+	defer b.roleMutex.Unlock()
+
+	actual, err := b.roles(ctx, r.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	drift := compareRoles(desired, actual)
+	deleted := []string{}
+	if d.Get("prune").(bool) {
+		deleted = drift.Extra
+	}
+	if len(deleted) > 0 {
+		if err = b.verifyRoleDeletion(r, cfg); err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		}
+	}
+
+	dryRun := d.Get("dry_run").(bool)
+	resp := &logical.Response{
+		Data: map[string]interface{}{
+			"dry_run": dryRun,
+			"created": drift.Missing,
+			"updated": drift.Differing,
+			"deleted": deleted,
+		},
+	}
+	if dryRun {
+		return resp, nil
+	}
+
+	changed := append([]string{}, drift.Missing...)
+	for name := range drift.Differing {
+		changed = append(changed, name)
+	}
+	sort.Strings(changed)
+
+	snapshot, err := b.snapshotRoles(ctx, r.Storage, append(changed, deleted...))
+	if err != nil {
+		return nil, err
+	}
+
+	pending := map[string]interface{}{}
+	apply := func() error {
+		for _, name := range changed {
+			v, warnings, err := b.putRoleLocked(ctx, r, cfg, name, desired[name])
+			if err != nil {
+				return errors.Wrapf(err, "failed to put role %q", name)
+			}
+			for _, warning := range warnings {
+				resp.AddWarning(warning)
+			}
+			if v != nil && v.State == roleVersionPending {
+				pending[name] = v.Version
+			}
+		}
+		for _, name := range deleted {
+			if err := b.deleteRoleLocked(ctx, r.Storage, name); err != nil {
+				return errors.Wrapf(err, "failed to delete role %q", name)
+			}
+		}
+		return nil
+	}
+
+	if err = apply(); err != nil {
+		if restoreErr := b.restoreRoles(ctx, r.Storage, snapshot); restoreErr != nil {
+			return nil, errors.Wrapf(err, "failed to import roles and to roll back (%s)", restoreErr)
+		}
+		return nil, errors.Wrapf(err, "failed to import roles, rolled back")
+	}
+
+	if len(pending) > 0 {
+		resp.Data["pending"] = pending
+		resp.AddWarning(fmt.Sprintf("%d role change(s) pending approval by role_governance_groups", len(pending)))
+	}
+
+	return resp, nil
+}
+
+// roleSnapshot holds the storage entries of a role and its versions, nil if
+// they do not exist.
+type roleSnapshot struct {
+	name           string
+	role, versions *logical.StorageEntry
+}
+
+func (b *backend) snapshotRoles(ctx context.Context, s logical.Storage, names []string) ([]roleSnapshot, error) {
+
+	snapshot := make([]roleSnapshot, 0, len(names))
+	for _, name := range names {
+		role, err := b.roleAccessor.get(ctx, s, name)
+		if err != nil {
+			return nil, err
+		}
+		versions, err := b.roleVersionAccessor.get(ctx, s, name)
+		if err != nil {
+			return nil, err
+		}
+		snapshot = append(snapshot, roleSnapshot{name: name, role: role, versions: versions})
+	}
+
+	return snapshot, nil
+}
+
+// restoreRoles restores the roles and their versions to the snapshot.
+func (b *backend) restoreRoles(ctx context.Context, s logical.Storage, snapshot []roleSnapshot) error {
+
+	restore := func(a *atomicStorageAccessor, name string, entry *logical.StorageEntry) error {
+		if entry == nil {
+			return a.delete(ctx, s, name)
+		}
+		return s.Put(ctx, entry)
+	}
+
+	for _, rs := range snapshot {
+		if err := restore(b.roleAccessor, rs.name, rs.role); err != nil {
+			return errors.Wrapf(err, "failed to restore role %q", rs.name)
+		}
+		if err := restore(b.roleVersionAccessor, rs.name, rs.versions); err != nil {
+			return errors.Wrapf(err, "failed to restore versions of role %q", rs.name)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestRoleBundle_ExportImport(t *testing.T) {
+	b, storage := getBackend(t)
+	ctx := context.Background()
+
+	roles := map[string]map[string]interface{}{
+		"k8s-admin": {
+			"secret_path":        "k8s/issue/admin",
+			"secret_path_method": "POST",
+			"secret_data":        map[string]interface{}{"common_name": "{{identity.entity.name}}", "alt names": "a,b"},
+			"parameters":         map[string]interface{}{"env": map[string]interface{}{"type": "string", "allowed_values": []interface{}{"dta", "prd"}}},
+			"min_approvers":      2,
+			"approval_rules":     []interface{}{"sre>=1,security>=1"},
+			"bound_approver_ids": "one,two",
+			"policy":             map[string]interface{}{"no self approval": `all(approvers, a, a.id != requester.id) && reason matches '^INC-[0-9]+$'`},
+		},
+		"dta-readonly": {
+			"steps": []interface{}{
+				map[string]interface{}{"name": "token", "path": "auth/token/create", "data": map[string]interface{}{"ttl": "1h"}},
+				map[string]interface{}{"name": "creds", "path": "dta/creds/readonly", "method": "GET"},
+			},
+			"secret_ttl":   "1h",
+			"auto_approve": []interface{}{"group=dev,days=mon-fri,hours=08:00-18:00"},
+		},
+	}
+	for name, data := range roles {
+		resp, err := b.HandleRequest(ctx, &logical.Request{Operation: logical.CreateOperation, Path: "roles/" + name, Storage: storage, Data: data})
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("err:%s resp:%#v\n", err, resp)
+		}
+	}
+
+	for _, format := range []string{bundleFormatJSON, bundleFormatHCL} {
+		resp, err := b.HandleRequest(ctx, &logical.Request{Operation: logical.ReadOperation, Path: "roles/export", Storage: storage, Data: map[string]interface{}{"format": format}})
+		if err != nil || resp.IsError() {
+			t.Fatalf("err:%s resp:%#v\n", err, resp)
+		}
+		bundle := resp.Data["bundle"].(string)
+
+		b2, storage2 := getBackend(t)
+		resp, err = b2.HandleRequest(ctx, &logical.Request{Operation: logical.UpdateOperation, Path: "roles/import", Storage: storage2, Data: map[string]interface{}{"bundle": bundle, "dry_run": true}})
+		if err != nil || resp.IsError() {
+			t.Fatalf("%s: err:%s resp:%#v\n", format, err, resp)
+		}
+		if created := resp.Data["created"].([]string); !reflect.DeepEqual(created, []string{"dta-readonly", "k8s-admin"}) {
+			t.Fatalf("%s: expected both roles to be created, got %v\n", format, created)
+		}
+		if names, _ := b2.roleAccessor.list(ctx, storage2, ""); len(names) != 0 {
+			t.Fatalf("%s: expected dry run not to create roles, got %v\n", format, names)
+		}
+
+		resp, err = b2.HandleRequest(ctx, &logical.Request{Operation: logical.UpdateOperation, Path: "roles/import", Storage: storage2, Data: map[string]interface{}{"bundle": bundle}})
+		if err != nil || resp.IsError() {
+			t.Fatalf("%s: err:%s resp:%#v\n", format, err, resp)
+		}
+
+		resp, err = b.HandleRequest(ctx, &logical.Request{Operation: logical.UpdateOperation, Path: "soll-ist", Storage: storage, Data: map[string]interface{}{"bundle": bundle}})
+		if err != nil || resp.IsError() {
+			t.Fatalf("%s: err:%s resp:%#v\n", format, err, resp)
+		}
+		if resp.Data["in_sync"] != true {
+			t.Fatalf("%s: expected exported bundle to be in sync, got %#v\n", format, resp.Data)
+		}
+
+		resp2, err := b2.HandleRequest(ctx, &logical.Request{Operation: logical.ReadOperation, Path: "roles/export", Storage: storage2, Data: map[string]interface{}{"format": format}})
+		if err != nil || resp2.IsError() {
+			t.Fatalf("err:%s resp:%#v\n", err, resp2)
+		}
+		if resp2.Data["bundle"] != bundle {
+			t.Fatalf("%s: expected imported roles to export the same bundle, got\n%s\nexpected\n%s\n", format, resp2.Data["bundle"], bundle)
+		}
+	}
+}
+
+func TestRoleBundle_Import(t *testing.T) {
+	b, storage := getBackend(t)
+	ctx := context.Background()
+
+	for _, name := range []string{"a", "b"} {
+		resp, err := b.HandleRequest(ctx, &logical.Request{Operation: logical.CreateOperation, Path: "roles/" + name, Storage: storage, Data: map[string]interface{}{"secret_path": "secret/" + name}})
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("err:%s resp:%#v\n", err, resp)
+		}
+	}
+
+	importRoles := func(roles map[string]interface{}, prune bool) *logical.Response {
+		resp, err := b.HandleRequest(ctx, &logical.Request{Operation: logical.UpdateOperation, Path: "roles/import", Storage: storage, Data: map[string]interface{}{"roles": roles, "prune": prune}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	// An invalid role fails the whole import.
+	resp := importRoles(map[string]interface{}{
+		"a": map[string]interface{}{"secret_path": "secret/a", "min_approvers": 3},
+		"c": map[string]interface{}{"secret_path": "secret/c", "min_approvers": 0},
+	}, true)
+	if !resp.IsError() {
+		t.Fatalf("Expected invalid role to fail import, got %#v\n", resp)
+	}
+	if role, _ := b.role(ctx, storage, "a"); role.MinApprovers != 1 {
+		t.Fatalf("Expected failed import not to change roles, got %#v\n", role)
+	}
+
+	desired := map[string]interface{}{
+		"a": map[string]interface{}{"secret_path": "secret/a", "min_approvers": 3},
+		"c": map[string]interface{}{"secret_path": "secret/c"},
+	}
+
+	resp, err := b.HandleRequest(ctx, &logical.Request{Operation: logical.UpdateOperation, Path: "soll-ist", Storage: storage, Data: map[string]interface{}{"roles": desired}})
+	if err != nil || resp.IsError() {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+	differing := map[string]interface{}{"a": map[string]interface{}{"min_approvers": map[string]interface{}{"old": float64(1), "new": float64(3)}}}
+	if !reflect.DeepEqual(resp.Data["missing"], []string{"c"}) || !reflect.DeepEqual(resp.Data["extra"], []string{"b"}) || !reflect.DeepEqual(resp.Data["differing"], differing) || resp.Data["in_sync"] != false {
+		t.Fatalf("Unexpected drift: %#v\n", resp.Data)
+	}
+
+	if resp = importRoles(desired, true); resp.IsError() {
+		t.Fatalf("Expected import, got %#v\n", resp)
+	}
+
+	names, err := b.roleAccessor.list(ctx, storage, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(names)
+	if !reflect.DeepEqual(names, []string{"a", "c"}) {
+		t.Fatalf("Expected roles a and c, got %v\n", names)
+	}
+	if role, _ := b.role(ctx, storage, "a"); role.MinApprovers != 3 {
+		t.Fatalf("Expected imported role, got %#v\n", role)
+	}
+}
+
+func TestRoleBundle_SollIstRead(t *testing.T) {
+	b, storage := getBackend(t)
+	ctx := context.Background()
+
+	roles := map[string]*roleStorageEntry{
+		"a": {SecretPath: "secret/a", MinApprovers: 1, BreakGlass: true, BreakGlassMaxTTL: time.Hour, BreakGlassReviewPeriod: 24 * time.Hour, SecretEnvironment: "prd", NotifySlackChannels: []string{"#ops"}, BoundRequesterRoles: []string{"sre"}},
+		"b": {SecretPath: "secret/b", MinApprovers: 1, BoundRequesterRoles: []string{"dev"}},
+	}
+	for name, role := range roles {
+		if err := b.roleAccessor.put(ctx, storage, role, name); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	read := func(data map[string]interface{}) map[string]interface{} {
+		resp, err := b.HandleRequest(ctx, &logical.Request{Operation: logical.ReadOperation, Path: "soll-ist", Storage: storage, Data: data})
+		if err != nil || resp.IsError() {
+			t.Fatalf("err:%s resp:%#v\n", err, resp)
+		}
+		if _, ok := resp.Data["b"]; ok || len(resp.Data) != 1 {
+			t.Fatalf("Expected role b to be filtered, got %#v\n", resp.Data)
+		}
+		return resp.Data["a"].(map[string]interface{})
+	}
+
+	// All fields are read by default, including zero values.
+	a := read(map[string]interface{}{"bound_requester_role": "sre"})
+	for field, v := range map[string]interface{}{"name": "a", "secret_path": "secret/a", "secret_type": "", "require_mfa": false, "exclusive_lease": false, "min_rejecters": 0} {
+		if actual, ok := a[field]; !ok || !reflect.DeepEqual(actual, v) {
+			t.Fatalf("Expected %s %#v, got %#v\n", field, v, actual)
+		}
+	}
+
+	a = read(map[string]interface{}{"bound_requester_role": "sre", "format": "bundle"})
+	if _, ok := a["require_mfa"]; ok {
+		t.Fatalf("Expected zero values to be omitted from bundle format, got %#v\n", a)
+	}
+	expected := map[string]interface{}{
+		"name":                      "a",
+		"secret_path":               "secret/a",
+		"secret_environment":        "prd",
+		"min_approvers":             float64(1),
+		"break_glass":               true,
+		"break_glass_max_ttl":       float64(3600),
+		"break_glass_review_period": float64(86400),
+		"notify_slack_channels":     []interface{}{"#ops"},
+		"bound_requester_roles":     []interface{}{"sre"},
+	}
+	for field, v := range expected {
+		if !reflect.DeepEqual(a[field], v) {
+			t.Fatalf("Expected %s %#v, got %#v\n", field, v, a[field])
+		}
+	}
+}
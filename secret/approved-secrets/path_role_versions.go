@@ -0,0 +1,602 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/strutil"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/pkg/errors"
+)
+
+const (
+	roleVersionPending    = "pending"
+	roleVersionActive     = "active"
+	roleVersionSuperseded = "superseded"
+	roleVersionRejected   = "rejected"
+	roleVersionWithdrawn  = "withdrawn"
+	roleVersionDeleted    = "deleted"
+)
+
+// roleVersionsStorageEntry holds the version history of a role. If role
+// governance is configured, changes of the role are pending versions until
+// approved by role_governance_min_approvers members of role_governance_groups.
+type roleVersionsStorageEntry struct {
+	Active   int            `json:"active"`
+	Versions []*roleVersion `json:"versions"`
+}
+
+type roleVersion struct {
+	Version     int               `json:"version"`
+	State       string            `json:"state"`
+	Role        *roleStorageEntry `json:"role"`
+	ProposedBy  string            `json:"proposed_by"`
+	ProposedAt  time.Time         `json:"proposed_at"`
+	Approvals   []requestApproval `json:"approvals,omitempty"`
+	Rejection   *requestRejection `json:"rejection,omitempty"`
+	ActivatedAt time.Time         `json:"activated_at,omitempty"`
+}
+
+func pathRoleVersions(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "roles/" + framework.GenericNameRegex("name") + "/versions/?$",
+		Fields: map[string]*framework.FieldSchema{
+			"name": {
+				Type:        framework.TypeString,
+				Description: "Name of the role.",
+				Required:    true,
+			},
+		},
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ReadOperation: b.pathRoleVersionsRead,
+		},
+	}
+}
+
+func pathRoleVersion(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "roles/" + framework.GenericNameRegex("name") + "/versions/(?P<version>[0-9]+)",
+		Fields: map[string]*framework.FieldSchema{
+			"name": {
+				Type:        framework.TypeString,
+				Description: "Name of the role.",
+				Required:    true,
+			},
+			"version": {
+				Type:        framework.TypeInt,
+				Description: "Version of the role.",
+				Required:    true,
+			},
+		},
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ReadOperation: b.pathRoleVersionRead,
+		},
+	}
+}
+
+func pathRoleApprove(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "roles/" + framework.GenericNameRegex("name") + "/approve",
+		Fields: map[string]*framework.FieldSchema{
+			"name": {
+				Type:        framework.TypeString,
+				Description: "Name of the role.",
+				Required:    true,
+			},
+			"version": {
+				Type:        framework.TypeInt,
+				Description: "Pending version of the role to approve.",
+				Required:    true,
+			},
+			"comment": {
+				Type:        framework.TypeString,
+				Description: "Optional comment for the approval.",
+			},
+		},
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.CreateOperation: b.pathRoleApproveUpdate,
+			logical.UpdateOperation: b.pathRoleApproveUpdate,
+		},
+	}
+}
+
+func pathRoleReject(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "roles/" + framework.GenericNameRegex("name") + "/reject",
+		Fields: map[string]*framework.FieldSchema{
+			"name": {
+				Type:        framework.TypeString,
+				Description: "Name of the role.",
+				Required:    true,
+			},
+			"version": {
+				Type:        framework.TypeInt,
+				Description: "Pending version of the role to reject.",
+				Required:    true,
+			},
+			"comment": {
+				Type:        framework.TypeString,
+				Description: "Reason for rejecting the change.",
+				Required:    true,
+			},
+		},
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.CreateOperation: b.pathRoleRejectUpdate,
+			logical.UpdateOperation: b.pathRoleRejectUpdate,
+		},
+	}
+}
+
+func (b *backend) pathRoleVersionsRead(ctx context.Context, r *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+
+	name := d.Get("name").(string)
+	versions, err := b.roleVersions(ctx, r.Storage, name)
+	if err != nil {
+		return nil, err
+	} else if len(versions.Versions) == 0 {
+		return nil, logical.CodedError(http.StatusNotFound, "no role versions found")
+	}
+
+	list := make([]interface{}, 0, len(versions.Versions))
+	for _, v := range versions.Versions {
+		list = append(list, v.data())
+	}
+
+	data := map[string]interface{}{
+		"name":     name,
+		"active":   versions.Active,
+		"versions": list,
+	}
+	if pending := versions.pending(); pending != nil {
+		data["pending"] = pending.Version
+	}
+
+	return &logical.Response{Data: data}, nil
+}
+
+func (b *backend) pathRoleVersionRead(ctx context.Context, r *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+
+	name := d.Get("name").(string)
+	versions, err := b.roleVersions(ctx, r.Storage, name)
+	if err != nil {
+		return nil, err
+	}
+
+	v := versions.version(d.Get("version").(int))
+	if v == nil {
+		return nil, logical.CodedError(http.StatusNotFound, "no role version found")
+	}
+
+	active, err := b.role(ctx, r.Storage, name)
+	if err != nil {
+		return nil, err
+	}
+
+	data := v.data()
+	data["name"] = name
+	data["role"] = roleData(v.Role)
+	data["diff"] = roleDiff(active, v.Role)
+
+	return &logical.Response{Data: data}, nil
+}
+
+func (b *backend) pathRoleApproveUpdate(ctx context.Context, r *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+
+	name := d.Get("name").(string)
+	cfg, err := b.config(ctx, r.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	approverID, approverGroups, err := b.verifyRoleGovernor(r, cfg)
+	if err != nil {
+		return logical.ErrorResponse("failed to validate role_governance_groups: " + err.Error()), nil
+	}
+
+	b.roleMutex.Lock()
+	defer b.roleMutex.Unlock()
+
+	versions, err := b.roleVersions(ctx, r.Storage, name)
+	if err != nil {
+		return nil, err
+	}
+
+	v := versions.pending()
+	if v == nil || v.Version != d.Get("version").(int) {
+		return logical.ErrorResponse(fmt.Sprintf("version %d of role %q is not pending", d.Get("version").(int), name)), nil
+	}
+
+	if approverID == strings.ToLower(v.ProposedBy) {
+		return logical.ErrorResponse("cannot approve your own role change"), nil
+	}
+	for _, approval := range v.Approvals {
+		if approval.ApproverID == approverID {
+			return logical.ErrorResponse(fmt.Sprintf("version %d of role %q is already approved by %q", v.Version, name, approverID)), nil
+		}
+	}
+
+	now := time.Now()
+	v.Approvals = append(v.Approvals, requestApproval{
+		ApproverID: approverID,
+		Comment:    d.Get("comment").(string),
+		Groups:     approverGroups,
+		ApprovedAt: now,
+	})
+
+	var warnings []string
+	if len(v.Approvals) >= cfg.roleGovernanceMinApprovers() {
+		if warnings, err = b.activateRoleVersion(ctx, r.Storage, cfg, name, versions, v, now); err != nil {
+			return nil, err
+		}
+	} else if err = b.roleVersionAccessor.put(ctx, r.Storage, versions, name); err != nil {
+		return nil, err
+	}
+
+	resp := &logical.Response{Data: v.data()}
+	resp.Data["name"] = name
+	for _, warning := range warnings {
+		resp.AddWarning(warning)
+	}
+
+	return resp, nil
+}
+
+func (b *backend) pathRoleRejectUpdate(ctx context.Context, r *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+
+	name := d.Get("name").(string)
+	comment := d.Get("comment").(string)
+	if comment == "" {
+		return logical.ErrorResponse("field 'comment' is mandatory"), nil
+	}
+
+	cfg, err := b.config(ctx, r.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	rejecterID, _, err := b.verifyRoleGovernor(r, cfg)
+	if err != nil {
+		return logical.ErrorResponse("failed to validate role_governance_groups: " + err.Error()), nil
+	}
+
+	b.roleMutex.Lock()
+	defer b.roleMutex.Unlock()
+
+	versions, err := b.roleVersions(ctx, r.Storage, name)
+	if err != nil {
+		return nil, err
+	}
+
+	v := versions.pending()
+	if v == nil || v.Version != d.Get("version").(int) {
+		return logical.ErrorResponse(fmt.Sprintf("version %d of role %q is not pending", d.Get("version").(int), name)), nil
+	}
+
+	v.State = roleVersionRejected
+	v.Rejection = &requestRejection{RejecterID: rejecterID, Comment: comment, RejectedAt: time.Now()}
+	if err = b.roleVersionAccessor.put(ctx, r.Storage, versions, name); err != nil {
+		return nil, err
+	}
+
+	resp := &logical.Response{Data: v.data()}
+	resp.Data["name"] = name
+
+	return resp, nil
+}
+
+// verifyRoleGovernor verifies that the caller is member of the configured
+// role_governance_groups, and returns its identity and groups.
+func (b *backend) verifyRoleGovernor(r *logical.Request, cfg *configStorageEntry) (string, []string, error) {
+
+	if cfg == nil || len(cfg.RoleGovernanceGroups) == 0 {
+		return "", nil, errors.New("role governance is not configured")
+	}
+
+	callerID, err := b.getCallerIdentity(r, cfg.IdentityTemplate)
+	if err != nil {
+		return "", nil, errors.New("failed to get caller's identity: " + err.Error())
+	}
+
+	groups, err := b.verifyCallerGroups(r, nil, cfg.RoleGovernanceGroups)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return strings.ToLower(callerID), groups, nil
+}
+
+// roleChangeActor returns the identity of the caller changing a role, or the
+// display name of its token if it has no identity (like the root token).
+func (b *backend) roleChangeActor(r *logical.Request, cfg *configStorageEntry) string {
+
+	var identityTemplate string
+	if cfg != nil {
+		identityTemplate = cfg.IdentityTemplate
+	}
+	if r.EntityID != "" {
+		if callerID, err := b.getCallerIdentity(r, identityTemplate); err == nil {
+			return strings.ToLower(callerID)
+		}
+	}
+
+	return r.DisplayName
+}
+
+// putRole stores a new version of the role, which is activated at once
+// unless role governance is configured.
+func (b *backend) putRole(ctx context.Context, r *logical.Request, cfg *configStorageEntry, name string, role *roleStorageEntry) (*logical.Response, error) {
+
+	b.roleMutex.Lock()
+	defer b.roleMutex.Unlock()
+
+	active, err := b.role(ctx, r.Storage, name)
+	if err != nil {
+		return nil, err
+	}
+
+	v, warnings, err := b.putRoleLocked(ctx, r, cfg, name, role)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &logical.Response{}
+	for _, warning := range warnings {
+		resp.AddWarning(warning)
+	}
+
+	switch {
+	case v == nil:
+		resp.AddWarning(fmt.Sprintf("no changes to role %q", name))
+	case v.State == roleVersionPending:
+		resp.Data = v.data()
+		resp.Data["name"] = name
+		resp.Data["diff"] = roleDiff(active, role)
+		resp.AddWarning(fmt.Sprintf("version %d of role %q is pending approval by %d member(s) of role_governance_groups", v.Version, name, cfg.roleGovernanceMinApprovers()))
+	case len(warnings) == 0:
+		return nil, nil
+	}
+
+	return resp, nil
+}
+
+// putRoleLocked stores a new version of the role and returns it, or nil if
+// role governance is configured and the role is unchanged. A new version
+// withdraws any pending version. It requires roleMutex to be held.
+func (b *backend) putRoleLocked(ctx context.Context, r *logical.Request, cfg *configStorageEntry, name string, role *roleStorageEntry) (*roleVersion, []string, error) {
+
+	versions, err := b.roleVersions(ctx, r.Storage, name)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	now := time.Now()
+	v := &roleVersion{
+		State:      roleVersionPending,
+		Role:       role,
+		ProposedBy: b.roleChangeActor(r, cfg),
+		ProposedAt: now,
+	}
+
+	if cfg == nil || len(cfg.RoleGovernanceGroups) == 0 {
+		versions.add(v)
+		warnings, err := b.activateRoleVersion(ctx, r.Storage, cfg, name, versions, v, now)
+		if err != nil {
+			return nil, nil, err
+		}
+		return v, warnings, nil
+	}
+
+	active, err := b.role(ctx, r.Storage, name)
+	if err != nil {
+		return nil, nil, err
+	}
+	if active != nil && len(roleDiff(active, role)) == 0 {
+		return nil, nil, nil
+	}
+
+	versions.add(v)
+	if err = b.roleVersionAccessor.put(ctx, r.Storage, versions, name); err != nil {
+		return nil, nil, err
+	}
+
+	return v, nil, nil
+}
+
+// activateRoleVersion stores the version as the role and records it as the
+// active version.
+func (b *backend) activateRoleVersion(ctx context.Context, s logical.Storage, cfg *configStorageEntry, name string, versions *roleVersionsStorageEntry, v *roleVersion, now time.Time) ([]string, error) {
+
+	if err := b.roleAccessor.put(ctx, s, v.Role, name); err != nil {
+		return nil, err
+	}
+
+	if active := versions.version(versions.Active); active != nil && active.State == roleVersionActive {
+		active.State = roleVersionSuperseded
+	}
+	v.State = roleVersionActive
+	v.ActivatedAt = now
+	versions.Active = v.Version
+
+	if err := b.roleVersionAccessor.put(ctx, s, versions, name); err != nil {
+		return nil, errors.Wrapf(err, "failed to record version of role %q", name)
+	}
+
+	// Tokens of vault-token roles are created with the managed token role.
+	var warnings []string
+	if v.Role.SecretType == "vault-token" && cfg != nil && cfg.TokenRoleName != "" {
+		if err := b.reconcileTokenRole(ctx, s, cfg); err != nil {
+			warnings = append(warnings, err.Error())
+		}
+	}
+
+	return warnings, nil
+}
+
+// deleteRole deletes the role and records its active version as deleted.
+func (b *backend) deleteRole(ctx context.Context, s logical.Storage, name string) error {
+
+	b.roleMutex.Lock()
+	defer b.roleMutex.Unlock()
+
+	return b.deleteRoleLocked(ctx, s, name)
+}
+
+// verifyRoleDeletion verifies that the caller may delete roles, which requires
+// membership in role_governance_groups if configured. Deletions take effect at
+// once, so they are not left to a single operator.
+func (b *backend) verifyRoleDeletion(r *logical.Request, cfg *configStorageEntry) error {
+
+	if cfg == nil || len(cfg.RoleGovernanceGroups) == 0 {
+		return nil
+	}
+
+	if _, _, err := b.verifyRoleGovernor(r, cfg); err != nil {
+		return errors.Wrap(err, "deleting roles is restricted to role_governance_groups")
+	}
+
+	return nil
+}
+
+// verifyRoleGovernanceChange verifies that the caller may change the role
+// governance settings from previous to cfg, which requires membership in the
+// previous role_governance_groups if configured. Otherwise, anyone allowed to
+// write the config could bypass the approval of role changes.
+func (b *backend) verifyRoleGovernanceChange(r *logical.Request, previous, cfg *configStorageEntry) error {
+
+	if len(previous.RoleGovernanceGroups) == 0 {
+		return nil
+	}
+	if strutil.EquivalentSlices(previous.RoleGovernanceGroups, cfg.RoleGovernanceGroups) && previous.roleGovernanceMinApprovers() == cfg.roleGovernanceMinApprovers() && previous.IdentityTemplate == cfg.IdentityTemplate {
+		return nil
+	}
+
+	if _, _, err := b.verifyRoleGovernor(r, previous); err != nil {
+		return errors.Wrap(err, "changing role governance is restricted to role_governance_groups")
+	}
+
+	return nil
+}
+
+// deleteRoleLocked is deleteRole, requiring roleMutex to be held.
+func (b *backend) deleteRoleLocked(ctx context.Context, s logical.Storage, name string) error {
+
+	if err := b.roleAccessor.delete(ctx, s, name); err != nil {
+		return err
+	}
+
+	versions, err := b.roleVersions(ctx, s, name)
+	if err != nil {
+		return err
+	}
+
+	active := versions.version(versions.Active)
+	if active == nil {
+		return nil
+	}
+	active.State = roleVersionDeleted
+	versions.Active = 0
+
+	return b.roleVersionAccessor.put(ctx, s, versions, name)
+}
+
+func (b *backend) roleVersions(ctx context.Context, s logical.Storage, name string) (*roleVersionsStorageEntry, error) {
+
+	versions := &roleVersionsStorageEntry{}
+
+	entry, err := b.roleVersionAccessor.get(ctx, s, name)
+	if err != nil {
+		return nil, err
+	} else if entry == nil {
+		return versions, nil // No versions yet.
+	}
+
+	if err := json.Unmarshal(entry.Value, versions); err != nil {
+		return nil, err
+	}
+
+	return versions, nil
+}
+
+// add numbers the version and appends it, withdrawing any pending version.
+func (versions *roleVersionsStorageEntry) add(v *roleVersion) {
+
+	if pending := versions.pending(); pending != nil {
+		pending.State = roleVersionWithdrawn
+	}
+
+	v.Version = 1
+	if n := len(versions.Versions); n > 0 {
+		v.Version = versions.Versions[n-1].Version + 1
+	}
+	versions.Versions = append(versions.Versions, v)
+}
+
+func (versions *roleVersionsStorageEntry) version(n int) *roleVersion {
+	for _, v := range versions.Versions {
+		if v.Version == n {
+			return v
+		}
+	}
+	return nil
+}
+
+func (versions *roleVersionsStorageEntry) pending() *roleVersion {
+	for _, v := range versions.Versions {
+		if v.State == roleVersionPending {
+			return v
+		}
+	}
+	return nil
+}
+
+func (v *roleVersion) data() map[string]interface{} {
+
+	data := map[string]interface{}{
+		"version":     v.Version,
+		"state":       v.State,
+		"proposed_by": v.ProposedBy,
+		"proposed_at": v.ProposedAt,
+		"approvals":   v.Approvals,
+	}
+	if v.Rejection != nil {
+		data["rejection"] = v.Rejection
+	}
+	if !v.ActivatedAt.IsZero() {
+		data["activated_at"] = v.ActivatedAt
+	}
+
+	return data
+}
+
+// roleDiff returns the fields of roles/:name that differ between the roles,
+// as {"old": ..., "new": ...} by field name. A nil role has no fields set.
+func roleDiff(old, new *roleStorageEntry) map[string]interface{} {
+
+	normalize := func(role *roleStorageEntry) map[string]interface{} {
+		if role == nil {
+			role = &roleStorageEntry{}
+		}
+		data := map[string]interface{}{}
+		if raw, err := json.Marshal(roleData(role)); err == nil {
+			_ = json.Unmarshal(raw, &data)
+		}
+		return data
+	}
+
+	oldData, newData := normalize(old), normalize(new)
+
+	diff := map[string]interface{}{}
+	for field, newValue := range newData {
+		if oldValue := oldData[field]; !reflect.DeepEqual(oldValue, newValue) {
+			diff[field] = map[string]interface{}{"old": oldValue, "new": newValue}
+		}
+	}
+
+	return diff
+}
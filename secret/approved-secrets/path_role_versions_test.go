@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestRoleVersions_Direct(t *testing.T) {
+	b, storage := getBackend(t)
+	ctx := context.Background()
+
+	for _, minApprovers := range []string{"1", "2"} {
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "roles/admin",
+			Storage:   storage,
+			Data:      map[string]interface{}{"secret_path": "secret/admin", "min_approvers": minApprovers},
+		})
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("err:%s resp:%#v\n", err, resp)
+		}
+	}
+
+	versions, err := b.roleVersions(ctx, storage, "admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if versions.Active != 2 || len(versions.Versions) != 2 || versions.Versions[0].State != roleVersionSuperseded || versions.Versions[1].State != roleVersionActive {
+		t.Fatalf("Unexpected versions: %#v\n", versions)
+	}
+
+	resp, err := b.HandleRequest(ctx, &logical.Request{Operation: logical.ReadOperation, Path: "roles/admin/versions/1", Storage: storage})
+	if err != nil || resp.IsError() {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+	expected := map[string]interface{}{"min_approvers": map[string]interface{}{"old": float64(2), "new": float64(1)}}
+	if !reflect.DeepEqual(expected, resp.Data["diff"]) {
+		t.Fatalf("Expected diff %v, got %v\n", expected, resp.Data["diff"])
+	}
+}
+
+func TestRoleVersions_Governance(t *testing.T) {
+	b, storage := getBackendWithEntities(t, "http://127.0.0.1:0")
+	ctx := context.Background()
+
+	b.System().(*entitySystemView).GroupsVal = []*logical.Group{{ID: "governance-id", Name: "governance"}}
+
+	cfg, err := b.config(ctx, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.RoleGovernanceGroups = []string{"governance"}
+	if err = b.configAccessor.put(ctx, storage, cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	call := func(path, entityID string, data map[string]interface{}) *logical.Response {
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      path,
+			Storage:   storage,
+			EntityID:  entityID,
+			Data:      data,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	resp := call("roles/admin", "bob", map[string]interface{}{"secret_path": "secret/admin"})
+	if resp == nil || resp.IsError() || resp.Data["state"] != roleVersionPending || resp.Data["version"] != 1 {
+		t.Fatalf("Expected pending version 1, got %#v\n", resp)
+	}
+	if role, err := b.role(ctx, storage, "admin"); err != nil || role != nil {
+		t.Fatalf("Expected pending role not to be active, got %#v (%v)\n", role, err)
+	}
+
+	if resp = call("roles/admin/approve", "bob", map[string]interface{}{"version": 1}); !resp.IsError() {
+		t.Fatalf("Expected proposer not to approve own change, got %#v\n", resp)
+	}
+	if resp = call("roles/admin/approve", "alice", map[string]interface{}{"version": 1}); resp.IsError() || resp.Data["state"] != roleVersionActive {
+		t.Fatalf("Expected version 1 to be activated, got %#v\n", resp)
+	}
+
+	call("roles/admin", "bob", map[string]interface{}{"secret_path": "secret/admin", "min_approvers": 2})
+
+	resp, err = b.HandleRequest(ctx, &logical.Request{Operation: logical.ReadOperation, Path: "roles/admin", Storage: storage})
+	if err != nil || resp.IsError() {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+	expected := map[string]interface{}{"min_approvers": map[string]interface{}{"old": float64(1), "new": float64(2)}}
+	if resp.Data["version"] != 1 || resp.Data["pending_version"] != 2 || resp.Data["min_approvers"] != 1 || !reflect.DeepEqual(expected, resp.Data["pending_diff"]) {
+		t.Fatalf("Unexpected role read: %#v\n", resp.Data)
+	}
+
+	if resp = call("roles/admin/reject", "alice", map[string]interface{}{"version": 2, "comment": "no"}); resp.IsError() || resp.Data["state"] != roleVersionRejected {
+		t.Fatalf("Expected version 2 to be rejected, got %#v\n", resp)
+	}
+
+	role, err := b.role(ctx, storage, "admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if role.MinApprovers != 1 {
+		t.Fatalf("Expected rejected change not to be applied, got %#v\n", role)
+	}
+}
+
+func TestRoleVersions_GovernedDelete(t *testing.T) {
+	b, storage := getBackendWithEntities(t, "http://127.0.0.1:0")
+	ctx := context.Background()
+
+	cfg, err := b.config(ctx, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.RoleGovernanceGroups = []string{"governance"}
+	if err = b.configAccessor.put(ctx, storage, cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"a", "b"} {
+		if err := b.roleAccessor.put(ctx, storage, &roleStorageEntry{SecretPath: "secret/" + name, MinApprovers: 1}, name); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	call := func(op logical.Operation, path string, data map[string]interface{}) *logical.Response {
+		resp, err := b.HandleRequest(ctx, &logical.Request{Operation: op, Path: path, Storage: storage, EntityID: "operator", Data: data})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+	exists := func(name string) bool {
+		role, err := b.role(ctx, storage, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return role != nil
+	}
+
+	// Outside of role_governance_groups, roles are not deleted or pruned.
+	if resp := call(logical.DeleteOperation, "roles/a", nil); resp == nil || !resp.IsError() || !exists("a") {
+		t.Fatalf("Expected error when deleting governed role, got %#v\n", resp)
+	}
+	prune := map[string]interface{}{"roles": map[string]interface{}{"a": map[string]interface{}{"secret_path": "secret/a", "min_approvers": 1}}, "prune": true}
+	if resp := call(logical.UpdateOperation, "roles/import", prune); resp == nil || !resp.IsError() || !exists("b") {
+		t.Fatalf("Expected error when pruning governed role, got %#v\n", resp)
+	}
+
+	b.System().(*entitySystemView).GroupsVal = []*logical.Group{{ID: "governance-id", Name: "governance"}}
+
+	if resp := call(logical.DeleteOperation, "roles/a", nil); (resp != nil && resp.IsError()) || exists("a") {
+		t.Fatalf("Expected governance member to delete role, got %#v\n", resp)
+	}
+	prune["roles"] = map[string]interface{}{}
+	if resp := call(logical.UpdateOperation, "roles/import", prune); resp == nil || resp.IsError() || exists("b") {
+		t.Fatalf("Expected governance member to prune role, got %#v\n", resp)
+	}
+}
+
+func TestRoleVersions_GovernedConfig(t *testing.T) {
+	vault := breakGlassVault()
+	defer vault.Close()
+
+	b, storage := getBackendWithEntities(t, vault.URL)
+	ctx := context.Background()
+
+	cfg, err := b.config(ctx, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.RoleGovernanceGroups = []string{"governance"}
+	cfg.RoleGovernanceMinApprovers = 2
+	if err = b.configAccessor.put(ctx, storage, cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	write := func(data map[string]interface{}) *logical.Response {
+		data["vault_addr"] = vault.URL
+		data["vault_token"] = "s.root"
+		resp, err := b.HandleRequest(ctx, &logical.Request{Operation: logical.UpdateOperation, Path: "config", Storage: storage, EntityID: "operator", Data: data})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+	governance := func() ([]string, int) {
+		cfg, err := b.config(ctx, storage)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cfg.RoleGovernanceGroups, cfg.RoleGovernanceMinApprovers
+	}
+
+	// Outside of role_governance_groups, governance is neither cleared nor
+	// weakened, but other settings may change.
+	for _, data := range []map[string]interface{}{
+		{"role_governance_groups": []string{}},
+		{"role_governance_groups": "operators"},
+		{"role_governance_min_approvers": 1},
+		{"identity_template": "{{identity.entity.name}}"},
+	} {
+		if resp := write(data); resp == nil || !resp.IsError() {
+			t.Fatalf("Expected error when changing governance with %v, got %#v\n", data, resp)
+		}
+		if groups, min := governance(); len(groups) != 1 || min != 2 {
+			t.Fatalf("Expected governance to be kept, got %v %d\n", groups, min)
+		}
+	}
+	if resp := write(map[string]interface{}{"approval_ttl": 7200}); resp != nil && resp.IsError() {
+		t.Fatalf("Expected config to be written, got %#v\n", resp)
+	}
+
+	b.System().(*entitySystemView).GroupsVal = []*logical.Group{{ID: "governance-id", Name: "governance"}}
+
+	if resp := write(map[string]interface{}{"role_governance_groups": []string{}}); resp != nil && resp.IsError() {
+		t.Fatalf("Expected governance member to clear governance, got %#v\n", resp)
+	}
+	if groups, _ := governance(); len(groups) != 0 {
+		t.Fatalf("Expected governance to be cleared, got %v\n", groups)
+	}
+}
@@ -2,101 +2,28 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"net/http"
+	"regexp"
 	"time"
 
 	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/wrapping"
 	"github.com/hashicorp/vault/sdk/logical"
 )
 
 var (
 	errBadSecretPathMethod = logical.ErrorResponse("bad secret_path_method (expected POST or GET)")
 	errBadMinApprovers     = logical.ErrorResponse("bad min_approvers (must be >= 1)")
+	errBadMinRejecters     = logical.ErrorResponse("bad min_rejecters (must be >= 1)")
 	errBadSecretDataMethod = logical.ErrorResponse("bad method for secret_data (must be POST)")
 )
 
 func pathsRole(b *backend) []*framework.Path {
 	return []*framework.Path{
 		{
-			Pattern: "roles/" + framework.GenericNameRegex("name"),
-			Fields: map[string]*framework.FieldSchema{
-				"name": &framework.FieldSchema{
-					Type:        framework.TypeString,
-					Description: `Name of the role.`,
-					Required:    true,
-				},
-				"secret_path": &framework.FieldSchema{
-					Type:        framework.TypeString,
-					Description: `The path of the requested secret.`,
-					Required:    true,
-				},
-				"secret_path_method": &framework.FieldSchema{
-					Type:          framework.TypeString,
-					Default:       http.MethodGet,
-					Description:   `The method of the path of the requested secret.`,
-					AllowedValues: []interface{}{http.MethodGet, http.MethodPost},
-				},
-				"secret_data": &framework.FieldSchema{
-					Type:        framework.TypeMap,
-					Description: `The static input data send to the secret path (requires POST method).`,
-				},
-				"secret_type": &framework.FieldSchema{
-					Type:        framework.TypeString,
-					Description: `Type of secret (for example: kubernetes or ssh).`,
-				},
-				"secret_environment": &framework.FieldSchema{
-					Type:        framework.TypeString,
-					Description: `Environment name`,
-				},
-				"secret_aws_state_role": &framework.FieldSchema{
-					Type:        framework.TypeString,
-					Description: `Setup dedicated terraform state role while getting aws role`,
-				},
-				"secret_required_fields": &framework.FieldSchema{
-					Type:        framework.TypeCommaStringSlice,
-					Description: `Required extra fields when issuing secret.`,
-				},
-				"secret_ttl": &framework.FieldSchema{
-					Type:        framework.TypeDurationSecond,
-					Default:     "8h",
-					Description: `Default duration in seconds send to secret path.`,
-				},
-				"secret_max_ttl": &framework.FieldSchema{
-					Type:        framework.TypeDurationSecond,
-					Default:     "12h",
-					Description: `Max duration in seconds send to secret path.`,
-				},
-				"exclusive_lease": &framework.FieldSchema{
-					Type:        framework.TypeBool,
-					Default:     false,
-					Description: `Ensures secret can only be requested after the TTL of last issue has expired.`,
-				},
-				"min_approvers": &framework.FieldSchema{
-					Type:        framework.TypeInt,
-					Default:     1,
-					Description: `Minimum number of approvers (>=1).`,
-				},
-				"bound_requester_ids": &framework.FieldSchema{
-					Type:        framework.TypeCommaStringSlice,
-					Description: `List of identities from template that are allowed to request. If unset, anyone may approve.`,
-				},
-				"bound_requester_roles": &framework.FieldSchema{
-					Type:        framework.TypeCommaStringSlice,
-					Description: `List of roles from Vault token's metadata that are allowed to request. If unset, any role may approve.`,
-				},
-				"bound_approver_ids": &framework.FieldSchema{
-					Type:        framework.TypeCommaStringSlice,
-					Description: `List of identities from template that are allowed to approve. If unset, anyone may approve.`,
-				},
-				"bound_approver_roles": &framework.FieldSchema{
-					Type:        framework.TypeCommaStringSlice,
-					Description: `List of roles from Vault token's metadata that are allowed to approve. If unset, any role may approve.`,
-				},
-				"notify_slack_channels": &framework.FieldSchema{
-					Type:        framework.TypeStringSlice,
-					Description: `Slack channels to notify.`,
-				},
-			},
+			Pattern:        "roles/" + framework.GenericNameRegex("name"),
+			Fields:         roleFields(),
 			ExistenceCheck: b.pathRoleExistenceCheck,
 			Callbacks: map[logical.Operation]framework.OperationFunc{
 				logical.DeleteOperation: b.pathRoleDelete,
@@ -108,6 +35,161 @@ func pathsRole(b *backend) []*framework.Path {
 	}
 }
 
+// roleFields returns the fields of a role, as written to roles/:name.
+func roleFields() map[string]*framework.FieldSchema {
+	return map[string]*framework.FieldSchema{
+		"name": &framework.FieldSchema{
+			Type:        framework.TypeString,
+			Description: `Name of the role.`,
+			Required:    true,
+		},
+		"secret_path": &framework.FieldSchema{
+			Type:        framework.TypeString,
+			Description: `The path of the requested secret.`,
+			Required:    true,
+		},
+		"secret_path_method": &framework.FieldSchema{
+			Type:          framework.TypeString,
+			Default:       http.MethodGet,
+			Description:   `The method of the path of the requested secret.`,
+			AllowedValues: []interface{}{http.MethodGet, http.MethodPost},
+		},
+		"secret_data": &framework.FieldSchema{
+			Type:        framework.TypeMap,
+			Description: `The static input data send to the secret path (requires POST method).`,
+		},
+		"secret_type": &framework.FieldSchema{
+			Type:        framework.TypeString,
+			Description: `Type of secret (for example: kubernetes or ssh).`,
+		},
+		"secret_environment": &framework.FieldSchema{
+			Type:        framework.TypeString,
+			Description: `Environment name`,
+		},
+		"secret_aws_state_role": &framework.FieldSchema{
+			Type:        framework.TypeString,
+			Description: `Setup dedicated terraform state role while getting aws role`,
+		},
+		"secret_required_fields": &framework.FieldSchema{
+			Type:        framework.TypeCommaStringSlice,
+			Description: `Required extra fields when issuing secret.`,
+		},
+		"parameters": &framework.FieldSchema{
+			Type:        framework.TypeMap,
+			Description: `Schema of the parameters filled at request time and sent to the secret path on issue. Maps a parameter name to its type (string, int, bool or list), required, pattern, allowed_values and default.`,
+		},
+		"steps": &framework.FieldSchema{
+			Type:        framework.TypeSlice,
+			Description: `Ordered steps to issue the secret instead of secret_path. Each step has a name, path, method, data templated from prior step output, parameters and identity, and an output mapping.`,
+		},
+		"output_template": &framework.FieldSchema{
+			Type:        framework.TypeString,
+			Description: `Go text/template rendered with the fields of the issued secret, returned as field 'output'.`,
+		},
+		"wrap_ttl": &framework.FieldSchema{
+			Type:        framework.TypeDurationSecond,
+			Description: `If set, the issued secret is returned response-wrapped with this TTL.`,
+		},
+		"secret_ttl": &framework.FieldSchema{
+			Type:        framework.TypeDurationSecond,
+			Default:     "8h",
+			Description: `Default duration in seconds send to secret path.`,
+		},
+		"secret_max_ttl": &framework.FieldSchema{
+			Type:        framework.TypeDurationSecond,
+			Default:     "12h",
+			Description: `Max duration in seconds send to secret path.`,
+		},
+		"exclusive_lease": &framework.FieldSchema{
+			Type:        framework.TypeBool,
+			Description: `Deprecated, use max_concurrent_leases. If true, sets max_concurrent_leases to 1.`,
+		},
+		"max_concurrent_leases": &framework.FieldSchema{
+			Type:        framework.TypeInt,
+			Description: `Maximum number of unexpired issues of the secret at a time. If 0, unlimited.`,
+		},
+		"break_glass": &framework.FieldSchema{
+			Type:        framework.TypeBool,
+			Default:     false,
+			Description: `Allows requesters to issue the secret without approval in emergencies, subject to post-hoc review.`,
+		},
+		"require_mfa": &framework.FieldSchema{
+			Type:        framework.TypeBool,
+			Default:     false,
+			Description: `Requires approvers and requesters to pass a TOTP code (see mfa/enroll) to approve and issue.`,
+		},
+		"break_glass_max_ttl": &framework.FieldSchema{
+			Type:        framework.TypeDurationSecond,
+			Default:     "1h",
+			Description: `Max duration in seconds of secrets issued by break glass.`,
+		},
+		"break_glass_review_period": &framework.FieldSchema{
+			Type:        framework.TypeDurationSecond,
+			Default:     "72h",
+			Description: `Duration in seconds within which approvers must review a break glass issue.`,
+		},
+		"min_approvers": &framework.FieldSchema{
+			Type:        framework.TypeInt,
+			Default:     1,
+			Description: `Minimum number of approvers (>=1).`,
+		},
+		"approval_rules": &framework.FieldSchema{
+			Type:        framework.TypeStringSlice,
+			Description: `Alternative approval rule sets, of which any must be satisfied. A rule set requires minimums of distinct approvers per role, for example "sre>=1,security>=1".`,
+		},
+		"ticket_pattern": &framework.FieldSchema{
+			Type:        framework.TypeString,
+			Description: `Regular expression that the ticket of requests must match, for example "INC-[0-9]+". If set, requests require a ticket.`,
+		},
+		"ticket_validate": &framework.FieldSchema{
+			Type:        framework.TypeBool,
+			Default:     false,
+			Description: `Validates that the ticket of requests exists, is open and is assigned to the requester with the configured ticket_tracker.`,
+		},
+		"policy": &framework.FieldSchema{
+			Type:        framework.TypeMap,
+			Description: `Policy rules by name, each an expression that must hold to request, approve, issue and break glass, for example {"no approver of the requester's team": "all(approvers, a, !intersects(a.groups, requester.groups))"}.`,
+		},
+		"auto_approve": &framework.FieldSchema{
+			Type:        framework.TypeStringSlice,
+			Description: `Rules of which any approves requests automatically. A rule is a list of conditions that all must hold, for example "group=dev,days=mon-fri,hours=08:00-18:00,tz=Europe/Amsterdam,max_ttl=1h,environment=dta".`,
+		},
+		"min_rejecters": &framework.FieldSchema{
+			Type:        framework.TypeInt,
+			Default:     1,
+			Description: `Number of rejections that veto a request (>=1).`,
+		},
+		"bound_requester_ids": &framework.FieldSchema{
+			Type:        framework.TypeCommaStringSlice,
+			Description: `List of identities from template that are allowed to request. If unset, anyone may approve.`,
+		},
+		"bound_requester_roles": &framework.FieldSchema{
+			Type:        framework.TypeCommaStringSlice,
+			Description: `List of roles from Vault token's metadata that are allowed to request. If unset, any role may approve.`,
+		},
+		"bound_requester_groups": &framework.FieldSchema{
+			Type:        framework.TypeCommaStringSlice,
+			Description: `List of Vault identity group names or IDs that are allowed to request. If unset together with bound_requester_roles, any group may request.`,
+		},
+		"bound_approver_ids": &framework.FieldSchema{
+			Type:        framework.TypeCommaStringSlice,
+			Description: `List of identities from template that are allowed to approve. If unset, anyone may approve.`,
+		},
+		"bound_approver_roles": &framework.FieldSchema{
+			Type:        framework.TypeCommaStringSlice,
+			Description: `List of roles from Vault token's metadata that are allowed to approve. If unset, any role may approve.`,
+		},
+		"bound_approver_groups": &framework.FieldSchema{
+			Type:        framework.TypeCommaStringSlice,
+			Description: `List of Vault identity group names or IDs that are allowed to approve. If unset together with bound_approver_roles, any group may approve.`,
+		},
+		"notify_slack_channels": &framework.FieldSchema{
+			Type:        framework.TypeStringSlice,
+			Description: `Slack channels to notify.`,
+		},
+	}
+}
+
 func pathListRole(b *backend) *framework.Path {
 	return &framework.Path{
 		Pattern: "role/?$",
@@ -155,34 +237,73 @@ func (b *backend) pathRoleRead(ctx context.Context, req *logical.Request, d *fra
 		return nil, logical.CodedError(http.StatusNotFound, "no role found")
 	}
 
-	resp := &logical.Response{
-		Data: map[string]interface{}{
-			"name":                   name,
-			"secret_path":            role.SecretPath,
-			"secret_path_method":     role.SecretPathMethod,
-			"secret_data":            role.SecretData,
-			"secret_type":            role.SecretType,
-			"secret_environment":     role.SecretEnvironment,
-			"secret_aws_state_role":  role.SecretAWSStateRole,
-			"secret_required_fields": role.SecretRequiredFields,
-			"secret_ttl":             role.SecretTTL / time.Second,
-			"secret_max_ttl":         role.SecretMaxTTL / time.Second,
-			"exclusive_lease":        role.ExclusiveLease,
-			"bound_requester_ids":    role.BoundRequesterIDs,
-			"bound_requester_roles":  role.BoundRequesterRoles,
-			"bound_approver_ids":     role.BoundApproverIDs,
-			"bound_approver_roles":   role.BoundApproverRoles,
-			"min_approvers":          role.MinApprovers,
-		},
+	data := roleData(role)
+	data["name"] = name
+
+	versions, err := b.roleVersions(ctx, req.Storage, name)
+	if err != nil {
+		return nil, err
+	}
+	data["version"] = versions.Active
+	if pending := versions.pending(); pending != nil {
+		data["pending_version"] = pending.Version
+		data["pending_diff"] = roleDiff(role, pending.Role)
 	}
 
-	return resp, nil
+	return &logical.Response{Data: data}, nil
+}
+
+// roleData returns the fields of the role as read from roles/:name.
+func roleData(role *roleStorageEntry) map[string]interface{} {
+	return map[string]interface{}{
+		"secret_path":               role.SecretPath,
+		"secret_path_method":        role.SecretPathMethod,
+		"secret_data":               role.SecretData,
+		"secret_type":               role.SecretType,
+		"secret_environment":        role.SecretEnvironment,
+		"secret_aws_state_role":     role.SecretAWSStateRole,
+		"secret_required_fields":    role.SecretRequiredFields,
+		"parameters":                role.Parameters,
+		"steps":                     role.Steps,
+		"output_template":           role.OutputTemplate,
+		"wrap_ttl":                  role.WrapTTL / time.Second,
+		"secret_ttl":                role.SecretTTL / time.Second,
+		"secret_max_ttl":            role.SecretMaxTTL / time.Second,
+		"max_concurrent_leases":     role.MaxConcurrentLeases,
+		"break_glass":               role.BreakGlass,
+		"break_glass_max_ttl":       role.BreakGlassMaxTTL / time.Second,
+		"break_glass_review_period": role.BreakGlassReviewPeriod / time.Second,
+		"require_mfa":               role.RequireMFA,
+		"ticket_pattern":            role.TicketPattern,
+		"ticket_validate":           role.TicketValidate,
+		"bound_requester_ids":       role.BoundRequesterIDs,
+		"bound_requester_roles":     role.BoundRequesterRoles,
+		"bound_approver_ids":        role.BoundApproverIDs,
+		"bound_approver_roles":      role.BoundApproverRoles,
+		"bound_requester_groups":    role.BoundRequesterGroups,
+		"bound_approver_groups":     role.BoundApproverGroups,
+		"min_approvers":             role.MinApprovers,
+		"min_rejecters":             role.MinRejecters,
+		"approval_rules":            approvalRuleSetsString(role.ApprovalRules),
+		"auto_approve":              autoApproveRulesString(role.AutoApprove),
+		"policy":                    role.Policy,
+		"notify_slack_channels":     role.NotifySlackChannels,
+	}
 }
 
 func (b *backend) pathRoleDelete(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
 
+	cfg, err := b.config(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = b.verifyRoleDeletion(req, cfg); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
 	name := d.Get("name").(string)
-	if err := b.roleAccessor.delete(ctx, req.Storage, name); err != nil {
+	if err = b.deleteRole(ctx, req.Storage, name); err != nil {
 		return nil, err
 	}
 
@@ -192,6 +313,10 @@ func (b *backend) pathRoleDelete(ctx context.Context, req *logical.Request, d *f
 func (b *backend) pathRoleCreateUpdate(ctx context.Context, r *logical.Request, d *framework.FieldData) (*logical.Response, error) {
 
 	name := d.Get("name").(string)
+	if containsFold(reservedRoleNames, name) {
+		return logical.ErrorResponse(fmt.Sprintf("role name %q is reserved", name)), nil
+	}
+
 	role, err := b.role(ctx, r.Storage, name)
 	if err != nil {
 		return nil, err
@@ -199,6 +324,32 @@ func (b *backend) pathRoleCreateUpdate(ctx context.Context, r *logical.Request,
 		role = &roleStorageEntry{}
 	}
 
+	resp, err := b.setRoleFields(role, d, r.Operation == logical.CreateOperation)
+	if err != nil || (resp != nil && resp.IsError()) {
+		return resp, err
+	}
+
+	cfg, err := b.config(ctx, r.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	putResp, err := b.putRole(ctx, r, cfg, name, role)
+	if err != nil || resp == nil {
+		return putResp, err
+	}
+	if putResp != nil {
+		resp.Data = putResp.Data
+		resp.Warnings = append(resp.Warnings, putResp.Warnings...)
+	}
+
+	return resp, nil
+}
+
+// setRoleFields sets the fields of the role from the data, with defaults for
+// fields not set on create. It returns an error response if a field is invalid.
+func (b *backend) setRoleFields(role *roleStorageEntry, d *framework.FieldData, create bool) (*logical.Response, error) {
+
 	role.SecretPath = d.Get("secret_path").(string)
 
 	if secretPathMethodRaw, ok := d.GetOk("secret_path_method"); ok {
@@ -239,15 +390,50 @@ func (b *backend) pathRoleCreateUpdate(ctx context.Context, r *logical.Request,
 		role.SecretRequiredFields = secretRequiredFieldsRaw.([]string)
 	}
 
+	if parametersRaw, ok := d.GetOk("parameters"); ok {
+		parameters, err := parseRequestParameters(parametersRaw.(map[string]interface{}))
+		if err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		}
+		role.Parameters = parameters
+	}
+
+	if stepsRaw, ok := d.GetOk("steps"); ok {
+		steps, err := parseIssueSteps(stepsRaw.([]interface{}))
+		if err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		}
+		role.Steps = steps
+	}
+
+	if outputTemplateRaw, ok := d.GetOk("output_template"); ok {
+		role.OutputTemplate = outputTemplateRaw.(string)
+		if _, err := parseOutputTemplate(role.OutputTemplate); err != nil {
+			return logical.ErrorResponse("invalid output_template: " + err.Error()), nil
+		}
+	}
+
+	if wrapTTLRaw, ok := d.GetOk("wrap_ttl"); ok {
+		role.WrapTTL = time.Second * time.Duration(wrapTTLRaw.(int))
+	}
+
+	if role.SecretPath == "" && len(role.Steps) == 0 {
+		return logical.ErrorResponse("either secret_path or steps is required"), nil
+	}
+
+	if role.SecretPathMethod == http.MethodGet && len(role.Parameters) > 0 && len(role.Steps) == 0 {
+		return logical.ErrorResponse("parameters require the POST secret_path_method"), nil
+	}
+
 	if secretTTLRaw, ok := d.GetOk("secret_ttl"); ok {
 		role.SecretTTL = time.Second * time.Duration(secretTTLRaw.(int))
-	} else if r.Operation == logical.CreateOperation {
+	} else if create {
 		role.SecretTTL = time.Second * time.Duration(d.Get("secret_ttl").(int))
 	}
 
 	if secretMaxTTLRaw, ok := d.GetOk("secret_max_ttl"); ok {
 		role.SecretMaxTTL = time.Second * time.Duration(secretMaxTTLRaw.(int))
-	} else if r.Operation == logical.CreateOperation {
+	} else if create {
 		role.SecretMaxTTL = time.Second * time.Duration(d.Get("secret_max_ttl").(int))
 	}
 
@@ -265,7 +451,38 @@ func (b *backend) pathRoleCreateUpdate(ctx context.Context, r *logical.Request,
 		resp.AddWarning("secret_max_ttl is greater than the system or backend mount's maximum TTL value; secrets' max TTL value is truncated")
 	}
 
-	role.ExclusiveLease = d.Get("exclusive_lease").(bool)
+	if maxConcurrentLeasesRaw, ok := d.GetOk("max_concurrent_leases"); ok {
+		role.MaxConcurrentLeases = maxConcurrentLeasesRaw.(int)
+	} else if exclusiveLeaseRaw, ok := d.GetOk("exclusive_lease"); ok {
+		role.MaxConcurrentLeases = 0
+		if exclusiveLeaseRaw.(bool) {
+			role.MaxConcurrentLeases = 1
+		}
+	}
+
+	if role.MaxConcurrentLeases < 0 {
+		return logical.ErrorResponse("max_concurrent_leases must be >= 0"), nil
+	}
+
+	if breakGlassRaw, ok := d.GetOk("break_glass"); ok {
+		role.BreakGlass = breakGlassRaw.(bool)
+	}
+
+	if requireMFARaw, ok := d.GetOk("require_mfa"); ok {
+		role.RequireMFA = requireMFARaw.(bool)
+	}
+
+	if breakGlassMaxTTLRaw, ok := d.GetOk("break_glass_max_ttl"); ok {
+		role.BreakGlassMaxTTL = time.Second * time.Duration(breakGlassMaxTTLRaw.(int))
+	} else if role.BreakGlassMaxTTL == 0 {
+		role.BreakGlassMaxTTL = time.Second * time.Duration(d.GetDefaultOrZero("break_glass_max_ttl").(int))
+	}
+
+	if breakGlassReviewPeriodRaw, ok := d.GetOk("break_glass_review_period"); ok {
+		role.BreakGlassReviewPeriod = time.Second * time.Duration(breakGlassReviewPeriodRaw.(int))
+	} else if role.BreakGlassReviewPeriod == 0 {
+		role.BreakGlassReviewPeriod = time.Second * time.Duration(d.GetDefaultOrZero("break_glass_review_period").(int))
+	}
 
 	if minApproversRaw, ok := d.GetOk("min_approvers"); ok {
 		role.MinApprovers = minApproversRaw.(int)
@@ -277,6 +494,57 @@ func (b *backend) pathRoleCreateUpdate(ctx context.Context, r *logical.Request,
 		return errBadMinApprovers, nil
 	}
 
+	if approvalRulesRaw, ok := d.GetOk("approval_rules"); ok {
+		role.ApprovalRules = nil
+		for _, s := range approvalRulesRaw.([]string) {
+			set, err := parseApprovalRuleSet(s)
+			if err != nil {
+				return logical.ErrorResponse(err.Error()), nil
+			}
+			role.ApprovalRules = append(role.ApprovalRules, set)
+		}
+	}
+
+	if policyRaw, ok := d.GetOk("policy"); ok {
+		policy, err := parseRolePolicy(policyRaw.(map[string]interface{}))
+		if err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		}
+		role.Policy = policy
+	}
+
+	if autoApproveRaw, ok := d.GetOk("auto_approve"); ok {
+		role.AutoApprove = nil
+		for _, s := range autoApproveRaw.([]string) {
+			rule, err := parseAutoApproveRule(s)
+			if err != nil {
+				return logical.ErrorResponse(err.Error()), nil
+			}
+			role.AutoApprove = append(role.AutoApprove, rule)
+		}
+	}
+
+	if ticketPatternRaw, ok := d.GetOk("ticket_pattern"); ok {
+		role.TicketPattern = ticketPatternRaw.(string)
+		if _, err := regexp.Compile(role.TicketPattern); err != nil {
+			return logical.ErrorResponse(fmt.Sprintf("invalid ticket_pattern: %s", err)), nil
+		}
+	}
+
+	if ticketValidateRaw, ok := d.GetOk("ticket_validate"); ok {
+		role.TicketValidate = ticketValidateRaw.(bool)
+	}
+
+	if minRejectersRaw, ok := d.GetOk("min_rejecters"); ok {
+		role.MinRejecters = minRejectersRaw.(int)
+	} else if role.MinRejecters == 0 {
+		role.MinRejecters = d.GetDefaultOrZero("min_rejecters").(int)
+	}
+
+	if role.MinRejecters < 1 {
+		return errBadMinRejecters, nil
+	}
+
 	if boundRequesterIDsRaw, ok := d.GetOk("bound_requester_ids"); ok {
 		role.BoundRequesterIDs = boundRequesterIDsRaw.([]string)
 	}
@@ -285,6 +553,10 @@ func (b *backend) pathRoleCreateUpdate(ctx context.Context, r *logical.Request,
 		role.BoundRequesterRoles = boundRequesterRolesRaw.([]string)
 	}
 
+	if boundRequesterGroupsRaw, ok := d.GetOk("bound_requester_groups"); ok {
+		role.BoundRequesterGroups = boundRequesterGroupsRaw.([]string)
+	}
+
 	if boundApproverIDsRaw, ok := d.GetOk("bound_approver_ids"); ok {
 		role.BoundApproverIDs = boundApproverIDsRaw.([]string)
 	}
@@ -293,28 +565,77 @@ func (b *backend) pathRoleCreateUpdate(ctx context.Context, r *logical.Request,
 		role.BoundApproverRoles = boundApproverRolesRaw.([]string)
 	}
 
+	if boundApproverGroupsRaw, ok := d.GetOk("bound_approver_groups"); ok {
+		role.BoundApproverGroups = boundApproverGroupsRaw.([]string)
+	}
+
 	if notifySlackChannelsRaw, ok := d.GetOk("notify_slack_channels"); ok {
 		role.NotifySlackChannels = notifySlackChannelsRaw.([]string)
 	}
 
-	return resp, b.roleAccessor.put(ctx, r.Storage, role, name)
+	return resp, nil
 }
 
 type roleStorageEntry struct {
-	SecretPath           string                 `json:"secret_path"`
-	SecretPathMethod     string                 `json:"secret_path_method"`
-	SecretData           map[string]interface{} `json:"secret_data"`
-	SecretType           string                 `json:"secret_type"`
-	SecretEnvironment    string                 `json:"secret_environment"`
-	SecretAWSStateRole   string                 `json:"secret_aws_state_role"`
-	SecretRequiredFields []string               `json:"secret_required_fields"`
-	SecretTTL            time.Duration          `json:"secret_ttl"`
-	SecretMaxTTL         time.Duration          `json:"secret_max_ttl"`
-	ExclusiveLease       bool                   `json:"exclusive_lease"`
-	MinApprovers         int                    `json:"min_approvers"`
-	BoundRequesterIDs    []string               `json:"allowed_requester_ids"`
-	BoundRequesterRoles  []string               `json:"allowed_requester_roles"`
-	BoundApproverIDs     []string               `json:"allowed_approver_ids"`
-	BoundApproverRoles   []string               `json:"allowed_approver_roles"`
-	NotifySlackChannels  []string               `json:"notify_slack_channels"`
+	SecretPath             string                       `json:"secret_path"`
+	SecretPathMethod       string                       `json:"secret_path_method"`
+	SecretData             map[string]interface{}       `json:"secret_data"`
+	SecretType             string                       `json:"secret_type"`
+	SecretEnvironment      string                       `json:"secret_environment"`
+	SecretAWSStateRole     string                       `json:"secret_aws_state_role"`
+	SecretRequiredFields   []string                     `json:"secret_required_fields"`
+	Parameters             map[string]*requestParameter `json:"parameters"`
+	Steps                  []*issueStep                 `json:"steps"`
+	OutputTemplate         string                       `json:"output_template"`
+	WrapTTL                time.Duration                `json:"wrap_ttl"`
+	SecretTTL              time.Duration                `json:"secret_ttl"`
+	SecretMaxTTL           time.Duration                `json:"secret_max_ttl"`
+	ExclusiveLease         bool                         `json:"exclusive_lease,omitempty"` // Deprecated, migrated to MaxConcurrentLeases.
+	MaxConcurrentLeases    int                          `json:"max_concurrent_leases"`
+	BreakGlass             bool                         `json:"break_glass"`
+	BreakGlassMaxTTL       time.Duration                `json:"break_glass_max_ttl"`
+	BreakGlassReviewPeriod time.Duration                `json:"break_glass_review_period"`
+	RequireMFA             bool                         `json:"require_mfa"`
+	TicketPattern          string                       `json:"ticket_pattern"`
+	TicketValidate         bool                         `json:"ticket_validate"`
+	MinApprovers           int                          `json:"min_approvers"`
+	MinRejecters           int                          `json:"min_rejecters"`
+	ApprovalRules          []approvalRuleSet            `json:"approval_rules"`
+	AutoApprove            []*autoApproveRule           `json:"auto_approve,omitempty"`
+	Policy                 rolePolicy                   `json:"policy,omitempty"`
+	BoundRequesterIDs      []string                     `json:"allowed_requester_ids"`
+	BoundRequesterRoles    []string                     `json:"allowed_requester_roles"`
+	BoundApproverIDs       []string                     `json:"allowed_approver_ids"`
+	BoundApproverRoles     []string                     `json:"allowed_approver_roles"`
+	BoundRequesterGroups   []string                     `json:"bound_requester_groups"`
+	BoundApproverGroups    []string                     `json:"bound_approver_groups"`
+	NotifySlackChannels    []string                     `json:"notify_slack_channels"`
+}
+
+// wrap marks the issue response to be response-wrapped if the role has a
+// wrap_ttl.
+func (role *roleStorageEntry) wrap(resp *logical.Response) {
+	if role.WrapTTL > 0 {
+		resp.WrapInfo = &wrapping.ResponseWrapInfo{TTL: role.WrapTTL}
+	}
+}
+
+// secretTTL returns the requested TTL capped to the role's max TTL, with a
+// warning if capped.
+func (role *roleStorageEntry) secretTTL(d *framework.FieldData) (time.Duration, string) {
+
+	var ttl time.Duration
+	if rawTTL, ok := d.GetOk("ttl"); ok {
+		ttl = time.Second * time.Duration(rawTTL.(int))
+	} else {
+		ttl = role.SecretTTL
+	}
+
+	var ttlWarning string
+	if ttl > role.SecretMaxTTL {
+		ttlWarning = fmt.Sprintf("Specified ttl is greater than role-secret's max TTL, capped to max TTL: %s", role.SecretMaxTTL)
+		ttl = role.SecretMaxTTL
+	}
+
+	return ttl, ttlWarning
 }
@@ -25,15 +25,18 @@ func TestRole_Read(t *testing.T) {
 	}
 
 	expected := &roleStorageEntry{
-		SecretPath:         "integration/k8s/pki/admin",
-		SecretPathMethod:   "POST",
-		SecretData:         map[string]interface{}{"alt_names": "example.com"},
-		SecretTTL:          time.Hour,
-		SecretMaxTTL:       4 * time.Hour,
-		ExclusiveLease:     false,
-		MinApprovers:       2,
-		BoundApproverIDs:   []string{"gd40qy", "bc12po", "po12lk"},
-		BoundApproverRoles: []string{"sre", "security"},
+		SecretPath:             "integration/k8s/pki/admin",
+		SecretPathMethod:       "POST",
+		SecretData:             map[string]interface{}{"alt_names": "example.com"},
+		SecretTTL:              time.Hour,
+		SecretMaxTTL:           4 * time.Hour,
+		ExclusiveLease:         false,
+		MinApprovers:           2,
+		MinRejecters:           1,
+		BreakGlassMaxTTL:       time.Hour,
+		BreakGlassReviewPeriod: 72 * time.Hour,
+		BoundApproverIDs:       []string{"gd40qy", "bc12po", "po12lk"},
+		BoundApproverRoles:     []string{"sre", "security"},
 	}
 
 	req := &logical.Request{
@@ -119,3 +122,23 @@ func TestRole_Delete(t *testing.T) {
 		t.Fatalf("Unexpected resp data: expected nil got %#v\n", resp.Data)
 	}
 }
+
+func TestRole_ReservedName(t *testing.T) {
+	b, storage := getBackend(t)
+	ctx := context.Background()
+
+	for _, name := range []string{"Export", "IMPORT"} {
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation: logical.CreateOperation,
+			Path:      "roles/" + name,
+			Storage:   storage,
+			Data:      map[string]interface{}{"secret_path": "secret/admin", "min_approvers": 1},
+		})
+		if err != nil || resp == nil || !resp.IsError() {
+			t.Fatalf("%s: expected error for reserved role name, got %#v %v\n", name, resp, err)
+		}
+		if role, err := b.role(ctx, storage, name); err != nil || role != nil {
+			t.Fatalf("%s: expected no role to be stored, got %#v %v\n", name, role, err)
+		}
+	}
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/pkg/errors"
+)
+
+const (
+	slackActionApprove = "approve"
+	slackActionReject  = "reject"
+
+	// slackMaxRequestAge is the maximum age of requests to slack/callback,
+	// to prevent replays.
+	slackMaxRequestAge = 5 * time.Minute
+)
+
+// pathSlackCallback handles the Approve and Reject buttons of Slack
+// messages. It is unauthenticated, requests are verified by their signature.
+func pathSlackCallback(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "slack/callback",
+		Fields: map[string]*framework.FieldSchema{
+			"payload": {
+				Type:        framework.TypeString,
+				Description: "Interaction payload sent by Slack.",
+			},
+		},
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.CreateOperation: b.pathSlackCallback,
+			logical.UpdateOperation: b.pathSlackCallback,
+		},
+	}
+}
+
+type slackInteraction struct {
+	Type string `json:"type"`
+	User struct {
+		ID string `json:"id"`
+	} `json:"user"`
+	Container struct {
+		ChannelID string `json:"channel_id"`
+		MessageTS string `json:"message_ts"`
+	} `json:"container"`
+	ResponseURL string `json:"response_url"`
+	Actions     []struct {
+		ActionID string `json:"action_id"`
+		Value    string `json:"value"`
+	} `json:"actions"`
+}
+
+func (b *backend) pathSlackCallback(ctx context.Context, r *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+
+	cfg, err := b.config(ctx, r.Storage)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get config")
+	} else if cfg == nil || cfg.SlackSigningSecret == "" {
+		return nil, logical.CodedError(http.StatusForbidden, "slack callback is not configured")
+	}
+
+	// Slack signs the form encoded body, which holds the payload only. Vault
+	// passes the parsed form instead of the raw body, so the body is encoded
+	// again. Encoders differ in escaping '~', so both forms are verified.
+	payload := d.Get("payload").(string)
+	timestamp, signature := requestHeader(r, "X-Slack-Request-Timestamp"), requestHeader(r, "X-Slack-Signature")
+	err = verifySlackSignature(cfg.SlackSigningSecret, timestamp, signature, "payload="+slackFormEncode(payload), time.Now())
+	if err != nil && strings.Contains(payload, "~") {
+		err = verifySlackSignature(cfg.SlackSigningSecret, timestamp, signature, "payload="+url.QueryEscape(payload), time.Now())
+	}
+	if err != nil {
+		return nil, logical.CodedError(http.StatusUnauthorized, err.Error())
+	}
+
+	var interaction slackInteraction
+	if err = json.Unmarshal([]byte(payload), &interaction); err != nil {
+		return nil, logical.CodedError(http.StatusBadRequest, "invalid payload")
+	}
+	if interaction.Type != "block_actions" || len(interaction.Actions) != 1 {
+		return slackOK(), nil // Not a button of ours.
+	}
+
+	action := interaction.Actions[0]
+	parts := strings.SplitN(action.Value, "/", 2)
+	if len(parts) != 2 || (action.ActionID != slackActionApprove && action.ActionID != slackActionReject) {
+		return slackOK(), nil
+	}
+	name, nonce := parts[0], parts[1]
+
+	entityID, ok := cfg.SlackUserEntities[interaction.User.ID]
+	if !ok {
+		b.respondSlack(interaction.ResponseURL, fmt.Sprintf("Slack user %s is not mapped to a Vault entity, use vault-helper instead.", interaction.User.ID))
+		return slackOK(), nil
+	}
+
+	// Approve or reject as the mapped entity, so the same rules apply as in Vault.
+	resp, err := b.HandleRequest(ctx, &logical.Request{
+		Operation:  logical.UpdateOperation,
+		Path:       action.ActionID + "/" + name,
+		Storage:    r.Storage,
+		EntityID:   entityID,
+		MountPoint: r.MountPoint,
+		Data: map[string]interface{}{
+			"nonce":   nonce,
+			"comment": "via Slack",
+		},
+	})
+	if err == nil && resp != nil && resp.IsError() {
+		err = resp.Error()
+	}
+	if err != nil {
+		b.respondSlack(interaction.ResponseURL, fmt.Sprintf("Failed to %s request for role %q: %s", action.ActionID, name, err))
+		return slackOK(), nil
+	}
+
+	if err = b.updateSlackMessage(ctx, r.Storage, cfg, interaction.Container.ChannelID, interaction.Container.MessageTS, name, nonce); err != nil {
+		b.Logger().Warn("failed to update Slack message", "role", name, "nonce", nonce, "error", err)
+	}
+
+	return slackOK(), nil
+}
+
+// updateSlackMessage replaces the request message with the request's state.
+// The buttons are kept while the request is pending.
+func (b *backend) updateSlackMessage(ctx context.Context, s logical.Storage, cfg *configStorageEntry, channel, ts, name, nonce string) error {
+
+	if cfg.SlackBotToken == "" || channel == "" || ts == "" {
+		return nil
+	}
+
+	sr, err := b.request(ctx, s, name, nonce)
+	if err != nil {
+		return err
+	} else if sr == nil {
+		return nil // Issued or deleted meanwhile.
+	}
+
+	state := sr.state(time.Now())
+	status := string(state)
+	if len(sr.ApproverIDs) > 0 {
+		status += fmt.Sprintf(", approved by %s", strings.Join(sr.ApproverIDs, ", "))
+	}
+	if len(sr.Rejections) > 0 {
+		var rejecters []string
+		for _, rejection := range sr.Rejections {
+			rejecters = append(rejecters, rejection.RejecterID)
+		}
+		status += fmt.Sprintf(", rejected by %s", strings.Join(rejecters, ", "))
+	}
+
+	e := notificationEvent{Type: eventRequestCreated, Actor: sr.RequesterID, Role: name, Nonce: nonce, Reason: sr.Reason, Ticket: sr.Ticket, TicketURL: sr.TicketURL}
+	payload := e.payload()
+	blocks := requestBlocks(e, status, state == requestPending)
+
+	return newSlackClient(slackAPIURL, cfg.SlackBotToken).updateMessage(channel, ts, payload, blocks)
+}
+
+func (b *backend) respondSlack(responseURL, text string) {
+	if responseURL == "" {
+		return
+	}
+	if err := respond(responseURL, text); err != nil {
+		b.Logger().Warn("failed to respond to Slack", "error", err)
+	}
+}
+
+// verifySlackSignature verifies the signature of a Slack request, see
+// https://api.slack.com/authentication/verifying-requests-from-slack.
+func verifySlackSignature(secret, timestamp, signature, body string, now time.Time) error {
+
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return errors.New("invalid Slack request timestamp")
+	}
+
+	age := now.Sub(time.Unix(ts, 0))
+	if age > slackMaxRequestAge || age < -slackMaxRequestAge {
+		return errors.New("Slack request timestamp is out of range")
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("v0:" + timestamp + ":" + body))
+	expected := "v0=" + hex.EncodeToString(mac.Sum(nil))
+	if !hmac.Equal([]byte(expected), []byte(signature)) {
+		return errors.New("invalid Slack request signature")
+	}
+
+	return nil
+}
+
+// slackFormEncode encodes s like Slack encodes form values: all bytes but
+// ASCII letters, digits, '-', '_' and '.' are percent-encoded, and spaces are
+// encoded as '+'.
+func slackFormEncode(s string) string {
+
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9', c == '-', c == '_', c == '.':
+			sb.WriteByte(c)
+		case c == ' ':
+			sb.WriteByte('+')
+		default:
+			fmt.Fprintf(&sb, "%%%02X", c)
+		}
+	}
+
+	return sb.String()
+}
+
+// requestHeader returns the first value of the header, which Vault must be
+// configured to pass through.
+func requestHeader(r *logical.Request, name string) string {
+	for k, v := range r.Headers {
+		if strings.EqualFold(k, name) && len(v) > 0 {
+			return v[0]
+		}
+	}
+	return ""
+}
+
+// slackOK returns an empty response, which Slack expects within 3 seconds.
+func slackOK() *logical.Response {
+	return &logical.Response{
+		Data: map[string]interface{}{
+			logical.HTTPStatusCode:  http.StatusOK,
+			logical.HTTPContentType: "text/plain",
+			logical.HTTPRawBody:     []byte{},
+		},
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestSlack_VerifySignature(t *testing.T) {
+
+	now := time.Unix(1531420618, 0)
+	body := "token=xyzz0WbapA4vBCDEFasx0q6G&team_id=T1DC2JH3J&team_domain=testteamnow&channel_id=G8PSS9T3V&channel_name=foobar&user_id=U2CERLKJA&user_name=roadrunner&command=%2Fwebhook-collect&text=&response_url=https%3A%2F%2Fhooks.slack.com%2Fcommands%2FT1DC2JH3J%2F397700885554%2F96rGlfmibIGlgcZRskXaIFfN&trigger_id=398738663015.47445629121.803a0bc887a14d10d2c447fce8b6703c"
+	signature := "v0=a2114d57b48eac39b9ad189dd8316235a7b4a8d21a10bd27519666489c69b503"
+
+	if err := verifySlackSignature("8f742231b10e8888abcd99yyyzzz85a5", "1531420618", signature, body, now); err != nil {
+		t.Fatalf("Expected valid signature, got %v\n", err)
+	}
+	if err := verifySlackSignature("wrong", "1531420618", signature, body, now); err == nil {
+		t.Fatalf("Expected invalid signature\n")
+	}
+	if err := verifySlackSignature("8f742231b10e8888abcd99yyyzzz85a5", "1531420618", signature, body, now.Add(time.Hour)); err == nil {
+		t.Fatalf("Expected replayed request to be refused\n")
+	}
+}
+
+func TestSlack_Callback(t *testing.T) {
+	b, storage := getBackendWithEntities(t, "http://127.0.0.1:0")
+	ctx := context.Background()
+
+	var responses []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var msg map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&msg)
+		responses = append(responses, msg["text"].(string))
+	}))
+	defer srv.Close()
+
+	cfg, err := b.config(ctx, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.SlackSigningSecret = "signing-secret"
+	cfg.SlackUserEntities = map[string]string{"U123": "alice"}
+	if err = b.configAccessor.put(ctx, storage, cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = b.roleAccessor.put(ctx, storage, &roleStorageEntry{SecretPath: "secret/admin", MinApprovers: 1}, "admin"); err != nil {
+		t.Fatal(err)
+	}
+	sr := &requestStorageEntry{Nonce: "r1", State: requestPending, RequesterID: "bob", MinApprovers: 1, ExpiresAt: time.Now().Add(time.Hour)}
+	if err = b.putRequest(ctx, storage, sr, "admin", "r1"); err != nil {
+		t.Fatal(err)
+	}
+
+	callback := func(user, secret string) (*logical.Response, error) {
+		payload, _ := json.Marshal(map[string]interface{}{
+			"type":         "block_actions",
+			"user":         map[string]interface{}{"id": user},
+			"response_url": srv.URL,
+			"actions":      []interface{}{map[string]interface{}{"action_id": slackActionApprove, "value": "admin/r1"}},
+		})
+
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write([]byte("v0:" + timestamp + ":payload=" + url.QueryEscape(string(payload))))
+
+		return b.HandleRequest(ctx, &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "slack/callback",
+			Storage:   storage,
+			Headers: map[string][]string{
+				"X-Slack-Request-Timestamp": {timestamp},
+				"X-Slack-Signature":         {"v0=" + hex.EncodeToString(mac.Sum(nil))},
+			},
+			Data: map[string]interface{}{"payload": string(payload)},
+		})
+	}
+
+	if _, err = callback("U123", "forged"); err == nil {
+		t.Fatalf("Expected forged request to be refused\n")
+	}
+
+	if resp, err := callback("U999", "signing-secret"); err != nil || resp.Data[logical.HTTPStatusCode] != http.StatusOK {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+	if len(responses) != 1 {
+		t.Fatalf("Expected unmapped user to be told, got %v\n", responses)
+	}
+
+	if resp, err := callback("U123", "signing-secret"); err != nil || resp.Data[logical.HTTPStatusCode] != http.StatusOK {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	stored, err := b.request(ctx, storage, "admin", "r1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.State != requestApproved || len(stored.ApproverIDs) != 1 || stored.ApproverIDs[0] != "alice" {
+		t.Fatalf("Expected request to be approved by alice, got %#v\n", stored)
+	}
+
+	// The same rules apply as for approvals in Vault.
+	if _, err := callback("U123", "signing-secret"); err != nil {
+		t.Fatal(err)
+	}
+	if len(responses) != 2 {
+		t.Fatalf("Expected second approval to be refused, got %v\n", responses)
+	}
+}
+
+func TestSlack_CallbackEncoding(t *testing.T) {
+	b, storage := getBackendWithEntities(t, "http://127.0.0.1:0")
+	ctx := context.Background()
+
+	cfg, err := b.config(ctx, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.SlackSigningSecret = "signing-secret"
+	cfg.SlackUserEntities = map[string]string{"U123": "alice"}
+	if err = b.configAccessor.put(ctx, storage, cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = b.roleAccessor.put(ctx, storage, &roleStorageEntry{SecretPath: "secret/admin", MinApprovers: 1}, "admin"); err != nil {
+		t.Fatal(err)
+	}
+	sr := &requestStorageEntry{Nonce: "r1", State: requestPending, RequesterID: "bob", MinApprovers: 1, ExpiresAt: time.Now().Add(time.Hour)}
+	if err = b.putRequest(ctx, storage, sr, "admin", "r1"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Body as sent by Slack, with escaped slashes in the JSON and '~', '*',
+	// '(', ')', '!', "'" and spaces in the form encoding.
+	body := "payload=%7B%22type%22%3A%22block_actions%22%2C%22user%22%3A%7B%22id%22%3A%22U123%22%2C%22username%22%3A%22alice%7Eops%22%7D%2C%22container%22%3A%7B%22type%22%3A%22message%22%2C%22channel_id%22%3A%22C1%22%2C%22message_ts%22%3A%221531420618.000100%22%7D%2C%22actions%22%3A%5B%7B%22action_id%22%3A%22approve%22%2C%22block_id%22%3A%22request%22%2C%22value%22%3A%22admin%5C%2Fr1%22%2C%22text%22%3A%7B%22type%22%3A%22plain_text%22%2C%22text%22%3A%22Approve+%28it%27s+%2Aurgent%2A%29%21%22%7D%7D%5D%7D"
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	mac := hmac.New(sha256.New, []byte("signing-secret"))
+	mac.Write([]byte("v0:" + timestamp + ":" + body))
+
+	// Vault passes the parsed form to the plugin.
+	form, err := url.ParseQuery(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "slack/callback",
+		Storage:   storage,
+		Headers: map[string][]string{
+			"X-Slack-Request-Timestamp": {timestamp},
+			"X-Slack-Signature":         {"v0=" + hex.EncodeToString(mac.Sum(nil))},
+		},
+		Data: map[string]interface{}{"payload": form.Get("payload")},
+	})
+	if err != nil || resp.Data[logical.HTTPStatusCode] != http.StatusOK {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	stored, err := b.request(ctx, storage, "admin", "r1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.State != requestApproved || len(stored.ApproverIDs) != 1 || stored.ApproverIDs[0] != "alice" {
+		t.Fatalf("Expected request to be approved by alice, got %#v\n", stored)
+	}
+}
@@ -2,13 +2,17 @@ package main
 
 import (
 	"context"
-	"time"
+	"fmt"
 
 	"github.com/hashicorp/vault/sdk/framework"
 	"github.com/hashicorp/vault/sdk/logical"
 	"github.com/pkg/errors"
 )
 
+// sollIstFormatBundle reads the roles of soll-ist with their fields as
+// exported by roles/export.
+const sollIstFormatBundle = "bundle"
+
 func pathSollIst(b *backend) *framework.Path {
 	return &framework.Path{
 		Pattern: "soll-ist",
@@ -17,58 +21,119 @@ func pathSollIst(b *backend) *framework.Path {
 				Type:        framework.TypeString,
 				Description: `Roles from Vault token's metadata that are allowed to request. If unset, any role may approve.`,
 			},
+			"roles": {
+				Type:        framework.TypeMap,
+				Description: `Desired roles by name, each with the fields of roles/:name, as exported in JSON.`,
+			},
+			"bundle": {
+				Type:        framework.TypeString,
+				Description: `Desired bundle as exported by roles/export, in JSON or HCL. Ignored if roles is set.`,
+			},
+			"format": {
+				Type:        framework.TypeString,
+				Description: `Format of the roles read, either "bundle" for the fields as exported, or unset for all fields.`,
+			},
 		},
 		Callbacks: map[logical.Operation]framework.OperationFunc{
-			logical.ReadOperation: b.pathSollIstRead,
+			logical.ReadOperation:   b.pathSollIstRead,
+			logical.CreateOperation: b.pathSollIstUpdate,
+			logical.UpdateOperation: b.pathSollIstUpdate,
 		},
 	}
 }
 
 func (b *backend) pathSollIstRead(ctx context.Context, r *logical.Request, d *framework.FieldData) (*logical.Response, error) {
 
-	roles, err := b.roleAccessor.list(ctx, r.Storage, "")
+	format := d.Get("format").(string)
+	if format != "" && format != sollIstFormatBundle {
+		return logical.ErrorResponse(fmt.Sprintf("format must be %q or unset", sollIstFormatBundle)), nil
+	}
+
+	// Roles deleted since listed are skipped.
+	roles, err := b.roles(ctx, r.Storage)
 	if err != nil {
 		return nil, err
 	}
 
 	data := make(map[string]interface{})
-	for _, rname := range roles {
-		role, err := b.role(ctx, r.Storage, rname)
-		if err != nil {
-			return nil, errors.Wrapf(err, "failed to read role: %s: ", rname)
+	for name, role := range roles {
+		if !role.hasRequesterRole(d.Get("bound_requester_role").(string)) {
+			continue
 		}
 
-		filter := d.Get("bound_requester_role").(string)
-		include := filter == ""
-		if !include {
-
-			for _, requesterRole := range role.BoundRequesterRoles {
-				if filter == requesterRole {
-					include = true
-					break
-				}
+		var fields map[string]interface{}
+		if format == sollIstFormatBundle {
+			if fields, err = exportRole(role); err != nil {
+				return nil, errors.Wrapf(err, "failed to export role %q", name)
 			}
+		} else {
+			fields = roleData(role)
+			// Kept for readers of earlier versions.
+			fields["exclusive_lease"] = role.MaxConcurrentLeases == 1
 		}
+		fields["name"] = name
+		data[name] = fields
+	}
 
-		if include {
-			data[rname] = map[string]interface{}{
-				"name":                   rname,
-				"secret_path":            role.SecretPath,
-				"secret_path_method":     role.SecretPathMethod,
-				"secret_data":            role.SecretData,
-				"secret_type":            role.SecretType,
-				"secret_required_fields": role.SecretRequiredFields,
-				"secret_ttl":             role.SecretTTL / time.Second,
-				"secret_max_ttl":         role.SecretMaxTTL / time.Second,
-				"exclusive_lease":        role.ExclusiveLease,
-				"bound_requester_ids":    role.BoundRequesterIDs,
-				"bound_requester_roles":  role.BoundRequesterRoles,
-				"bound_approver_ids":     role.BoundApproverIDs,
-				"bound_approver_roles":   role.BoundApproverRoles,
-				"min_approvers":          role.MinApprovers,
-			}
+	return &logical.Response{Data: data}, nil
+}
+
+// pathSollIstUpdate compares the desired roles with the actual roles and
+// returns the missing, extra and differing roles.
+func (b *backend) pathSollIstUpdate(ctx context.Context, r *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+
+	raw, err := roleBundleData(d)
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	desired, err := b.bundleRoles(raw)
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	actual, err := b.roles(ctx, r.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	filter := d.Get("bound_requester_role").(string)
+	for name, role := range desired {
+		if current, ok := actual[name]; !role.hasRequesterRole(filter) && (!ok || !current.hasRequesterRole(filter)) {
+			delete(desired, name)
+		}
+	}
+	for name, role := range actual {
+		if _, ok := desired[name]; !ok && !role.hasRequesterRole(filter) {
+			delete(actual, name)
 		}
 	}
 
-	return &logical.Response{Data: data}, nil
+	drift := compareRoles(desired, actual)
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"missing":   drift.Missing,
+			"extra":     drift.Extra,
+			"differing": drift.Differing,
+			"in_sync":   drift.inSync(),
+		},
+	}, nil
+}
+
+// hasRequesterRole returns whether the role's bound_requester_roles contain
+// requesterRole, or true if requesterRole is empty.
+func (role *roleStorageEntry) hasRequesterRole(requesterRole string) bool {
+
+	if requesterRole == "" {
+		return true
+	}
+
+	for _, r := range role.BoundRequesterRoles {
+		if r == requesterRole {
+			return true
+		}
+	}
+
+	return false
 }
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/pkg/errors"
+)
+
+// tidyInterval is the minimum interval between tidy runs of the periodic func.
+const tidyInterval = time.Hour
+
+func pathTidyHistory(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "tidy/history",
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.UpdateOperation: b.pathTidyHistoryUpdate,
+		},
+	}
+}
+
+func (b *backend) pathTidyHistoryUpdate(ctx context.Context, r *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+
+	cfg, err := b.config(ctx, r.Storage)
+	if err != nil {
+		return nil, err
+	} else if cfg == nil {
+		return logical.ErrorResponse("could not find config"), nil
+	}
+
+	deleted, err := b.tidyHistory(ctx, r.Storage, cfg.HistoryRetention)
+	if err != nil {
+		return nil, err
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"deleted": deleted,
+		},
+	}, nil
+}
+
+// tidyHistory deletes completed history entries of which the last event is
+// older than retention. A retention of zero keeps history forever.
+func (b *backend) tidyHistory(ctx context.Context, s logical.Storage, retention time.Duration) (int, error) {
+
+	if retention <= 0 {
+		return 0, nil
+	}
+
+	roles, err := b.historyAccessor.list(ctx, s, "")
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to list history")
+	}
+
+	deleted := 0
+	cutoff := time.Now().Add(-retention)
+	for _, role := range roles {
+		role = strings.TrimSuffix(role, "/")
+		nonces, err := b.historyAccessor.list(ctx, s, role)
+		if err != nil {
+			return deleted, errors.Wrapf(err, "failed to list history of role %q", role)
+		}
+
+		for _, nonce := range nonces {
+			entry, err := b.history(ctx, s, role, nonce)
+			if err != nil {
+				return deleted, err
+			} else if entry == nil || !entry.completed() || entry.lastEventAt().After(cutoff) {
+				continue
+			}
+
+			if err = b.historyAccessor.delete(ctx, s, role, nonce); err != nil {
+				return deleted, errors.Wrapf(err, "failed to delete history of role %q with nonce %q", role, nonce)
+			}
+			deleted++
+		}
+	}
+
+	return deleted, nil
+}
+
+// periodicHousekeeping runs the tidy operations and review checks at most
+// once per tidyInterval.
+func (b *backend) periodicHousekeeping(ctx context.Context, r *logical.Request) error {
+
+	b.tidyMutex.Lock()
+	defer b.tidyMutex.Unlock()
+
+	if time.Since(b.lastTidy) < tidyInterval {
+		return nil
+	}
+
+	cfg, err := b.config(ctx, r.Storage)
+	if err != nil || cfg == nil {
+		return err
+	}
+
+	b.lastTidy = time.Now()
+	if _, err = b.tidyHistory(ctx, r.Storage, cfg.HistoryRetention); err != nil {
+		b.Logger().Warn("failed to tidy history", "error", err)
+	}
+
+	if cfg.TokenRoleName != "" {
+		if err = b.reconcileTokenRole(ctx, r.Storage, cfg); err != nil {
+			b.Logger().Warn("failed to reconcile token role", "role", cfg.TokenRoleName, "error", err)
+		}
+	}
+
+	if err = b.cleanupLegacyTokenRoles(ctx, r.Storage, cfg); err != nil {
+		b.Logger().Warn("failed to clean up legacy token roles", "error", err)
+	}
+
+	if err = b.expireLeases(ctx, r.Storage); err != nil {
+		b.Logger().Warn("failed to expire leases", "error", err)
+	}
+
+	if err = b.checkOverdueReviews(ctx, r.Storage); err != nil {
+		b.Logger().Warn("failed to check overdue reviews", "error", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/pkg/errors"
+)
+
+const (
+	// vaultTokenIncrement is the TTL requested when creating or renewing the
+	// plugin's Vault token.
+	vaultTokenIncrement = 72 * time.Hour
+
+	// vaultTokenExpiryWarning is the remaining TTL of the plugin's Vault token
+	// below which warnings are logged and the token is re-minted from AppRole.
+	vaultTokenExpiryWarning = 24 * time.Hour
+)
+
+func pathConfigHealth(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "config/health",
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ReadOperation: b.pathConfigHealthRead,
+		},
+	}
+}
+
+func pathRotateToken(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "rotate-token",
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.CreateOperation: b.pathRotateTokenUpdate,
+			logical.UpdateOperation: b.pathRotateTokenUpdate,
+		},
+	}
+}
+
+func (b *backend) pathConfigHealthRead(ctx context.Context, r *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+
+	cfg, err := b.config(ctx, r.Storage)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get config")
+	} else if cfg == nil {
+		return nil, logical.CodedError(http.StatusNotFound, "no config found")
+	}
+
+	health, err := b.tokenHealth(ctx, r.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &logical.Response{Data: health.data()}
+	for _, warning := range health.warnings() {
+		resp.AddWarning(warning)
+	}
+
+	return resp, nil
+}
+
+func (b *backend) pathRotateTokenUpdate(ctx context.Context, r *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+
+	b.tokenMutex.Lock()
+	defer b.tokenMutex.Unlock()
+
+	cfg, err := b.config(ctx, r.Storage)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get config")
+	} else if cfg == nil {
+		return nil, logical.CodedError(http.StatusNotFound, "no config found")
+	}
+
+	resp := &logical.Response{}
+	if err = b.rotateVaultToken(ctx, r.Storage, cfg, resp); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	health, err := b.tokenHealth(ctx, r.Storage)
+	if err != nil {
+		return nil, err
+	}
+	resp.Data = health.data()
+
+	return resp, nil
+}
+
+// mintVaultToken creates a new token for the plugin, by AppRole login if
+// configured, or else as orphan of token.
+func mintVaultToken(ctx context.Context, cfg *configStorageEntry, token string) (*api.Secret, error) {
+
+	clt, err := newVaultClient(ctx, cfg.VaultAddr, token)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create Vault client")
+	}
+
+	var secret *api.Secret
+	if cfg.AppRoleRoleID != "" {
+		clt.ClearToken()
+		secret, err = clt.Logical().Write(path.Join("auth", cfg.AppRoleMount, "login"), map[string]interface{}{
+			"role_id":   cfg.AppRoleRoleID,
+			"secret_id": cfg.AppRoleSecretID,
+		})
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to login with AppRole")
+		}
+	} else {
+		secret, err = clt.Logical().Write("/auth/token/create-orphan", map[string]interface{}{
+			"policies":     cfg.VaultPolicies,
+			"ttl":          fmt.Sprintf("%.0fh", vaultTokenIncrement.Hours()),
+			"renewable":    true,
+			"display_name": "approved-secrets-plugin",
+			"meta":         map[string]interface{}{"created_by": "approved-secrets-plugin"},
+		})
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to create orphaned Vault token")
+		}
+	}
+
+	if secret == nil || secret.Auth == nil {
+		return nil, errors.New("no token returned")
+	}
+
+	return secret, nil
+}
+
+// rotateVaultToken replaces the plugin's token by a newly minted token and
+// revokes the previous one. It must be called with tokenMutex held.
+func (b *backend) rotateVaultToken(ctx context.Context, s logical.Storage, cfg *configStorageEntry, resp *logical.Response) error {
+
+	previous := cfg.VaultToken
+	secret, err := mintVaultToken(ctx, cfg, previous)
+	if err != nil {
+		return err
+	}
+
+	cfg.VaultToken = secret.Auth.ClientToken
+	if err = b.configAccessor.put(ctx, s, cfg); err != nil {
+		return errors.Wrap(err, "failed to store config")
+	}
+
+	now := time.Now()
+	health := &tokenHealthStorageEntry{
+		LastRenewal:  now,
+		ExpiresAt:    now.Add(time.Duration(secret.Auth.LeaseDuration) * time.Second),
+		LastRotation: now,
+	}
+	if err = b.tokenHealthAccessor.put(ctx, s, health); err != nil {
+		return errors.Wrap(err, "failed to store token health")
+	}
+
+	if previous != "" && previous != cfg.VaultToken {
+		if err = revokeVaultToken(ctx, cfg.VaultAddr, previous); err != nil {
+			b.Logger().Warn("failed to revoke previous Vault token", "error", err)
+			if resp != nil {
+				resp.AddWarning(err.Error())
+			}
+		}
+	}
+
+	return nil
+}
+
+func revokeVaultToken(ctx context.Context, vaultAddr, token string) error {
+
+	clt, err := newVaultClient(ctx, vaultAddr, token)
+	if err != nil {
+		return errors.Wrap(err, "failed to create Vault client")
+	}
+
+	if _, err = clt.Logical().Write("/auth/token/revoke-self", nil); err != nil {
+		return errors.Wrap(err, "failed to revoke previous Vault token")
+	}
+
+	return nil
+}
+
+// renewVaultToken renews the plugin's token and records its health. The
+// token is re-minted from AppRole if renewal fails or it expires soon.
+func (b *backend) renewVaultToken(ctx context.Context, s logical.Storage, cfg *configStorageEntry) error {
+
+	b.tokenMutex.Lock()
+	defer b.tokenMutex.Unlock()
+
+	health, err := b.tokenHealth(ctx, s)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	secret, err := renewSelf(ctx, cfg)
+	if err == nil {
+		health.LastRenewal = now
+		health.ExpiresAt = now.Add(time.Duration(secret.Auth.LeaseDuration) * time.Second)
+		health.LastError = ""
+	} else {
+		health.LastError = err.Error()
+		health.LastErrorAt = now
+	}
+
+	if health.LastError != "" || health.expiresSoon(now) {
+		if cfg.AppRoleRoleID != "" {
+			err := b.rotateVaultToken(ctx, s, cfg, nil)
+			if err == nil {
+				b.Logger().Info("re-minted Vault token from AppRole")
+				return nil
+			}
+			health.LastError = fmt.Sprintf("failed to re-mint Vault token from AppRole: %s", err)
+			health.LastErrorAt = now
+		}
+
+		for _, warning := range health.warnings() {
+			b.Logger().Warn(warning)
+		}
+	}
+
+	return b.tokenHealthAccessor.put(ctx, s, health)
+}
+
+func renewSelf(ctx context.Context, cfg *configStorageEntry) (*api.Secret, error) {
+
+	clt, err := newVaultClient(ctx, cfg.VaultAddr, cfg.VaultToken)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create Vault client")
+	}
+
+	secret, err := clt.Logical().Write("/auth/token/renew-self", map[string]interface{}{
+		"increment": fmt.Sprintf("%.0fh", vaultTokenIncrement.Hours()),
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to renew Vault token")
+	} else if secret == nil || secret.Auth == nil {
+		return nil, errors.New("failed to renew Vault token: no auth returned")
+	}
+
+	return secret, nil
+}
+
+func (b *backend) tokenHealth(ctx context.Context, s logical.Storage) (*tokenHealthStorageEntry, error) {
+
+	entry, err := b.tokenHealthAccessor.get(ctx, s)
+	if err != nil {
+		return nil, err
+	}
+
+	health := &tokenHealthStorageEntry{}
+	if entry == nil {
+		return health, nil
+	}
+
+	if err := json.Unmarshal(entry.Value, health); err != nil {
+		return nil, err
+	}
+
+	return health, nil
+}
+
+type tokenHealthStorageEntry struct {
+	LastRenewal  time.Time `json:"last_renewal"`
+	ExpiresAt    time.Time `json:"expires_at"`
+	LastError    string    `json:"last_error"`
+	LastErrorAt  time.Time `json:"last_error_at"`
+	LastRotation time.Time `json:"last_rotation"`
+}
+
+func (h *tokenHealthStorageEntry) expiresSoon(now time.Time) bool {
+	return !h.ExpiresAt.IsZero() && h.ExpiresAt.Sub(now) < vaultTokenExpiryWarning
+}
+
+func (h *tokenHealthStorageEntry) healthy() bool {
+	return h.LastError == "" && !h.expiresSoon(time.Now())
+}
+
+func (h *tokenHealthStorageEntry) warnings() []string {
+
+	var warnings []string
+	if h.LastError != "" {
+		warnings = append(warnings, fmt.Sprintf("plugin's Vault token is unhealthy: %s", h.LastError))
+	}
+
+	if h.ExpiresAt.IsZero() {
+		warnings = append(warnings, "plugin's Vault token expiry is unknown, it is not renewed yet")
+	} else if h.expiresSoon(time.Now()) {
+		warnings = append(warnings, fmt.Sprintf("plugin's Vault token expires at %s, rotate-token or reconfigure", h.ExpiresAt.Format(time.RFC3339)))
+	}
+
+	return warnings
+}
+
+func (h *tokenHealthStorageEntry) data() map[string]interface{} {
+	return map[string]interface{}{
+		"healthy":       h.healthy(),
+		"last_renewal":  h.LastRenewal,
+		"expires_at":    h.ExpiresAt,
+		"last_error":    h.LastError,
+		"last_error_at": h.LastErrorAt,
+		"last_rotation": h.LastRotation,
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// fakeTokenAuth serves the token renewal, revocation and AppRole login
+// endpoints of Vault.
+type fakeTokenAuth struct {
+	sync.Mutex
+	renewable map[string]bool
+	revoked   []string
+	logins    int
+}
+
+func (f *fakeTokenAuth) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.Lock()
+	defer f.Unlock()
+
+	token := r.Header.Get("X-Vault-Token")
+	switch r.URL.Path {
+	case "/v1/auth/token/renew-self":
+		if !f.renewable[token] {
+			w.WriteHeader(http.StatusForbidden)
+			json.NewEncoder(w).Encode(map[string]interface{}{"errors": []string{"permission denied"}})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"auth": map[string]interface{}{"client_token": token, "lease_duration": 259200}})
+	case "/v1/auth/approle/login":
+		f.logins++
+		json.NewEncoder(w).Encode(map[string]interface{}{"auth": map[string]interface{}{"client_token": "s.approle", "lease_duration": 259200}})
+	case "/v1/auth/token/revoke-self":
+		f.revoked = append(f.revoked, token)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestToken_RenewFailure(t *testing.T) {
+	b, storage := getBackend(t)
+	ctx := context.Background()
+
+	fake := &fakeTokenAuth{renewable: map[string]bool{"s.approle": true}}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	cfg := &configStorageEntry{VaultAddr: srv.URL, VaultToken: "s.expired"}
+	if err := b.configAccessor.put(ctx, storage, cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := b.renewVaultToken(ctx, storage, cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	health, err := b.tokenHealth(ctx, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if health.LastError == "" || health.healthy() {
+		t.Fatalf("Expected failed renewal to be recorded, got %#v\n", health)
+	}
+
+	resp, err := b.HandleRequest(ctx, &logical.Request{Operation: logical.ReadOperation, Path: "config/health", Storage: storage})
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+	if resp.Data["healthy"] != false || len(resp.Warnings) == 0 {
+		t.Fatalf("Expected unhealthy token with warnings, got %#v\n", resp)
+	}
+
+	// With AppRole configured, the token is re-minted and the previous one revoked.
+	cfg.AppRoleMount, cfg.AppRoleRoleID, cfg.AppRoleSecretID = "approle", "role-id", "secret-id"
+	if err = b.renewVaultToken(ctx, storage, cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	stored, err := b.config(ctx, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.VaultToken != "s.approle" || fake.logins != 1 {
+		t.Fatalf("Expected token to be re-minted from AppRole, got %q after %d login(s)\n", stored.VaultToken, fake.logins)
+	}
+	if len(fake.revoked) != 1 || fake.revoked[0] != "s.expired" {
+		t.Fatalf("Expected previous token to be revoked, got %v\n", fake.revoked)
+	}
+
+	health, err = b.tokenHealth(ctx, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !health.healthy() || health.LastRotation.IsZero() {
+		t.Fatalf("Expected healthy rotated token, got %#v\n", health)
+	}
+
+	// Renewal of the re-minted token succeeds without another login.
+	if err = b.renewVaultToken(ctx, storage, stored); err != nil {
+		t.Fatal(err)
+	}
+	if fake.logins != 1 {
+		t.Fatalf("Expected no additional AppRole login, got %d\n", fake.logins)
+	}
+}
+
+func TestToken_Rotate(t *testing.T) {
+	b, storage := getBackend(t)
+	ctx := context.Background()
+
+	fake := &fakeTokenAuth{renewable: map[string]bool{}}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	cfg := &configStorageEntry{VaultAddr: srv.URL, VaultToken: "s.previous", AppRoleMount: "approle", AppRoleRoleID: "role-id", AppRoleSecretID: "secret-id"}
+	if err := b.configAccessor.put(ctx, storage, cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := b.HandleRequest(ctx, &logical.Request{Operation: logical.UpdateOperation, Path: "rotate-token", Storage: storage})
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+	if resp.Data["healthy"] != true {
+		t.Fatalf("Expected healthy token, got %#v\n", resp.Data)
+	}
+
+	if len(fake.revoked) != 1 || fake.revoked[0] != "s.previous" {
+		t.Fatalf("Expected previous token to be revoked, got %v\n", fake.revoked)
+	}
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/pkg/errors"
+)
+
+var (
+	stepNameRegex = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+
+	// stepReferenceRegex matches references to prior step output, request
+	// parameters and the secret's TTL, for example {{steps.cert.certificate}}.
+	stepReferenceRegex = regexp.MustCompile(`{{\s*((?:steps|parameters)\.[^{}\s]+|ttl)\s*}}`)
+)
+
+// issueStep is a call to Vault in a role's issuance pipeline.
+type issueStep struct {
+	Name   string                 `json:"name"`
+	Path   string                 `json:"path"`
+	Method string                 `json:"method,omitempty"`
+	Data   map[string]interface{} `json:"data,omitempty"`
+	Output map[string]string      `json:"output,omitempty"`
+
+	// legacy marks the implicit step of roles without steps, which passes
+	// the request parameters and ttl to secret_path.
+	legacy    bool
+	tokenType bool
+}
+
+// parseIssueSteps parses a role's steps and verifies that templates only
+// refer to prior steps.
+func parseIssueSteps(raw []interface{}) ([]*issueStep, error) {
+
+	var steps []*issueStep
+	names := map[string]bool{}
+	for i, stepRaw := range raw {
+		bs, err := json.Marshal(stepRaw)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid step %d", i)
+		}
+
+		dec := json.NewDecoder(bytes.NewReader(bs))
+		dec.DisallowUnknownFields()
+		step := &issueStep{}
+		if err = dec.Decode(step); err != nil {
+			return nil, errors.Wrapf(err, "invalid step %d", i)
+		}
+
+		if !stepNameRegex.MatchString(step.Name) {
+			return nil, errors.Errorf("invalid name %q of step %d", step.Name, i)
+		} else if names[step.Name] {
+			return nil, errors.Errorf("duplicate step %q", step.Name)
+		}
+
+		if step.Path == "" {
+			return nil, errors.Errorf("missing path of step %q", step.Name)
+		}
+
+		step.Method = strings.ToUpper(step.Method)
+		if step.Method == "" {
+			step.Method = http.MethodPost
+		}
+		if step.Method != http.MethodPost && step.Method != http.MethodGet {
+			return nil, errors.Errorf("invalid method %q of step %q (expected GET or POST)", step.Method, step.Name)
+		}
+		if step.Method == http.MethodGet && len(step.Data) > 0 {
+			return nil, errors.Errorf("data of step %q requires the POST method", step.Name)
+		}
+
+		for _, ref := range stepReferenceRegex.FindAllStringSubmatch(string(bs), -1) {
+			parts := strings.SplitN(ref[1], ".", 3)
+			if parts[0] == "steps" && (len(parts) < 3 || !names[parts[1]]) {
+				return nil, errors.Errorf("step %q refers to %q, which is not a prior step's output", step.Name, ref[1])
+			}
+		}
+
+		names[step.Name] = true
+		steps = append(steps, step)
+	}
+
+	return steps, nil
+}
+
+// pipeline returns the steps to issue the role's secret. Roles without steps
+// call secret_path only, as before steps existed; secret_aws_state_role is
+// not used for them, so existing roles are not changed by it.
+func (role *roleStorageEntry) pipeline() []*issueStep {
+
+	if len(role.Steps) > 0 {
+		return role.Steps
+	}
+
+	return []*issueStep{{
+		Name:      "secret",
+		Path:      role.SecretPath,
+		Method:    strings.ToUpper(role.SecretPathMethod),
+		Data:      role.SecretData,
+		legacy:    true,
+		tokenType: role.SecretType == "vault-token",
+	}}
+}
+
+// stepContext holds what step data can refer to.
+type stepContext struct {
+	r         *logical.Request
+	tokenRole string
+	system    logical.SystemView
+	params    map[string]interface{}
+	outputs   map[string]map[string]interface{}
+	ttl       time.Duration
+}
+
+func (c *stepContext) lookup(ref string) (interface{}, error) {
+
+	if ref == "ttl" {
+		return int64(c.ttl / time.Second), nil
+	}
+
+	parts := strings.SplitN(ref, ".", 3)
+	switch {
+	case parts[0] == "parameters" && len(parts) >= 2:
+		if v, ok := lookupPath(c.params, strings.Join(parts[1:], ".")); ok {
+			return v, nil
+		}
+	case parts[0] == "steps" && len(parts) == 3:
+		if v, ok := lookupPath(c.outputs[parts[1]], parts[2]); ok {
+			return v, nil
+		}
+	}
+
+	return nil, errors.Errorf("could not resolve %q", ref)
+}
+
+// render resolves the references and identity templates in value.
+func (c *stepContext) render(value interface{}) (interface{}, error) {
+
+	switch casted := value.(type) {
+	case string:
+		// A value that is a single reference keeps the type of the referred value.
+		if m := stepReferenceRegex.FindStringSubmatch(casted); m != nil && m[0] == casted {
+			return c.lookup(m[1])
+		}
+
+		var err error
+		rendered := stepReferenceRegex.ReplaceAllStringFunc(casted, func(s string) string {
+			v, lookupErr := c.lookup(stepReferenceRegex.FindStringSubmatch(s)[1])
+			if lookupErr != nil {
+				err = lookupErr
+				return s
+			}
+			return fmt.Sprint(v)
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		if strings.Contains(rendered, "{{identity.") {
+			if c.r.EntityID == "" {
+				return nil, errors.New("identity template requires an entity")
+			}
+			return framework.PopulateIdentityTemplate(rendered, c.r.EntityID, c.system)
+		}
+		return rendered, nil
+	case map[string]interface{}:
+		data := map[string]interface{}{}
+		for k, v := range casted {
+			rendered, err := c.render(v)
+			if err != nil {
+				return nil, errors.Wrapf(err, "failed to render %q", k)
+			}
+			data[k] = rendered
+		}
+		return data, nil
+	case []interface{}:
+		var list []interface{}
+		for _, v := range casted {
+			rendered, err := c.render(v)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, rendered)
+		}
+		return list, nil
+	default:
+		return value, nil
+	}
+}
+
+// runStep calls the step with clt and returns its output data.
+func (b *backend) runStep(clt *api.Client, step *issueStep, c *stepContext, issuerID string) (map[string]interface{}, *api.Secret, error) {
+
+	var secret *api.Secret
+	var err error
+	if step.Method == http.MethodPost {
+		data := make(map[string]interface{})
+		if step.legacy {
+			for k, v := range c.params {
+				data[k] = v
+			}
+			data["ttl"] = c.ttl / time.Second
+			for k, v := range b.applyIdentityTemplateToSecretData(c.r, step.Data) {
+				data[k] = v
+			}
+		} else {
+			rendered, err := c.render(step.Data)
+			if err != nil {
+				return nil, nil, err
+			}
+			for k, v := range rendered.(map[string]interface{}) {
+				data[k] = v
+			}
+		}
+
+		if step.tokenType {
+			secret, err = createClientToken(clt, c.tokenRole, data, issuerID)
+		} else {
+			secret, err = clt.Logical().Write(step.Path, data)
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to %s secret %q: %s", step.Method, step.Path, err)
+		}
+	} else {
+		secret, err = clt.Logical().Read(step.Path)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to %s secret %q: %s", step.Method, step.Path, err)
+		}
+	}
+
+	if secret == nil {
+		return nil, nil, fmt.Errorf("no secret found at %q", step.Path)
+	}
+
+	data := secret.Data
+	if step.tokenType { // Got a vault token
+		bytes, _ := json.Marshal(*secret.Auth)
+		data = map[string]interface{}{}
+		json.Unmarshal(bytes, &data)
+	}
+
+	return data, secret, nil
+}
+
+// mapOutput adds the step's mapped output to result. Without output
+// mapping, all data of the step is added.
+func (step *issueStep) mapOutput(data, result map[string]interface{}) error {
+
+	if len(step.Output) == 0 {
+		for k, v := range data {
+			result[k] = v
+		}
+		return nil
+	}
+
+	for key, source := range step.Output {
+		v, ok := lookupPath(data, source)
+		if !ok {
+			return errors.Errorf("output %q of step %q not found", source, step.Name)
+		}
+		result[key] = v
+	}
+
+	return nil
+}
+
+// lookupPath returns the value at a dotted path in data.
+func lookupPath(data map[string]interface{}, p string) (interface{}, bool) {
+
+	var current interface{} = data
+	for _, key := range strings.Split(p, ".") {
+		m, ok := current.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if current, ok = m[key]; !ok {
+			return nil, false
+		}
+	}
+
+	return current, true
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestPipeline_Steps(t *testing.T) {
+
+	var kubeconfigData map[string]interface{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+
+		var resp interface{}
+		switch {
+		case strings.HasPrefix(r.URL.Path, "/v1/auth/token/create/"):
+			resp = map[string]interface{}{"auth": map[string]interface{}{"client_token": "s.client"}}
+		case strings.HasPrefix(r.URL.Path, "/v1/auth/token/roles/"):
+			w.WriteHeader(http.StatusNoContent)
+			return
+		case r.URL.Path == "/v1/pki/issue/admin":
+			if body["common_name"] != "app.yolt.io" {
+				t.Errorf("Unexpected common_name %v\n", body["common_name"])
+			}
+			resp = map[string]interface{}{"data": map[string]interface{}{"certificate": "CERT", "serial_number": "7a:17"}}
+		case r.URL.Path == "/v1/templates/kubeconfig":
+			kubeconfigData = body
+			resp = map[string]interface{}{"data": map[string]interface{}{"kubeconfig": "cert: " + body["cert"].(string)}}
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(resp)
+	}))
+	defer srv.Close()
+
+	steps, err := parseIssueSteps([]interface{}{
+		map[string]interface{}{
+			"name":   "cert",
+			"path":   "pki/issue/admin",
+			"data":   map[string]interface{}{"common_name": "{{parameters.common_name}}", "ttl": "{{ttl}}"},
+			"output": map[string]string{"certificate": "certificate"},
+		},
+		map[string]interface{}{
+			"name":   "kubeconfig",
+			"path":   "templates/kubeconfig",
+			"data":   map[string]interface{}{"cert": "{{steps.cert.certificate}}", "ttl": "{{ttl}}"},
+			"output": map[string]string{"kubeconfig": "kubeconfig"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, storage := getBackend(t)
+	cfg := &configStorageEntry{VaultAddr: srv.URL, VaultToken: "s.plugin", VaultPolicies: []string{"default"}, TokenRoleName: "approved-secrets"}
+	role := &roleStorageEntry{Steps: steps}
+	params := map[string]interface{}{"common_name": "app.yolt.io"}
+
+	data, downstream, err := b.fetchSecret(context.Background(), &logical.Request{Storage: storage}, params, cfg, role, "requester", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if data["certificate"] != "CERT" || data["kubeconfig"] != "cert: CERT" || len(data) != 2 {
+		t.Fatalf("Unexpected data: %#v\n", data)
+	}
+	if kubeconfigData["ttl"] != float64(3600) {
+		t.Fatalf("Expected ttl to keep its type, got %#v\n", kubeconfigData["ttl"])
+	}
+	if len(downstream) != 2 || downstream[0].PKIRevokePath != "pki/revoke" {
+		t.Fatalf("Unexpected downstream secrets: %#v\n", downstream)
+	}
+}
+
+func TestPipeline_Parse(t *testing.T) {
+
+	for _, raw := range []interface{}{
+		map[string]interface{}{"name": "a"},
+		map[string]interface{}{"name": "a b", "path": "p"},
+		map[string]interface{}{"name": "a", "path": "p", "method": "DELETE"},
+		map[string]interface{}{"name": "a", "path": "p", "method": "GET", "data": map[string]interface{}{"k": "v"}},
+		map[string]interface{}{"name": "a", "path": "p", "data": map[string]interface{}{"k": "{{steps.b.v}}"}},
+		map[string]interface{}{"name": "a", "path": "p", "unknown": true},
+	} {
+		if _, err := parseIssueSteps([]interface{}{raw}); err == nil {
+			t.Fatalf("Expected error for step %#v\n", raw)
+		}
+	}
+
+	if _, err := parseIssueSteps([]interface{}{
+		map[string]interface{}{"name": "a", "path": "p"},
+		map[string]interface{}{"name": "a", "path": "q"},
+	}); err == nil {
+		t.Fatalf("Expected error for duplicate steps\n")
+	}
+}
+
+func TestPipeline_AWSStateRole(t *testing.T) {
+
+	// Roles that stored secret_aws_state_role before steps existed keep
+	// issuing secret_path only.
+	role := &roleStorageEntry{SecretPath: "aws/sts/admin", SecretPathMethod: "POST", SecretAWSStateRole: "terraform-state"}
+	steps := role.pipeline()
+	if len(steps) != 1 || steps[0].Path != "aws/sts/admin" || !steps[0].legacy {
+		t.Fatalf("Unexpected pipeline: %#v\n", steps)
+	}
+}
@@ -0,0 +1,883 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	policyPhaseRequest    = "request"
+	policyPhaseApprove    = "approve"
+	policyPhaseIssue      = "issue"
+	policyPhaseBreakGlass = "break_glass"
+)
+
+// policyInputs are the top-level names available in policy expressions.
+var policyInputs = []string{"phase", "requester", "approver", "approvers", "role", "params", "reason", "ticket", "time"}
+
+// rolePolicy holds the rules of a role's policy by name. All rules must hold
+// for a request to be created, approved or issued.
+type rolePolicy map[string]string
+
+// parseRolePolicy parses the rules of a policy, to refuse invalid policies
+// when the role is written.
+func parseRolePolicy(raw map[string]interface{}) (rolePolicy, error) {
+
+	policy := make(rolePolicy)
+	for name, v := range raw {
+		expr, ok := v.(string)
+		if !ok {
+			return nil, errors.Errorf("policy rule %q: expression must be a string", name)
+		}
+		if _, err := parsePolicyExpr(expr); err != nil {
+			return nil, errors.Wrapf(err, "policy rule %q", name)
+		}
+		policy[name] = expr
+	}
+
+	return policy, nil
+}
+
+// evaluate evaluates all rules against the input and returns an error naming
+// the rules that do not hold.
+func (p rolePolicy) evaluate(input map[string]interface{}) error {
+
+	names := make([]string, 0, len(p))
+	for name := range p {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var denied []string
+	for _, name := range names {
+		expr, err := parsePolicyExpr(p[name])
+		if err != nil {
+			return errors.Wrapf(err, "policy rule %q", name)
+		}
+
+		v, err := expr.eval(policyScope{input: input})
+		if err != nil {
+			return errors.Wrapf(err, "policy rule %q", name)
+		}
+		if v != true {
+			denied = append(denied, name)
+		}
+	}
+
+	if len(denied) > 0 {
+		return errors.Errorf("denied by policy: %s", strings.Join(denied, "; "))
+	}
+
+	return nil
+}
+
+// newPolicyInput returns the input of the role's policy for the request in
+// the phase. In the approve phase, the request's approvals include the
+// approval being made, which is also the approver.
+func newPolicyInput(phase, name string, role *roleStorageEntry, sr *requestStorageEntry, now time.Time) map[string]interface{} {
+
+	identity := func(id string, groups []string) map[string]interface{} {
+		if groups == nil {
+			groups = []string{}
+		}
+		return map[string]interface{}{"id": id, "groups": groups}
+	}
+
+	approvers := make([]interface{}, 0, len(sr.Approvals))
+	for _, approval := range sr.Approvals {
+		approvers = append(approvers, identity(approval.ApproverID, approval.Groups))
+	}
+
+	var approver interface{}
+	if phase == policyPhaseApprove && len(sr.Approvals) > 0 {
+		approval := sr.Approvals[len(sr.Approvals)-1]
+		approver = identity(approval.ApproverID, approval.Groups)
+	}
+
+	params := sr.Parameters
+	if params == nil {
+		params = map[string]interface{}{}
+	}
+
+	now = now.UTC()
+	input := map[string]interface{}{
+		"phase":     phase,
+		"requester": identity(sr.RequesterID, sr.RequesterGroups),
+		"approver":  approver,
+		"approvers": approvers,
+		"role": map[string]interface{}{
+			"name":          name,
+			"secret_path":   role.SecretPath,
+			"environment":   role.SecretEnvironment,
+			"min_approvers": role.MinApprovers,
+		},
+		"params": params,
+		"reason": sr.Reason,
+		"ticket": sr.Ticket,
+		"time": map[string]interface{}{
+			"unix":    now.Unix(),
+			"hour":    now.Hour(),
+			"minute":  now.Minute(),
+			"weekday": weekdays[now.Weekday()],
+			"date":    now.Format("2006-01-02"),
+		},
+	}
+
+	// Normalize to JSON types, so numbers compare as float64 and lists as
+	// []interface{}.
+	data, err := json.Marshal(input)
+	if err != nil {
+		return input
+	}
+	var normalized map[string]interface{}
+	if err = json.Unmarshal(data, &normalized); err != nil {
+		return input
+	}
+
+	return normalized
+}
+
+// evaluatePolicy evaluates the role's policy, if any, for the request.
+func evaluatePolicy(phase, name string, role *roleStorageEntry, sr *requestStorageEntry, now time.Time) error {
+	if len(role.Policy) == 0 {
+		return nil
+	}
+	return role.Policy.evaluate(newPolicyInput(phase, name, role, sr, now))
+}
+
+type policyScope struct {
+	input map[string]interface{}
+	vars  map[string]interface{}
+}
+
+func (s policyScope) with(name string, v interface{}) policyScope {
+	vars := make(map[string]interface{}, len(s.vars)+1)
+	for k, v := range s.vars {
+		vars[k] = v
+	}
+	vars[name] = v
+	return policyScope{input: s.input, vars: vars}
+}
+
+type policyExpr interface {
+	eval(s policyScope) (interface{}, error)
+}
+
+type (
+	policyLiteral struct{ value interface{} }
+	policyIdent   struct{ name string }
+	policyList    struct{ items []policyExpr }
+	policyField   struct {
+		x     policyExpr
+		field string
+	}
+	policyIndex  struct{ x, index policyExpr }
+	policyNot    struct{ x policyExpr }
+	policyBinary struct {
+		op   string
+		x, y policyExpr
+	}
+	policyCall struct {
+		name string
+		args []policyExpr
+	}
+	policyQuantifier struct {
+		name string
+		list policyExpr
+		v    string
+		body policyExpr
+	}
+)
+
+func (e policyLiteral) eval(s policyScope) (interface{}, error) { return e.value, nil }
+
+func (e policyIdent) eval(s policyScope) (interface{}, error) {
+	if v, ok := s.vars[e.name]; ok {
+		return v, nil
+	}
+	return s.input[e.name], nil
+}
+
+func (e policyList) eval(s policyScope) (interface{}, error) {
+	list := make([]interface{}, 0, len(e.items))
+	for _, item := range e.items {
+		v, err := item.eval(s)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, v)
+	}
+	return list, nil
+}
+
+func (e policyField) eval(s policyScope) (interface{}, error) {
+	x, err := e.x.eval(s)
+	if err != nil {
+		return nil, err
+	}
+	switch x := x.(type) {
+	case nil:
+		return nil, nil
+	case map[string]interface{}:
+		return x[e.field], nil
+	}
+	return nil, errors.Errorf("cannot select %q of %s", e.field, policyType(x))
+}
+
+func (e policyIndex) eval(s policyScope) (interface{}, error) {
+	x, err := e.x.eval(s)
+	if err != nil {
+		return nil, err
+	}
+	index, err := e.index.eval(s)
+	if err != nil {
+		return nil, err
+	}
+	switch x := x.(type) {
+	case nil:
+		return nil, nil
+	case map[string]interface{}:
+		key, ok := index.(string)
+		if !ok {
+			return nil, errors.Errorf("map index must be a string, got %s", policyType(index))
+		}
+		return x[key], nil
+	case []interface{}:
+		i, ok := index.(float64)
+		if !ok || i != float64(int(i)) {
+			return nil, errors.Errorf("list index must be an integer, got %v", index)
+		}
+		if int(i) < 0 || int(i) >= len(x) {
+			return nil, nil
+		}
+		return x[int(i)], nil
+	}
+	return nil, errors.Errorf("cannot index %s", policyType(x))
+}
+
+func (e policyNot) eval(s policyScope) (interface{}, error) {
+	x, err := evalBool(e.x, s)
+	if err != nil {
+		return nil, err
+	}
+	return !x, nil
+}
+
+func (e policyBinary) eval(s policyScope) (interface{}, error) {
+
+	switch e.op {
+	case "&&", "||":
+		x, err := evalBool(e.x, s)
+		if err != nil {
+			return nil, err
+		}
+		if (e.op == "&&" && !x) || (e.op == "||" && x) {
+			return x, nil
+		}
+		return evalBool(e.y, s)
+	}
+
+	x, err := e.x.eval(s)
+	if err != nil {
+		return nil, err
+	}
+	y, err := e.y.eval(s)
+	if err != nil {
+		return nil, err
+	}
+
+	switch e.op {
+	case "==":
+		return reflect.DeepEqual(x, y), nil
+	case "!=":
+		return !reflect.DeepEqual(x, y), nil
+	case "in":
+		return policyContains(y, x)
+	case "matches":
+		str, ok := x.(string)
+		pattern, ok2 := y.(string)
+		if !ok || !ok2 {
+			return nil, errors.Errorf("matches requires strings, got %s and %s", policyType(x), policyType(y))
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid pattern %q", pattern)
+		}
+		return re.MatchString(str), nil
+	}
+
+	var cmp int
+	switch x := x.(type) {
+	case float64:
+		y, ok := y.(float64)
+		if !ok {
+			return nil, errors.Errorf("cannot compare number with %s", policyType(y))
+		}
+		cmp = compareFloat(x, y)
+	case string:
+		y, ok := y.(string)
+		if !ok {
+			return nil, errors.Errorf("cannot compare string with %s", policyType(y))
+		}
+		cmp = strings.Compare(x, y)
+	default:
+		return nil, errors.Errorf("cannot compare %s", policyType(x))
+	}
+
+	switch e.op {
+	case "<":
+		return cmp < 0, nil
+	case "<=":
+		return cmp <= 0, nil
+	case ">":
+		return cmp > 0, nil
+	case ">=":
+		return cmp >= 0, nil
+	}
+
+	return nil, errors.Errorf("unknown operator %q", e.op)
+}
+
+func (e policyCall) eval(s policyScope) (interface{}, error) {
+
+	args := make([]interface{}, 0, len(e.args))
+	for _, arg := range e.args {
+		v, err := arg.eval(s)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, v)
+	}
+
+	switch e.name {
+	case "count":
+		switch x := args[0].(type) {
+		case nil:
+			return float64(0), nil
+		case []interface{}:
+			return float64(len(x)), nil
+		case map[string]interface{}:
+			return float64(len(x)), nil
+		case string:
+			return float64(len(x)), nil
+		}
+		return nil, errors.Errorf("count requires a list, map or string, got %s", policyType(args[0]))
+	case "contains":
+		return policyContains(args[0], args[1])
+	case "intersects":
+		x, ok := args[0].([]interface{})
+		y, ok2 := args[1].([]interface{})
+		if (!ok && args[0] != nil) || (!ok2 && args[1] != nil) {
+			return nil, errors.Errorf("intersects requires lists, got %s and %s", policyType(args[0]), policyType(args[1]))
+		}
+		for _, v := range x {
+			if found, _ := policyContains(y, v); found {
+				return true, nil
+			}
+		}
+		return false, nil
+	case "lower", "upper":
+		str, ok := args[0].(string)
+		if !ok {
+			return nil, errors.Errorf("%s requires a string, got %s", e.name, policyType(args[0]))
+		}
+		if e.name == "lower" {
+			return strings.ToLower(str), nil
+		}
+		return strings.ToUpper(str), nil
+	case "startswith", "endswith":
+		str, ok := args[0].(string)
+		affix, ok2 := args[1].(string)
+		if !ok || !ok2 {
+			return nil, errors.Errorf("%s requires strings, got %s and %s", e.name, policyType(args[0]), policyType(args[1]))
+		}
+		if e.name == "startswith" {
+			return strings.HasPrefix(str, affix), nil
+		}
+		return strings.HasSuffix(str, affix), nil
+	}
+
+	return nil, errors.Errorf("unknown function %q", e.name)
+}
+
+func (e policyQuantifier) eval(s policyScope) (interface{}, error) {
+
+	v, err := e.list.eval(s)
+	if err != nil {
+		return nil, err
+	}
+	list, ok := v.([]interface{})
+	if !ok && v != nil {
+		return nil, errors.Errorf("%s requires a list, got %s", e.name, policyType(v))
+	}
+
+	var res []interface{}
+	for _, item := range list {
+		scope := s.with(e.v, item)
+		if e.name == "map" {
+			mapped, err := e.body.eval(scope)
+			if err != nil {
+				return nil, err
+			}
+			res = append(res, mapped)
+			continue
+		}
+
+		ok, err := evalBool(e.body, scope)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case e.name == "all" && !ok:
+			return false, nil
+		case e.name == "any" && ok:
+			return true, nil
+		case e.name == "filter" && ok:
+			res = append(res, item)
+		}
+	}
+
+	switch e.name {
+	case "all":
+		return true, nil
+	case "any":
+		return false, nil
+	}
+	if res == nil {
+		res = []interface{}{}
+	}
+	return res, nil
+}
+
+func evalBool(e policyExpr, s policyScope) (bool, error) {
+	v, err := e.eval(s)
+	if err != nil {
+		return false, err
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return false, errors.Errorf("expected bool, got %s", policyType(v))
+	}
+	return b, nil
+}
+
+// policyContains returns whether the list holds v, the map has key v or the
+// string contains v.
+func policyContains(container, v interface{}) (bool, error) {
+	switch c := container.(type) {
+	case nil:
+		return false, nil
+	case []interface{}:
+		for _, item := range c {
+			if reflect.DeepEqual(item, v) {
+				return true, nil
+			}
+		}
+		return false, nil
+	case map[string]interface{}:
+		key, ok := v.(string)
+		if !ok {
+			return false, nil
+		}
+		_, found := c[key]
+		return found, nil
+	case string:
+		str, ok := v.(string)
+		if !ok {
+			return false, errors.Errorf("cannot look up %s in string", policyType(v))
+		}
+		return strings.Contains(c, str), nil
+	}
+	return false, errors.Errorf("cannot look up value in %s", policyType(container))
+}
+
+func compareFloat(x, y float64) int {
+	switch {
+	case x < y:
+		return -1
+	case x > y:
+		return 1
+	}
+	return 0
+}
+
+func policyType(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "bool"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "list"
+	case map[string]interface{}:
+		return "map"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+// policyFunctions are the functions with their number of arguments.
+var policyFunctions = map[string]int{
+	"count":      1,
+	"contains":   2,
+	"intersects": 2,
+	"lower":      1,
+	"upper":      1,
+	"startswith": 2,
+	"endswith":   2,
+}
+
+// policyQuantifiers bind a variable to each element of a list, like
+// all(approvers, a, a.id != requester.id).
+var policyQuantifiers = map[string]bool{"all": true, "any": true, "filter": true, "map": true}
+
+type policyToken struct {
+	kind  string // ident, string, number, op or eof
+	value string
+	pos   int
+}
+
+type policyParser struct {
+	tokens []policyToken
+	pos    int
+	vars   []string
+}
+
+// parsePolicyExpr parses an expression like
+// `all(approvers, a, !intersects(a.groups, requester.groups))`.
+func parsePolicyExpr(s string) (policyExpr, error) {
+
+	tokens, err := tokenizePolicy(s)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &policyParser{tokens: tokens}
+	expr, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != "eof" {
+		return nil, errors.Errorf("unexpected %q at position %d", t.value, t.pos)
+	}
+
+	return expr, nil
+}
+
+func tokenizePolicy(s string) ([]policyToken, error) {
+
+	var tokens []policyToken
+	for i := 0; i < len(s); {
+		c := rune(s[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case c == '"':
+			j := i + 1
+			for j < len(s) && s[j] != '"' {
+				if s[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j >= len(s) {
+				return nil, errors.Errorf("unterminated string at position %d", i)
+			}
+			value, err := strconv.Unquote(s[i : j+1])
+			if err != nil {
+				return nil, errors.Errorf("invalid string at position %d", i)
+			}
+			tokens = append(tokens, policyToken{kind: "string", value: value, pos: i})
+			i = j + 1
+		case c == '\'':
+			// Single quoted strings are raw, which suits regular expressions.
+			j := strings.IndexByte(s[i+1:], '\'')
+			if j < 0 {
+				return nil, errors.Errorf("unterminated string at position %d", i)
+			}
+			tokens = append(tokens, policyToken{kind: "string", value: s[i+1 : i+1+j], pos: i})
+			i += j + 2
+		case unicode.IsDigit(c):
+			j := i
+			for j < len(s) && (unicode.IsDigit(rune(s[j])) || s[j] == '.') {
+				j++
+			}
+			tokens = append(tokens, policyToken{kind: "number", value: s[i:j], pos: i})
+			i = j
+		case unicode.IsLetter(c) || c == '_':
+			j := i
+			for j < len(s) && (unicode.IsLetter(rune(s[j])) || unicode.IsDigit(rune(s[j])) || s[j] == '_') {
+				j++
+			}
+			tokens = append(tokens, policyToken{kind: "ident", value: s[i:j], pos: i})
+			i = j
+		default:
+			op := ""
+			for _, candidate := range []string{"==", "!=", "<=", ">=", "&&", "||", "<", ">", "!", "(", ")", "[", "]", ",", "."} {
+				if strings.HasPrefix(s[i:], candidate) {
+					op = candidate
+					break
+				}
+			}
+			if op == "" {
+				return nil, errors.Errorf("unexpected %q at position %d", c, i)
+			}
+			tokens = append(tokens, policyToken{kind: "op", value: op, pos: i})
+			i += len(op)
+		}
+	}
+
+	return append(tokens, policyToken{kind: "eof", value: "end of expression", pos: len(s)}), nil
+}
+
+func (p *policyParser) peek() policyToken {
+	return p.tokens[p.pos]
+}
+
+func (p *policyParser) next() policyToken {
+	t := p.tokens[p.pos]
+	if t.kind != "eof" {
+		p.pos++
+	}
+	return t
+}
+
+func (p *policyParser) accept(op string) bool {
+	if t := p.peek(); t.kind == "op" && t.value == op {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *policyParser) expect(op string) error {
+	if !p.accept(op) {
+		t := p.peek()
+		return errors.Errorf("expected %q at position %d, got %q", op, t.pos, t.value)
+	}
+	return nil
+}
+
+func (p *policyParser) parseOr() (policyExpr, error) {
+	x, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("||") {
+		y, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		x = policyBinary{op: "||", x: x, y: y}
+	}
+	return x, nil
+}
+
+func (p *policyParser) parseAnd() (policyExpr, error) {
+	x, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("&&") {
+		y, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		x = policyBinary{op: "&&", x: x, y: y}
+	}
+	return x, nil
+}
+
+func (p *policyParser) parseNot() (policyExpr, error) {
+	if p.accept("!") {
+		x, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return policyNot{x: x}, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *policyParser) parseComparison() (policyExpr, error) {
+	x, err := p.parsePostfix()
+	if err != nil {
+		return nil, err
+	}
+
+	t := p.peek()
+	switch {
+	case t.kind == "op" && (t.value == "==" || t.value == "!=" || t.value == "<" || t.value == "<=" || t.value == ">" || t.value == ">="):
+	case t.kind == "ident" && (t.value == "in" || t.value == "matches"):
+	default:
+		return x, nil
+	}
+	p.next()
+
+	y, err := p.parsePostfix()
+	if err != nil {
+		return nil, err
+	}
+
+	return policyBinary{op: t.value, x: x, y: y}, nil
+}
+
+func (p *policyParser) parsePostfix() (policyExpr, error) {
+	x, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		switch {
+		case p.accept("."):
+			t := p.next()
+			if t.kind != "ident" {
+				return nil, errors.Errorf("expected field name at position %d, got %q", t.pos, t.value)
+			}
+			x = policyField{x: x, field: t.value}
+		case p.accept("["):
+			index, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			if err = p.expect("]"); err != nil {
+				return nil, err
+			}
+			x = policyIndex{x: x, index: index}
+		default:
+			return x, nil
+		}
+	}
+}
+
+func (p *policyParser) parsePrimary() (policyExpr, error) {
+
+	t := p.next()
+	switch t.kind {
+	case "string":
+		return policyLiteral{value: t.value}, nil
+	case "number":
+		f, err := strconv.ParseFloat(t.value, 64)
+		if err != nil {
+			return nil, errors.Errorf("invalid number %q at position %d", t.value, t.pos)
+		}
+		return policyLiteral{value: f}, nil
+	case "op":
+		switch t.value {
+		case "(":
+			x, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			return x, p.expect(")")
+		case "[":
+			var items []policyExpr
+			for !p.accept("]") {
+				if len(items) > 0 {
+					if err := p.expect(","); err != nil {
+						return nil, err
+					}
+				}
+				item, err := p.parseOr()
+				if err != nil {
+					return nil, err
+				}
+				items = append(items, item)
+			}
+			return policyList{items: items}, nil
+		}
+	case "ident":
+		switch t.value {
+		case "true":
+			return policyLiteral{value: true}, nil
+		case "false":
+			return policyLiteral{value: false}, nil
+		case "null":
+			return policyLiteral{value: nil}, nil
+		}
+
+		if p.accept("(") {
+			return p.parseCall(t)
+		}
+
+		for i := len(p.vars) - 1; i >= 0; i-- {
+			if p.vars[i] == t.value {
+				return policyIdent{name: t.value}, nil
+			}
+		}
+		for _, name := range policyInputs {
+			if name == t.value {
+				return policyIdent{name: t.value}, nil
+			}
+		}
+		return nil, errors.Errorf("unknown name %q at position %d (expected %s)", t.value, t.pos, strings.Join(policyInputs, ", "))
+	}
+
+	return nil, errors.Errorf("unexpected %q at position %d", t.value, t.pos)
+}
+
+func (p *policyParser) parseCall(name policyToken) (policyExpr, error) {
+
+	if policyQuantifiers[name.value] {
+		list, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if err = p.expect(","); err != nil {
+			return nil, err
+		}
+		v := p.next()
+		if v.kind != "ident" {
+			return nil, errors.Errorf("expected variable name at position %d, got %q", v.pos, v.value)
+		}
+		if err = p.expect(","); err != nil {
+			return nil, err
+		}
+
+		p.vars = append(p.vars, v.value)
+		body, err := p.parseOr()
+		p.vars = p.vars[:len(p.vars)-1]
+		if err != nil {
+			return nil, err
+		}
+
+		return policyQuantifier{name: name.value, list: list, v: v.value, body: body}, p.expect(")")
+	}
+
+	arity, ok := policyFunctions[name.value]
+	if !ok {
+		return nil, errors.Errorf("unknown function %q at position %d", name.value, name.pos)
+	}
+
+	var args []policyExpr
+	for !p.accept(")") {
+		if len(args) > 0 {
+			if err := p.expect(","); err != nil {
+				return nil, err
+			}
+		}
+		arg, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+	}
+
+	if len(args) != arity {
+		return nil, errors.Errorf("function %q takes %d argument(s), got %d", name.value, arity, len(args))
+	}
+
+	return policyCall{name: name.value, args: args}, nil
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestPolicy_Parse(t *testing.T) {
+
+	for _, s := range []string{
+		`all(approvers, a, !intersects(a.groups, requester.groups))`,
+		`phase != "issue" || count(filter(approvers, a, "security" in a.groups)) >= 1`,
+		`params.common_name matches '^[a-z0-9-]+\.yolt\.io$' && time.weekday in ["mon", "tue"]`,
+		`lower(requester.id) != lower(approvers[0].id)`,
+	} {
+		if _, err := parsePolicyExpr(s); err != nil {
+			t.Fatalf("Expected %q to parse, got %v\n", s, err)
+		}
+	}
+
+	for _, s := range []string{"", "requester.id ==", "unknown.id", "all(approvers, a.id)", "count(approvers, requester)", `"unterminated`, "(true"} {
+		if _, err := parsePolicyExpr(s); err == nil {
+			t.Fatalf("Expected error for %q\n", s)
+		}
+	}
+}
+
+func TestPolicy_Evaluate(t *testing.T) {
+
+	role := &roleStorageEntry{SecretPath: "secret/admin", SecretEnvironment: "prd", MinApprovers: 2}
+	sr := &requestStorageEntry{
+		RequesterID:     "bob",
+		RequesterGroups: []string{"team-a"},
+		Parameters:      map[string]interface{}{"common_name": "a.yolt.io", "replicas": 3},
+		Approvals: []requestApproval{
+			{ApproverID: "alice", Groups: []string{"team-b", "security"}},
+			{ApproverID: "carol", Groups: []string{"team-a"}},
+		},
+	}
+	monday := time.Date(2020, 3, 2, 10, 0, 0, 0, time.UTC)
+	input := newPolicyInput(policyPhaseApprove, "admin", role, sr, monday)
+
+	tests := []struct {
+		expr     string
+		expected bool
+	}{
+		{expr: `all(approvers, a, !intersects(a.groups, requester.groups))`, expected: false},
+		{expr: `any(approvers, a, "security" in a.groups)`, expected: true},
+		{expr: `approver.id == "carol" && phase == "approve"`, expected: true},
+		{expr: `count(approvers) >= role.min_approvers && role.environment == "prd"`, expected: true},
+		{expr: `params.replicas < 5 && params.common_name matches 'yolt\.io$'`, expected: true},
+		{expr: `params.missing == null && !("carol" in map(filter(approvers, a, "security" in a.groups), a, a.id))`, expected: true},
+		{expr: `time.weekday == "mon" && time.hour >= 9 && time.hour < 17`, expected: true},
+	}
+
+	for _, tc := range tests {
+		expr, err := parsePolicyExpr(tc.expr)
+		if err != nil {
+			t.Fatalf("%s: %s\n", tc.expr, err)
+		}
+		actual, err := expr.eval(policyScope{input: input})
+		if err != nil {
+			t.Fatalf("%s: %s\n", tc.expr, err)
+		}
+		if actual != tc.expected {
+			t.Fatalf("%s: expected %t got %v\n", tc.expr, tc.expected, actual)
+		}
+	}
+
+	if _, err := mustParsePolicyExpr(t, `requester.id < 1`).eval(policyScope{input: input}); err == nil {
+		t.Fatalf("Expected error when comparing string with number\n")
+	}
+}
+
+func mustParsePolicyExpr(t *testing.T, s string) policyExpr {
+	expr, err := parsePolicyExpr(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return expr
+}
+
+func TestPolicy_Approve(t *testing.T) {
+	b, storage := getBackendWithEntities(t, "http://127.0.0.1:0")
+	ctx := context.Background()
+
+	// Every entity is member of team-a.
+	b.System().(*entitySystemView).GroupsVal = []*logical.Group{{ID: "team-a-id", Name: "team-a"}}
+
+	policy, err := parseRolePolicy(map[string]interface{}{
+		"no approver of the requester's team": "phase != \"approve\" || !intersects(approver.groups, requester.groups)",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = b.roleAccessor.put(ctx, storage, &roleStorageEntry{SecretPath: "secret/admin", MinApprovers: 1, Policy: policy}, "admin"); err != nil {
+		t.Fatal(err)
+	}
+
+	for nonce, groups := range map[string][]string{"r1": {"team-a"}, "r2": {"team-b"}} {
+		sr := &requestStorageEntry{Nonce: nonce, State: requestPending, RequesterID: "bob", RequesterGroups: groups, MinApprovers: 1, ExpiresAt: time.Now().Add(time.Hour)}
+		if err := b.putRequest(ctx, storage, sr, "admin", nonce); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	approve := func(nonce string) *logical.Response {
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "approve/admin",
+			Storage:   storage,
+			EntityID:  "alice",
+			Data:      map[string]interface{}{"nonce": nonce},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	if resp := approve("r1"); resp == nil || !resp.IsError() {
+		t.Fatalf("Expected approval by requester's team to be denied, got %#v\n", resp)
+	}
+	if resp := approve("r2"); resp == nil || resp.IsError() {
+		t.Fatalf("Expected approval by other team, got %#v\n", resp)
+	}
+
+	stored, err := b.request(ctx, storage, "admin", "r1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stored.Approvals) != 0 || stored.State != requestPending {
+		t.Fatalf("Expected denied approval not to be stored, got %#v\n", stored)
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/helper/locksutil"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/pkg/errors"
+)
+
+// requestState is the state of a request in its lifecycle.
+type requestState string
+
+const (
+	requestPending   requestState = "pending"
+	requestApproved  requestState = "approved"
+	requestIssued    requestState = "issued"
+	requestExpired   requestState = "expired"
+	requestRejected  requestState = "rejected"
+	requestCancelled requestState = "cancelled"
+)
+
+// requestTransitions are the allowed transitions between request states.
+// Approvals and rejections that do not change the state are transitions to
+// the same state. Amended requests return to pending. Issued requests only
+// return to approved with releaseRequest, if issuing fails.
+var requestTransitions = map[requestState][]requestState{
+	requestPending:  {requestPending, requestApproved, requestRejected, requestExpired, requestCancelled},
+	requestApproved: {requestPending, requestApproved, requestIssued, requestRejected, requestExpired, requestCancelled},
+}
+
+// state returns the request's state at now. Requests stored before states
+// were introduced derive their state from their approvals and rejections.
+func (sr *requestStorageEntry) state(now time.Time) requestState {
+
+	state := sr.State
+	if state == "" {
+		switch {
+		case sr.Rejected:
+			state = requestRejected
+		case sr.approved():
+			state = requestApproved
+		default:
+			state = requestPending
+		}
+	}
+
+	if (state == requestPending || state == requestApproved) && !now.Before(sr.ExpiresAt) {
+		return requestExpired
+	}
+
+	return state
+}
+
+// transition moves the request to state to, if allowed from its state at now.
+func (sr *requestStorageEntry) transition(to requestState, now time.Time) error {
+
+	from := sr.state(now)
+	for _, allowed := range requestTransitions[from] {
+		if allowed == to {
+			sr.State = to
+			return nil
+		}
+	}
+
+	return errors.Errorf("request is %s", from)
+}
+
+// lockRequest locks the request of role name with nonce and returns the
+// function to unlock it. State transitions must hold the request's lock from
+// reading the request until it is stored.
+func (b *backend) lockRequest(name, nonce string) func() {
+	lock := locksutil.LockForKey(b.requestLocks, path.Join(strings.ToLower(name), strings.ToLower(nonce)))
+	lock.Lock()
+	return lock.Unlock
+}
+
+// putRequest stores the request if it is unchanged since it was read, and
+// increments its version. New requests have version 0.
+func (b *backend) putRequest(ctx context.Context, s logical.Storage, sr *requestStorageEntry, name, nonce string) error {
+
+	stored, err := b.request(ctx, s, name, nonce)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case stored == nil && sr.Version != 0:
+		return logical.CodedError(http.StatusConflict, fmt.Sprintf("request for role %q with nonce %q was deleted concurrently", name, nonce))
+	case stored != nil && stored.Version != sr.Version:
+		return logical.CodedError(http.StatusConflict, fmt.Sprintf("request for role %q with nonce %q was modified concurrently (version %d, expected %d)", name, nonce, stored.Version, sr.Version))
+	}
+
+	sr.Version++
+	if err = b.requestAccessor.put(ctx, s, sr, name, nonce); err != nil {
+		sr.Version--
+		return errors.Wrapf(err, "failed to store request")
+	}
+
+	return nil
+}
+
+// releaseRequest returns a request reserved for issuing to approved, after
+// issuing failed.
+func (b *backend) releaseRequest(ctx context.Context, s logical.Storage, sr *requestStorageEntry, name, nonce string) {
+
+	var err error
+	if from := sr.state(time.Now()); from != requestIssued {
+		err = errors.Errorf("request is %s", from)
+	} else {
+		sr.State = requestApproved
+		err = b.putRequest(ctx, s, sr, name, nonce)
+	}
+	if err != nil {
+		b.Logger().Warn("failed to release request", "role", name, "nonce", nonce, "error", err)
+	}
+}
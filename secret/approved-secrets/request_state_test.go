@@ -0,0 +1,252 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// entitySystemView returns an entity for any entity ID, with a single alias
+// named after the ID.
+type entitySystemView struct {
+	logical.StaticSystemView
+}
+
+func (v *entitySystemView) EntityInfo(entityID string) (*logical.Entity, error) {
+	if entityID == "" {
+		return nil, nil
+	}
+	return &logical.Entity{ID: entityID, Name: entityID, Aliases: []*logical.Alias{{Name: entityID}}}, nil
+}
+
+func getBackendWithEntities(t *testing.T, vaultAddr string) (*backend, logical.Storage) {
+	b := newBackend()
+	config := &logical.BackendConfig{
+		System:      &entitySystemView{logical.StaticSystemView{DefaultLeaseTTLVal: time.Hour, MaxLeaseTTLVal: 24 * time.Hour}},
+		StorageView: &logical.InmemStorage{},
+	}
+	if err := b.Setup(context.Background(), config); err != nil {
+		t.Fatalf("unable to create backend: %v", err)
+	}
+
+	cfg := &configStorageEntry{VaultAddr: vaultAddr, VaultToken: "s.plugin", VaultPolicies: []string{"default"}, TokenRoleName: "approved-secrets", ApprovalTTL: time.Hour}
+	if err := b.configAccessor.put(context.Background(), config.StorageView, cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	return b, config.StorageView
+}
+
+func TestRequestState_Transitions(t *testing.T) {
+
+	now := time.Now()
+	tests := []struct {
+		name    string
+		sr      *requestStorageEntry
+		to      requestState
+		allowed bool
+	}{
+		{name: "approve pending", sr: &requestStorageEntry{State: requestPending, ExpiresAt: now.Add(time.Hour)}, to: requestApproved, allowed: true},
+		{name: "issue pending", sr: &requestStorageEntry{State: requestPending, ExpiresAt: now.Add(time.Hour)}, to: requestIssued},
+		{name: "issue approved", sr: &requestStorageEntry{State: requestApproved, ExpiresAt: now.Add(time.Hour)}, to: requestIssued, allowed: true},
+		{name: "issue issued", sr: &requestStorageEntry{State: requestIssued, ExpiresAt: now.Add(time.Hour)}, to: requestIssued},
+		{name: "approve issued", sr: &requestStorageEntry{State: requestIssued, ExpiresAt: now.Add(time.Hour)}, to: requestApproved},
+		{name: "reject issued", sr: &requestStorageEntry{State: requestIssued, ExpiresAt: now.Add(time.Hour)}, to: requestRejected},
+		{name: "approve rejected", sr: &requestStorageEntry{State: requestRejected, ExpiresAt: now.Add(time.Hour)}, to: requestApproved},
+		{name: "issue expired", sr: &requestStorageEntry{State: requestApproved, ExpiresAt: now.Add(-time.Second)}, to: requestIssued},
+		{name: "legacy rejected", sr: &requestStorageEntry{Rejected: true, ExpiresAt: now.Add(time.Hour)}, to: requestApproved},
+		{name: "legacy approved", sr: &requestStorageEntry{ExpiresAt: now.Add(time.Hour)}, to: requestIssued, allowed: true},
+	}
+
+	for _, tc := range tests {
+		err := tc.sr.transition(tc.to, now)
+		if tc.allowed != (err == nil) {
+			t.Fatalf("%s: expected allowed %t got error %v\n", tc.name, tc.allowed, err)
+		}
+	}
+}
+
+func TestRequestState_ApproveIssued(t *testing.T) {
+	b, storage := getBackendWithEntities(t, "http://127.0.0.1:0")
+	ctx := context.Background()
+
+	if err := b.roleAccessor.put(ctx, storage, &roleStorageEntry{SecretPath: "secret/admin", MinApprovers: 1, MinRejecters: 1}, "admin"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Reserved for issuing by path_issue.
+	sr := &requestStorageEntry{Nonce: "nonce", State: requestIssued, RequesterID: "requester", MinApprovers: 1, ApproverIDs: []string{"one"}, ExpiresAt: time.Now().Add(time.Hour)}
+	if err := b.putRequest(ctx, storage, sr, "admin", "nonce"); err != nil {
+		t.Fatal(err)
+	}
+
+	for path, data := range map[string]map[string]interface{}{
+		"approve/admin": {"nonce": "nonce"},
+		"reject/admin":  {"nonce": "nonce", "comment": "no"},
+	} {
+		resp, err := b.HandleRequest(ctx, &logical.Request{Operation: logical.UpdateOperation, Path: path, Storage: storage, EntityID: "approver", Data: data})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp == nil || !resp.IsError() {
+			t.Fatalf("%s: expected error for issued request, got %#v\n", path, resp)
+		}
+	}
+
+	stored, err := b.request(ctx, storage, "admin", "nonce")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.State != requestIssued {
+		t.Fatalf("Expected request to stay issued, got %s\n", stored.State)
+	}
+
+	b.releaseRequest(ctx, storage, stored, "admin", "nonce")
+	if stored, err = b.request(ctx, storage, "admin", "nonce"); err != nil || stored.State != requestApproved {
+		t.Fatalf("Expected released request to be approved, got %#v (%v)\n", stored, err)
+	}
+}
+
+func TestRequestState_ConcurrentApprove(t *testing.T) {
+	b, storage := getBackendWithEntities(t, "http://127.0.0.1:0")
+	ctx := context.Background()
+
+	const approvers = 25
+	if err := b.roleAccessor.put(ctx, storage, &roleStorageEntry{SecretPath: "secret/admin", MinApprovers: approvers}, "admin"); err != nil {
+		t.Fatal(err)
+	}
+
+	sr := &requestStorageEntry{Nonce: "nonce", State: requestPending, RequesterID: "requester", MinApprovers: approvers, ExpiresAt: time.Now().Add(time.Hour)}
+	if err := b.putRequest(ctx, storage, sr, "admin", "nonce"); err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < approvers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			resp, err := b.HandleRequest(ctx, &logical.Request{
+				Operation: logical.UpdateOperation,
+				Path:      "approve/admin",
+				Storage:   storage,
+				EntityID:  fmt.Sprintf("approver-%d", i),
+				Data:      map[string]interface{}{"nonce": "nonce"},
+			})
+			if err != nil || resp == nil || resp.IsError() {
+				t.Errorf("approver %d: err:%s resp:%#v\n", i, err, resp)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	stored, err := b.request(ctx, storage, "admin", "nonce")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stored.ApproverIDs) != approvers || len(stored.Approvals) != approvers {
+		t.Fatalf("Expected %d approvals, got %v\n", approvers, stored.ApproverIDs)
+	}
+	if stored.Version != approvers+1 || stored.State != requestApproved {
+		t.Fatalf("Expected version %d in state approved, got %d in state %s\n", approvers+1, stored.Version, stored.State)
+	}
+}
+
+func TestRequestState_ConcurrentApproveIssue(t *testing.T) {
+
+	var fetches int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var resp interface{}
+		switch {
+		case strings.HasPrefix(r.URL.Path, "/v1/auth/token/create/"):
+			resp = map[string]interface{}{"auth": map[string]interface{}{"client_token": "s.client"}}
+		case strings.HasPrefix(r.URL.Path, "/v1/auth/token/roles/"):
+			w.WriteHeader(http.StatusNoContent)
+			return
+		case r.URL.Path == "/v1/secret/admin":
+			atomic.AddInt32(&fetches, 1)
+			resp = map[string]interface{}{"data": map[string]interface{}{"password": "secret"}}
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(resp)
+	}))
+	defer srv.Close()
+
+	b, storage := getBackendWithEntities(t, srv.URL)
+	ctx := context.Background()
+
+	role := &roleStorageEntry{SecretPath: "secret/admin", SecretPathMethod: "GET", SecretTTL: time.Hour, MinApprovers: 1}
+	if err := b.roleAccessor.put(ctx, storage, role, "admin"); err != nil {
+		t.Fatal(err)
+	}
+
+	sr := &requestStorageEntry{Nonce: "nonce", State: requestPending, RequesterID: "requester", MinApprovers: 1, ExpiresAt: time.Now().Add(time.Hour)}
+	if err := b.putRequest(ctx, storage, sr, "admin", "nonce"); err != nil {
+		t.Fatal(err)
+	}
+
+	const workers = 20
+	var issued int32
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			b.HandleRequest(ctx, &logical.Request{
+				Operation: logical.UpdateOperation,
+				Path:      "approve/admin",
+				Storage:   storage,
+				EntityID:  fmt.Sprintf("approver-%d", i),
+				Data:      map[string]interface{}{"nonce": "nonce"},
+			})
+		}(i)
+		go func() {
+			defer wg.Done()
+			resp, err := b.HandleRequest(ctx, &logical.Request{
+				Operation: logical.UpdateOperation,
+				Path:      "issue/admin/nonce",
+				Storage:   storage,
+				EntityID:  "requester",
+			})
+			if err == nil && resp != nil && !resp.IsError() {
+				atomic.AddInt32(&issued, 1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if issued > 1 || fetches != issued {
+		t.Fatalf("Expected at most one issue, got %d issue(s) and %d fetch(es)\n", issued, fetches)
+	}
+
+	// Issue once more if all issue attempts came before the first approval.
+	if issued == 0 {
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "issue/admin/nonce",
+			Storage:   storage,
+			EntityID:  "requester",
+		})
+		if err != nil || resp == nil || resp.IsError() {
+			t.Fatalf("err:%s resp:%#v\n", err, resp)
+		}
+	}
+
+	stored, err := b.request(ctx, storage, "admin", "nonce")
+	if err != nil {
+		t.Fatal(err)
+	} else if stored != nil {
+		t.Fatalf("Expected issued request to be deleted, got %#v\n", stored)
+	}
+}
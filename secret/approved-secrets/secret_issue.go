@@ -37,10 +37,41 @@ func (b *backend) secretApprovedSecretIssueRevoke(ctx context.Context, r *logica
 	}
 	nonce := nonceRaw.(string)
 
-	err := b.issueAccessor.delete(ctx, r.Storage, roleName, nonce)
+	// The lease may be released concurrently by leases/:name/:nonce/revoke or
+	// the sweep of expired leases, which then record its end.
+	b.leaseMutex.Lock()
+	issue, err := b.issue(ctx, r.Storage, roleName, nonce)
+	if err != nil {
+		b.leaseMutex.Unlock()
+		return nil, err
+	}
+
+	if issue != nil {
+		cfg, err := b.config(ctx, r.Storage)
+		if err != nil {
+			b.leaseMutex.Unlock()
+			return nil, err
+		}
+		if err = revokeDownstreamSecrets(ctx, cfg, issue.Downstream); err != nil {
+			b.Logger().Warn("failed to revoke downstream secret", "role", roleName, "nonce", nonce, "error", err)
+		}
+	}
+
+	err = b.issueAccessor.delete(ctx, r.Storage, roleName, nonce)
+	b.leaseMutex.Unlock()
 	if err != nil {
 		return logical.ErrorResponse(fmt.Sprintf("failed to delete issue for role %q with nonce %q: %s", roleName, nonce, err.Error())), nil
 	}
 
-	return nil, nil
+	if issue == nil {
+		return nil, nil
+	}
+
+	return b.recordLeaseEnd(ctx, r, roleName, issue.SlackThreads, notificationEvent{
+		Type:   eventSecretRevoked,
+		Actor:  issue.IssuerID,
+		Role:   roleName,
+		Nonce:  nonce,
+		Reason: "secret lease expired or revoked",
+	})
 }
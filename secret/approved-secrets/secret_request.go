@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"path"
+	"time"
 
 	"github.com/hashicorp/vault/sdk/framework"
 	"github.com/hashicorp/vault/sdk/logical"
@@ -38,10 +39,35 @@ func (b *backend) secretApprovedSecretRequestRevoke(ctx context.Context, r *logi
 	}
 	nonce := nonceRaw.(string)
 
-	err := b.requestAccessor.delete(ctx, r.Storage, path.Join(roleName, nonce))
+	unlock := b.lockRequest(roleName, nonce)
+	defer unlock()
+
+	sr, err := b.request(ctx, r.Storage, roleName, nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	err = b.requestAccessor.delete(ctx, r.Storage, path.Join(roleName, nonce))
 	if err != nil {
 		return logical.ErrorResponse(fmt.Sprintf("failed to delete request for role %q with nonce %q: %s", roleName, nonce, err.Error())), nil
 	}
 
-	return nil, nil
+	// Requests are deleted when issued, so a request that is still present
+	// when its lease ends has expired without being issued, unless it was
+	// left reserved for issuing or already ended by rejection or cancellation.
+	if sr == nil {
+		return nil, nil
+	}
+	switch sr.state(time.Now()) {
+	case requestIssued, requestRejected, requestCancelled:
+		return nil, nil
+	}
+
+	return b.recordLeaseEnd(ctx, r, roleName, sr.SlackThreads, notificationEvent{
+		Type:   eventRequestExpired,
+		Actor:  sr.RequesterID,
+		Role:   roleName,
+		Nonce:  nonce,
+		Reason: "request was not issued within approval_ttl",
+	})
 }
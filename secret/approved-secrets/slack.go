@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"time"
+
+	"github.com/ashwanthkumar/slack-go-webhook"
+	"github.com/pkg/errors"
+)
+
+const slackTimeout = 10 * time.Second
+
+var (
+	// slackAPIURL is the base URL of Slack's Web API.
+	slackAPIURL = "https://slack.com/api"
+
+	// slackHTTPClient is shared by all calls to Slack. Notifications are sent
+	// while the request is locked, so a hung Slack endpoint must time out.
+	slackHTTPClient = &http.Client{Timeout: slackTimeout}
+)
+
+type slackClient struct {
+	apiURL, token string
+}
+
+func newSlackClient(apiURL, token string) slackClient {
+	return slackClient{
+		apiURL: apiURL,
+		token:  token,
+	}
+}
+
+type slackMessage struct {
+	slack.Payload
+	ThreadTS string        `json:"thread_ts,omitempty"`
+	TS       string        `json:"ts,omitempty"`
+	Blocks   []interface{} `json:"blocks,omitempty"`
+}
+
+type slackResponse struct {
+	OK    bool   `json:"ok"`
+	Error string `json:"error"`
+	TS    string `json:"ts"`
+}
+
+// postMessage posts the payload with chat.postMessage, optionally as a reply
+// in the thread started by threadTS. Blocks, if any, replace the payload's
+// attachments in Slack clients with Block Kit support. It returns the
+// timestamp of the message.
+func (clt slackClient) postMessage(channel, threadTS string, payload slack.Payload, blocks []interface{}) (string, error) {
+
+	payload.Channel = channel
+	msg, err := clt.call("chat.postMessage", slackMessage{Payload: payload, ThreadTS: threadTS, Blocks: blocks})
+	if err != nil {
+		return "", err
+	}
+
+	return msg.TS, nil
+}
+
+// updateMessage replaces the message with timestamp ts with chat.update.
+func (clt slackClient) updateMessage(channel, ts string, payload slack.Payload, blocks []interface{}) error {
+
+	payload.Channel = channel
+	_, err := clt.call("chat.update", slackMessage{Payload: payload, TS: ts, Blocks: blocks})
+	return err
+}
+
+func (clt slackClient) call(method string, message slackMessage) (*slackResponse, error) {
+
+	url, err := url.Parse(clt.apiURL)
+	if err != nil {
+		return nil, errors.Errorf("invalid Slack API URL: %s", clt.apiURL)
+	}
+	url.Path = path.Join(url.Path, method)
+
+	data, _ := json.Marshal(message)
+
+	req, err := http.NewRequest("POST", url.String(), bytes.NewBuffer(data))
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to create request: %s", url)
+	}
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	req.Header.Set("Authorization", "Bearer "+clt.token)
+	res, err := slackHTTPClient.Do(req)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to POST URL: %s", url)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("unexpected result code: %d", res.StatusCode)
+	}
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read response body: %s", url)
+	}
+
+	var msg slackResponse
+	if err = json.Unmarshal(body, &msg); err != nil {
+		return nil, errors.Errorf("failed to unmarshal response body: %s", url)
+	}
+
+	if !msg.OK {
+		return nil, errors.Errorf("slack API error: %s", msg.Error)
+	}
+
+	return &msg, nil
+}
+
+// respond posts an ephemeral message to the user who triggered an
+// interaction, using the interaction's response_url.
+func respond(responseURL, text string) error {
+
+	data, _ := json.Marshal(map[string]interface{}{
+		"response_type":    "ephemeral",
+		"replace_original": false,
+		"text":             text,
+	})
+
+	res, err := slackHTTPClient.Post(responseURL, "application/json; charset=utf-8", bytes.NewBuffer(data))
+	if err != nil {
+		return errors.Wrap(err, "failed to POST response URL")
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return errors.Errorf("unexpected result code: %d", res.StatusCode)
+	}
+
+	return nil
+}
+
+// sendWebhook posts the payload to the incoming webhook URL.
+func sendWebhook(webhookURL string, payload slack.Payload) error {
+
+	data, _ := json.Marshal(payload)
+
+	res, err := slackHTTPClient.Post(webhookURL, "application/json; charset=utf-8", bytes.NewBuffer(data))
+	if err != nil {
+		return errors.Wrap(err, "failed to POST webhook URL")
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return errors.Errorf("unexpected result code: %d", res.StatusCode)
+	}
+
+	return nil
+}
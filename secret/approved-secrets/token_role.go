@@ -0,0 +1,291 @@
+package main
+
+import (
+	"context"
+	"path"
+	"strings"
+
+	uuid "github.com/hashicorp/go-uuid"
+	"github.com/hashicorp/vault/api"
+	"github.com/hashicorp/vault/sdk/helper/parseutil"
+	"github.com/hashicorp/vault/sdk/helper/strutil"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/pkg/errors"
+)
+
+// legacyTokenRolePrefix is the prefix of the temporary token roles created
+// for each token by earlier versions.
+const legacyTokenRolePrefix = "pagerduty-secrets-"
+
+// defaultTokenRoleName returns the name of the managed token role of the
+// mount at mountPoint.
+func defaultTokenRoleName(mountPoint string) string {
+
+	name := strings.Replace(strings.Trim(mountPoint, "/"), "/", "-", -1)
+	if name == "" || name == "approved-secrets" {
+		return "approved-secrets"
+	}
+
+	return "approved-secrets-" + name
+}
+
+// tokenRole returns the mount's managed token role, allowing tokens for
+// entityAlias. Configs of earlier versions get the default token role, which
+// is created on first use.
+func (b *backend) tokenRole(ctx context.Context, r *logical.Request, cfg *configStorageEntry, entityAlias string) (string, error) {
+
+	if cfg.TokenRoleName == "" {
+		cfg.TokenRoleName = defaultTokenRoleName(r.MountPoint)
+		if err := b.configAccessor.put(ctx, r.Storage, cfg); err != nil {
+			return "", errors.Wrap(err, "failed to store config")
+		}
+	}
+
+	if err := b.allowTokenRoleAlias(ctx, r.Storage, cfg, entityAlias); err != nil {
+		return "", err
+	}
+
+	return cfg.TokenRoleName, nil
+}
+
+// tokenRolePolicies returns the policies tokens of the managed token role
+// may have: the config's vault_policies and the policies of vault-token
+// roles.
+func (b *backend) tokenRolePolicies(ctx context.Context, s logical.Storage, cfg *configStorageEntry) ([]string, error) {
+
+	policies := append([]string{}, cfg.VaultPolicies...)
+
+	rolePolicies, err := b.vaultTokenPolicies(ctx, s)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range rolePolicies {
+		policies = append(policies, p...)
+	}
+
+	return strutil.RemoveDuplicates(policies, false), nil
+}
+
+// vaultTokenPolicies returns the policies of each vault-token role.
+func (b *backend) vaultTokenPolicies(ctx context.Context, s logical.Storage) ([][]string, error) {
+
+	names, err := b.roleAccessor.list(ctx, s)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list roles")
+	}
+
+	var policies [][]string
+	for _, name := range names {
+		role, err := b.role(ctx, s, name)
+		if err != nil {
+			return nil, err
+		} else if role == nil || role.SecretType != "vault-token" {
+			continue
+		}
+
+		rolePolicies, err := parseutil.ParseCommaStringSlice(role.SecretData["policies"])
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid policies of role %q", name)
+		}
+		policies = append(policies, rolePolicies)
+	}
+
+	return policies, nil
+}
+
+// reconcileTokenRole creates or updates the mount's managed token role if
+// it differs from the desired state.
+func (b *backend) reconcileTokenRole(ctx context.Context, s logical.Storage, cfg *configStorageEntry) error {
+
+	b.tokenRoleMutex.Lock()
+	defer b.tokenRoleMutex.Unlock()
+
+	return b.writeTokenRole(ctx, s, cfg, "")
+}
+
+// allowTokenRoleAlias adds entityAlias to the allowed entity aliases of the
+// managed token role, unless it is allowed already.
+func (b *backend) allowTokenRoleAlias(ctx context.Context, s logical.Storage, cfg *configStorageEntry, entityAlias string) error {
+
+	b.tokenRoleMutex.Lock()
+	defer b.tokenRoleMutex.Unlock()
+
+	return b.writeTokenRole(ctx, s, cfg, strings.ToLower(entityAlias))
+}
+
+// writeTokenRole writes the managed token role if it differs from the desired
+// state. Tokens are created for the issuer's entity alias, so the role allows
+// the aliases it already allows and entityAlias, if set, but no wildcard. It
+// must be called with tokenRoleMutex held.
+func (b *backend) writeTokenRole(ctx context.Context, s logical.Storage, cfg *configStorageEntry, entityAlias string) error {
+
+	policies, err := b.tokenRolePolicies(ctx, s, cfg)
+	if err != nil {
+		return err
+	}
+
+	clt, err := newVaultClient(ctx, cfg.VaultAddr, cfg.VaultToken)
+	if err != nil {
+		return errors.Wrap(err, "failed to create Vault client")
+	}
+
+	rolePath := path.Join("auth/token/roles", cfg.TokenRoleName)
+	secret, err := clt.Logical().Read(rolePath)
+	if err != nil {
+		return errors.Wrapf(err, "failed to read token role %q", cfg.TokenRoleName)
+	}
+
+	aliases := []string{}
+	if secret != nil {
+		allowed, _ := parseutil.ParseCommaStringSlice(secret.Data["allowed_entity_aliases"])
+		for _, alias := range allowed {
+			if alias != "*" {
+				aliases = append(aliases, alias)
+			}
+		}
+	}
+	if entityAlias != "" {
+		aliases = strutil.AppendIfMissing(aliases, entityAlias)
+	}
+
+	if secret != nil && tokenRoleUpToDate(secret.Data, policies, aliases) {
+		return nil
+	}
+
+	data := map[string]interface{}{
+		"allowed_policies":       policies,
+		"allowed_entity_aliases": aliases,
+		"orphan":                 true,
+		"renewable":              false,
+	}
+	if _, err = clt.Logical().Write(rolePath, data); err != nil {
+		return errors.Wrapf(err, "failed to write token role %q", cfg.TokenRoleName)
+	}
+
+	return nil
+}
+
+func tokenRoleUpToDate(data map[string]interface{}, policies, aliases []string) bool {
+
+	if orphan, _ := data["orphan"].(bool); !orphan {
+		return false
+	}
+	if renewable, _ := data["renewable"].(bool); renewable {
+		return false
+	}
+
+	actualAliases, _ := parseutil.ParseCommaStringSlice(data["allowed_entity_aliases"])
+	if !strutil.EquivalentSlices(actualAliases, aliases) {
+		return false
+	}
+
+	actual, _ := parseutil.ParseCommaStringSlice(data["allowed_policies"])
+	return strutil.EquivalentSlices(actual, policies)
+}
+
+// deleteTokenRole deletes a managed token role that is not used anymore.
+func deleteTokenRole(clt *api.Client, name string) error {
+	_, err := clt.Logical().Delete(path.Join("auth/token/roles", name))
+	return errors.Wrapf(err, "failed to delete token role %q", name)
+}
+
+// cleanupLegacyTokenRoles deletes the temporary token roles of earlier
+// versions. Such a role only lives for the duration of a token creation, so
+// a role is deleted when it was already seen by the previous run. Other
+// plugins may create token roles with the same prefix, so only roles whose
+// allowed_policies are those of one of the mount's vault-token roles are
+// deleted. It must be called with tidyMutex held.
+func (b *backend) cleanupLegacyTokenRoles(ctx context.Context, s logical.Storage, cfg *configStorageEntry) error {
+
+	rolePolicies, err := b.vaultTokenPolicies(ctx, s)
+	if err != nil {
+		return err
+	} else if len(rolePolicies) == 0 {
+		b.legacyTokenRoles = nil
+		return nil
+	}
+
+	clt, err := newVaultClient(ctx, cfg.VaultAddr, cfg.VaultToken)
+	if err != nil {
+		return errors.Wrap(err, "failed to create Vault client")
+	}
+
+	secret, err := clt.Logical().List("auth/token/roles")
+	if err != nil {
+		return errors.Wrap(err, "failed to list token roles")
+	}
+
+	var keys []string
+	if secret != nil {
+		keys, _ = parseutil.ParseCommaStringSlice(secret.Data["keys"])
+	}
+
+	seen := map[string]bool{}
+	for _, name := range keys {
+		if !isLegacyTokenRoleName(name) {
+			continue
+		}
+
+		if !b.legacyTokenRoles[name] {
+			seen[name] = true
+			continue
+		}
+
+		owned, err := legacyTokenRoleOwned(clt, name, rolePolicies)
+		if err != nil {
+			seen[name] = true
+			b.Logger().Warn("failed to read legacy token role", "role", name, "error", err)
+			continue
+		} else if !owned {
+			continue
+		}
+
+		if err = deleteTokenRole(clt, name); err != nil {
+			seen[name] = true
+			b.Logger().Warn("failed to delete legacy token role", "role", name, "error", err)
+		}
+	}
+
+	b.legacyTokenRoles = seen
+
+	return nil
+}
+
+// isLegacyTokenRoleName reports whether name is of the form used by earlier
+// versions: legacyTokenRolePrefix followed by a UUID.
+func isLegacyTokenRoleName(name string) bool {
+
+	if !strings.HasPrefix(name, legacyTokenRolePrefix) {
+		return false
+	}
+
+	_, err := uuid.ParseUUID(strings.TrimPrefix(name, legacyTokenRolePrefix))
+	return err == nil
+}
+
+// legacyTokenRoleOwned reports whether the legacy token role name was created
+// by this mount, i.e. it allows exactly the policies of one of the mount's
+// vault-token roles for a single entity alias.
+func legacyTokenRoleOwned(clt *api.Client, name string, rolePolicies [][]string) (bool, error) {
+
+	secret, err := clt.Logical().Read(path.Join("auth/token/roles", name))
+	if err != nil {
+		return false, errors.Wrapf(err, "failed to read token role %q", name)
+	} else if secret == nil {
+		return false, nil
+	}
+
+	aliases, _ := parseutil.ParseCommaStringSlice(secret.Data["allowed_entity_aliases"])
+	if len(aliases) != 1 || aliases[0] == "*" {
+		return false, nil
+	}
+
+	policies, _ := parseutil.ParseCommaStringSlice(secret.Data["allowed_policies"])
+	for _, p := range rolePolicies {
+		if len(p) > 0 && strutil.EquivalentSlices(policies, p) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
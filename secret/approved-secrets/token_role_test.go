@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeTokenRoles serves the token roles endpoints of Vault.
+type fakeTokenRoles struct {
+	sync.Mutex
+	roles  map[string]map[string]interface{}
+	writes int
+}
+
+func (f *fakeTokenRoles) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.Lock()
+	defer f.Unlock()
+
+	name := strings.TrimPrefix(r.URL.Path, "/v1/auth/token/roles")
+	name = strings.TrimPrefix(name, "/")
+	switch {
+	case r.Method == "LIST" || r.URL.Query().Get("list") == "true":
+		var keys []string
+		for k := range f.roles {
+			keys = append(keys, k)
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string]interface{}{"keys": keys}})
+	case r.Method == http.MethodGet:
+		role, ok := f.roles[name]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"data": role})
+	case r.Method == http.MethodPut || r.Method == http.MethodPost:
+		var data map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&data)
+		f.roles[name] = data
+		f.writes++
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodDelete:
+		delete(f.roles, name)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func TestTokenRole_Reconcile(t *testing.T) {
+	b, storage := getBackend(t)
+	ctx := context.Background()
+
+	fake := &fakeTokenRoles{roles: map[string]map[string]interface{}{}}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	role := &roleStorageEntry{SecretType: "vault-token", SecretData: map[string]interface{}{"policies": "admin,default"}}
+	if err := b.roleAccessor.put(ctx, storage, role, "token"); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &configStorageEntry{VaultAddr: srv.URL, VaultToken: "s.plugin", VaultPolicies: []string{"default", "issuer"}, TokenRoleName: "approved-secrets"}
+	for i := 0; i < 2; i++ {
+		if err := b.reconcileTokenRole(ctx, storage, cfg); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if fake.writes != 1 {
+		t.Fatalf("Expected token role to be written once, got %d\n", fake.writes)
+	}
+
+	policies, _ := json.Marshal(fake.roles["approved-secrets"]["allowed_policies"])
+	if string(policies) != `["admin","default","issuer"]` {
+		t.Fatalf("Unexpected allowed_policies: %s\n", policies)
+	}
+	if aliases, _ := json.Marshal(fake.roles["approved-secrets"]["allowed_entity_aliases"]); string(aliases) != `[]` {
+		t.Fatalf("Unexpected allowed_entity_aliases: %s\n", aliases)
+	}
+}
+
+func TestTokenRole_AllowAlias(t *testing.T) {
+	b, storage := getBackend(t)
+	ctx := context.Background()
+
+	// Written by an earlier version with a wildcard.
+	fake := &fakeTokenRoles{roles: map[string]map[string]interface{}{
+		"approved-secrets": {"allowed_policies": []string{"default"}, "allowed_entity_aliases": []string{"*", "one@yolt.com"}, "orphan": true},
+	}}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	cfg := &configStorageEntry{VaultAddr: srv.URL, VaultToken: "s.plugin", VaultPolicies: []string{"default"}, TokenRoleName: "approved-secrets"}
+	for _, alias := range []string{"Two@yolt.com", "two@yolt.com"} {
+		if err := b.allowTokenRoleAlias(ctx, storage, cfg, alias); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := b.reconcileTokenRole(ctx, storage, cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	if fake.writes != 1 {
+		t.Fatalf("Expected token role to be written once, got %d\n", fake.writes)
+	}
+	if aliases, _ := json.Marshal(fake.roles["approved-secrets"]["allowed_entity_aliases"]); string(aliases) != `["one@yolt.com","two@yolt.com"]` {
+		t.Fatalf("Unexpected allowed_entity_aliases: %s\n", aliases)
+	}
+}
+
+func TestTokenRole_CleanupLegacy(t *testing.T) {
+	b, storage := getBackend(t)
+	ctx := context.Background()
+
+	role := &roleStorageEntry{SecretType: "vault-token", SecretData: map[string]interface{}{"policies": "admin,default"}}
+	if err := b.roleAccessor.put(ctx, storage, role, "token"); err != nil {
+		t.Fatal(err)
+	}
+
+	const (
+		owned   = "pagerduty-secrets-6a1f0c6e-2b4d-4f0e-9c3a-1d2e3f405162"
+		added   = "pagerduty-secrets-7b2f1d7f-3c5e-4a1f-8d4b-2e3f40516273"
+		foreign = "pagerduty-secrets-8c3a2e80-4d6f-4b2a-9e5c-3f4051627384"
+		other   = "pagerduty-secrets-oncall"
+	)
+	fake := &fakeTokenRoles{roles: map[string]map[string]interface{}{
+		owned:              {"allowed_policies": []string{"default", "admin"}, "allowed_entity_aliases": []string{"one@yolt.com"}},
+		foreign:            {"allowed_policies": []string{"pagerduty"}, "allowed_entity_aliases": []string{"one@yolt.com"}},
+		other:              {"allowed_policies": []string{"admin", "default"}, "allowed_entity_aliases": []string{"one@yolt.com"}},
+		"approved-secrets": {"allowed_policies": []string{"admin", "default"}},
+	}}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	cfg := &configStorageEntry{VaultAddr: srv.URL, VaultToken: "s.plugin"}
+	if err := b.cleanupLegacyTokenRoles(ctx, storage, cfg); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := fake.roles[owned]; !ok {
+		t.Fatalf("Expected legacy token role to be kept on first sight\n")
+	}
+
+	fake.roles[added] = map[string]interface{}{"allowed_policies": []string{"admin", "default"}, "allowed_entity_aliases": []string{"two@yolt.com"}}
+	if err := b.cleanupLegacyTokenRoles(ctx, storage, cfg); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := fake.roles[owned]; ok {
+		t.Fatalf("Expected orphaned legacy token role to be deleted\n")
+	}
+	for _, name := range []string{added, foreign, other, "approved-secrets"} {
+		if _, ok := fake.roles[name]; !ok {
+			t.Fatalf("Expected token role %q to be kept\n", name)
+		}
+	}
+}
+
+func TestTokenRole_DefaultName(t *testing.T) {
+	for mount, expected := range map[string]string{
+		"approved-secrets/":     "approved-secrets",
+		"team/approved-secrets": "approved-secrets-team-approved-secrets",
+		"":                      "approved-secrets",
+	} {
+		if actual := defaultTokenRoleName(mount); actual != expected {
+			t.Fatalf("%q: expected %q got %q\n", mount, expected, actual)
+		}
+	}
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	ticketTrackerJira = "jira"
+	ticketTrackerHTTP = "http"
+
+	ticketTrackerTimeout = 10 * time.Second
+)
+
+// ticket is a ticket as seen by a tracker.
+type ticket struct {
+	Key  string
+	URL  string
+	Open bool
+
+	// Assignees holds the identifiers of the assignee (for example name,
+	// email address and account ID), any of which may match the requester.
+	Assignees []string
+}
+
+// ticketTracker looks up tickets in an issue tracker.
+type ticketTracker interface {
+	ticket(key string) (*ticket, error)
+}
+
+// newTicketTracker returns the tracker configured by ticket_tracker.
+func newTicketTracker(cfg *configStorageEntry) (ticketTracker, error) {
+
+	if cfg.TicketTrackerURL == "" {
+		return nil, errors.New("ticket_tracker_url is not configured")
+	}
+
+	switch cfg.TicketTracker {
+	case ticketTrackerJira:
+		return jiraTracker{baseURL: strings.TrimSuffix(cfg.TicketTrackerURL, "/"), username: cfg.TicketTrackerUsername, token: cfg.TicketTrackerToken}, nil
+	case ticketTrackerHTTP:
+		return httpTracker{baseURL: strings.TrimSuffix(cfg.TicketTrackerURL, "/"), token: cfg.TicketTrackerToken}, nil
+	case "":
+		return nil, errors.New("ticket_tracker is not configured")
+	}
+
+	return nil, errors.Errorf("unsupported ticket_tracker %q", cfg.TicketTracker)
+}
+
+// jiraTracker looks up issues with the Jira REST API.
+type jiraTracker struct {
+	baseURL, username, token string
+}
+
+func (t jiraTracker) ticket(key string) (*ticket, error) {
+
+	var issue struct {
+		Key    string `json:"key"`
+		Fields struct {
+			Status struct {
+				Name           string `json:"name"`
+				StatusCategory struct {
+					Key string `json:"key"`
+				} `json:"statusCategory"`
+			} `json:"status"`
+			Assignee *struct {
+				Name         string `json:"name"`
+				Key          string `json:"key"`
+				AccountID    string `json:"accountId"`
+				EmailAddress string `json:"emailAddress"`
+			} `json:"assignee"`
+		} `json:"fields"`
+	}
+
+	req, err := http.NewRequest("GET", t.baseURL+"/rest/api/2/issue/"+url.PathEscape(key)+"?fields=status,assignee", nil)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to create Jira request")
+	}
+	if t.username != "" {
+		req.SetBasicAuth(t.username, t.token)
+	} else if t.token != "" {
+		req.Header.Set("Authorization", "Bearer "+t.token)
+	}
+
+	if err = getTicketJSON(req, key, &issue); err != nil {
+		return nil, err
+	}
+
+	tk := &ticket{
+		Key:  issue.Key,
+		URL:  t.baseURL + "/browse/" + url.PathEscape(issue.Key),
+		Open: issue.Fields.Status.StatusCategory.Key != "done",
+	}
+	if a := issue.Fields.Assignee; a != nil {
+		tk.Assignees = []string{a.Name, a.Key, a.AccountID, a.EmailAddress}
+	}
+
+	return tk, nil
+}
+
+// httpTracker looks up tickets with a generic HTTP JSON API, which returns
+// {"key": "...", "url": "...", "open": true, "assignee": "..."} for GET
+// <base URL>/<key>.
+type httpTracker struct {
+	baseURL, token string
+}
+
+func (t httpTracker) ticket(key string) (*ticket, error) {
+
+	var resp struct {
+		Key      string `json:"key"`
+		URL      string `json:"url"`
+		Open     bool   `json:"open"`
+		Assignee string `json:"assignee"`
+	}
+
+	req, err := http.NewRequest("GET", t.baseURL+"/"+url.PathEscape(key), nil)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to create ticket tracker request")
+	}
+	if t.token != "" {
+		req.Header.Set("Authorization", "Bearer "+t.token)
+	}
+
+	if err = getTicketJSON(req, key, &resp); err != nil {
+		return nil, err
+	}
+
+	if resp.Key == "" {
+		resp.Key = key
+	}
+
+	return &ticket{Key: resp.Key, URL: resp.URL, Open: resp.Open, Assignees: []string{resp.Assignee}}, nil
+}
+
+func getTicketJSON(req *http.Request, key string, v interface{}) error {
+
+	req.Header.Set("Accept", "application/json")
+
+	client := &http.Client{Timeout: ticketTrackerTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return errors.Wrapf(err, "failed to look up ticket %q", key)
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return errors.Errorf("ticket %q does not exist", key)
+	case resp.StatusCode != http.StatusOK:
+		return errors.Errorf("failed to look up ticket %q: %s", key, resp.Status)
+	}
+
+	if err = json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return errors.Wrapf(err, "failed to decode ticket %q", key)
+	}
+
+	return nil
+}
+
+// validateTicket checks the requested ticket against the role's
+// ticket_pattern and, if ticket_validate is set, that it exists, is open and
+// is assigned to the requester.
+func (b *backend) validateTicket(cfg *configStorageEntry, role *roleStorageEntry, key, requesterID string) (*ticket, error) {
+
+	if key == "" {
+		if role.TicketPattern != "" || role.TicketValidate {
+			return nil, errors.New("field 'ticket' is mandatory")
+		}
+		return nil, nil
+	}
+
+	if role.TicketPattern != "" {
+		re, err := regexp.Compile("^(?:" + role.TicketPattern + ")$")
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid ticket_pattern")
+		}
+		if !re.MatchString(key) {
+			return nil, errors.Errorf("ticket %q does not match %q", key, role.TicketPattern)
+		}
+	}
+
+	if !role.TicketValidate {
+		return &ticket{Key: key}, nil
+	}
+
+	tracker, err := b.newTicketTracker(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	tk, err := tracker.ticket(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if !tk.Open {
+		return nil, errors.Errorf("ticket %q is not open", key)
+	}
+
+	for _, a := range tk.Assignees {
+		if a != "" && strings.ToLower(a) == strings.ToLower(requesterID) {
+			return tk, nil
+		}
+	}
+
+	return nil, errors.New(fmt.Sprintf("ticket %q is not assigned to %q", key, requesterID))
+}
+
+// ticketLink returns the ticket as Slack link, or its key if it has no URL.
+func ticketLink(key, url string) string {
+	if url == "" {
+		return key
+	}
+	return fmt.Sprintf("<%s|%s>", url, key)
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/pkg/errors"
+)
+
+type stubTracker map[string]*ticket
+
+func (t stubTracker) ticket(key string) (*ticket, error) {
+	if tk, ok := t[key]; ok {
+		return tk, nil
+	}
+	return nil, errors.Errorf("ticket %q does not exist", key)
+}
+
+func TestTracker_Jira(t *testing.T) {
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, ok := r.BasicAuth(); !ok || user != "bot" || pass != "token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var category string
+		switch r.URL.Path {
+		case "/rest/api/2/issue/INC-1":
+			category = "indeterminate"
+		case "/rest/api/2/issue/INC-2":
+			category = "done"
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"key": r.URL.Path[len("/rest/api/2/issue/"):],
+			"fields": map[string]interface{}{
+				"status":   map[string]interface{}{"name": "In Progress", "statusCategory": map[string]interface{}{"key": category}},
+				"assignee": map[string]interface{}{"name": "bob", "emailAddress": "bob@yolt.com"},
+			},
+		})
+	}))
+	defer srv.Close()
+
+	tracker, err := newTicketTracker(&configStorageEntry{TicketTracker: ticketTrackerJira, TicketTrackerURL: srv.URL + "/", TicketTrackerUsername: "bot", TicketTrackerToken: "token"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tk, err := tracker.ticket("INC-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !tk.Open || tk.URL != srv.URL+"/browse/INC-1" || tk.Assignees[3] != "bob@yolt.com" {
+		t.Fatalf("Unexpected ticket: %#v\n", tk)
+	}
+
+	if tk, err = tracker.ticket("INC-2"); err != nil || tk.Open {
+		t.Fatalf("Expected closed ticket, got %#v %v\n", tk, err)
+	}
+	if _, err = tracker.ticket("INC-3"); err == nil {
+		t.Fatalf("Expected error for unknown ticket\n")
+	}
+}
+
+func TestTracker_Request(t *testing.T) {
+	b, storage := getBackendWithEntities(t, "http://127.0.0.1:0")
+	ctx := context.Background()
+
+	b.newTicketTracker = func(cfg *configStorageEntry) (ticketTracker, error) {
+		return stubTracker{
+			"INC-1": {Key: "INC-1", URL: "https://jira/browse/INC-1", Open: true, Assignees: []string{"bob"}},
+			"INC-2": {Key: "INC-2", Open: false, Assignees: []string{"bob"}},
+			"INC-3": {Key: "INC-3", Open: true, Assignees: []string{"alice"}},
+		}, nil
+	}
+
+	role := &roleStorageEntry{SecretPath: "secret/admin", MinApprovers: 1, MinRejecters: 1, TicketPattern: "INC-[0-9]+", TicketValidate: true}
+	if err := b.roleAccessor.put(ctx, storage, role, "admin"); err != nil {
+		t.Fatal(err)
+	}
+
+	request := func(ticket string) *logical.Response {
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "request/admin",
+			Storage:   storage,
+			EntityID:  "bob",
+			Data:      map[string]interface{}{"reason": "incident", "ticket": ticket},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	for _, tk := range []string{"", "CHG-1", "INC-2", "INC-3", "INC-4"} {
+		if resp := request(tk); resp == nil || !resp.IsError() {
+			t.Fatalf("Expected error for ticket %q, got %#v\n", tk, resp)
+		}
+	}
+
+	resp := request("INC-1")
+	if resp == nil || resp.IsError() {
+		t.Fatalf("Expected request with valid ticket, got %#v\n", resp)
+	}
+
+	sr, err := b.request(ctx, storage, "admin", resp.Data["nonce"].(string))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sr.Ticket != "INC-1" || sr.TicketURL != "https://jira/browse/INC-1" {
+		t.Fatalf("Expected ticket to be stored, got %#v\n", sr)
+	}
+}
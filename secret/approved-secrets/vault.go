@@ -2,13 +2,10 @@ package main
 
 import (
 	"context"
-	"fmt"
 	"path"
 	"strings"
 
-	uuid "github.com/hashicorp/go-uuid"
 	"github.com/hashicorp/vault/api"
-	"github.com/hashicorp/vault/sdk/helper/parseutil"
 	"github.com/pkg/errors"
 )
 
@@ -33,46 +30,22 @@ func newVaultClient(ctx context.Context, vaultAddr, vaultToken string) (*api.Cli
 	return clt, nil
 }
 
+// createClientToken creates a token with the mount's managed token role for
+// entityAlias.
 func createClientToken(clt *api.Client,
+	tokenRole string,
 	tokenData map[string]interface{},
 	entityAlias string) (*api.Secret, error) {
 
-	var policies []string
-	if policiesRaw, ok := tokenData["policies"]; ok {
-		policies, _ = parseutil.ParseCommaStringSlice(policiesRaw)
-	} else {
+	if _, ok := tokenData["policies"]; !ok {
 		return nil, errors.Errorf("expected 'policies' in secret data, got: %s", tokenData)
 	}
 
 	entityAlias = strings.ToLower(entityAlias)
-	data := map[string]interface{}{
-		"allowed_policies":       policies,
-		"allowed_entity_aliases": entityAlias,
-		"orphan":                 true,
-		"renewable":              false,
-	}
-
-	uuid, err := uuid.GenerateUUID()
-	if err != nil {
-		return nil, errors.Wrap(err, "failed to create uuid")
-	}
-
-	role := fmt.Sprintf("pagerduty-secrets-%s", uuid)
-	vaultPath := path.Join("/auth/token/roles", role)
-	secret, err := clt.Logical().Write(vaultPath, data)
-	if err != nil {
-		return nil, errors.Wrap(err, "failed to create one-time token policy")
-	}
-
-	defer func(path string) {
-		clt.Logical().Delete(path)
-	}(vaultPath)
-
-	vaultPath = path.Join("/auth/token/create", role)
 	tokenData["display_name"] = entityAlias
 	tokenData["entity_alias"] = entityAlias
 
-	secret, err = clt.Logical().Write(vaultPath, tokenData)
+	secret, err := clt.Logical().Write(path.Join("/auth/token/create", tokenRole), tokenData)
 	if err != nil {
 		return nil, errors.Wrapf(err, "failed to create Vault token for issuing secret for: %s", entityAlias)
 	}